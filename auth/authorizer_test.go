@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"urlshortner/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type stubRoles map[[2]uint]models.Role
+
+func (s stubRoles) MemberRole(ctx context.Context, workspaceID, userID uint) (models.Role, error) {
+	if workspaceID == 99 {
+		return "", errors.New("database error")
+	}
+	return s[[2]uint{workspaceID, userID}], nil
+}
+
+func TestAuthorize(t *testing.T) {
+	admin, editor, viewer, outsider := uint(1), uint(2), uint(3), uint(4)
+	teamA, teamB, broken := uint(10), uint(20), uint(99)
+
+	roles := stubRoles{
+		{teamA, admin}:  models.RoleAdmin,
+		{teamA, editor}: models.RoleEditor,
+		{teamA, viewer}: models.RoleViewer,
+		{teamB, editor}: models.RoleEditor,
+	}
+	authorizer := NewAuthorizer(roles)
+
+	tests := []struct {
+		name        string
+		principal   *Principal
+		action      Action
+		res         Resource
+		expectError error
+	}{
+		{"Viewer views stats", &Principal{UserID: &viewer}, ActionViewStats, Resource{WorkspaceID: &teamA}, nil},
+		{"Viewer cannot edit", &Principal{UserID: &viewer}, ActionEditLink, Resource{WorkspaceID: &teamA}, ErrForbidden},
+		{"Editor edits", &Principal{UserID: &editor}, ActionEditLink, Resource{WorkspaceID: &teamA}, nil},
+		{"Editor deletes", &Principal{UserID: &editor}, ActionDeleteLink, Resource{WorkspaceID: &teamA}, nil},
+		{"Editor cannot manage keys", &Principal{UserID: &editor}, ActionManageKeys, Resource{WorkspaceID: &teamA}, ErrForbidden},
+		{"Admin manages keys", &Principal{UserID: &admin}, ActionManageKeys, Resource{WorkspaceID: &teamA}, nil},
+		{"Non-member cannot view", &Principal{UserID: &outsider}, ActionViewStats, Resource{WorkspaceID: &teamA}, ErrForbidden},
+		{"Key bound to another workspace", &Principal{UserID: &editor, WorkspaceID: &teamB}, ActionEditLink, Resource{WorkspaceID: &teamA}, ErrForbidden},
+		{"Key without user in workspace", &Principal{Scopes: []string{ScopeAdmin}}, ActionViewStats, Resource{WorkspaceID: &teamA}, ErrForbidden},
+		{"Owner edits personal link", &Principal{UserID: &outsider}, ActionEditLink, Resource{OwnerID: &outsider}, nil},
+		{"Others cannot see personal link", &Principal{UserID: &editor}, ActionViewStats, Resource{OwnerID: &outsider}, ErrForbidden},
+		{"Unscoped create needs only the scope", &Principal{}, ActionCreateLink, Resource{}, nil},
+		{"Unscoped edit needs admin scope", &Principal{Scopes: []string{ScopeShorten}}, ActionEditLink, Resource{}, ErrForbidden},
+		{"Unscoped edit with admin scope", &Principal{Scopes: []string{ScopeAdmin}}, ActionEditLink, Resource{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authorizer.Authorize(context.Background(), tt.principal, tt.action, tt.res)
+			if tt.expectError != nil {
+				assert.ErrorIs(t, err, tt.expectError)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	t.Run("Lookup failure is not a denial", func(t *testing.T) {
+		err := authorizer.Authorize(context.Background(), &Principal{UserID: &editor}, ActionViewStats, Resource{WorkspaceID: &broken})
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrForbidden)
+	})
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func signToken(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := b64(header) + "." + b64(payload)
+
+	hash := signingHashes[alg]
+	h := hash.New()
+	h.Write([]byte(signingInput))
+	digest := h.Sum(nil)
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, hash, digest)
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		require.NoError(t, err)
+		size := (k.Curve.Params().BitSize + 7) / 8
+		signature = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	}
+	return signingInput + "." + b64(signature)
+}
+
+func writeJWKS(t *testing.T, rsaKey *rsa.PublicKey, ecKey *ecdsa.PublicKey) string {
+	t.Helper()
+	doc := map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+			{"kty": "RSA", "kid": "enc-1", "use": "enc", "n": b64(rsaKey.N.Bytes()), "e": "AQAB"},
+		},
+	}
+	data, _ := json.Marshal(doc)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func TestJWTVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	keys, err := LoadKeySet(writeJWKS(t, &rsaKey.PublicKey, &ecKey.PublicKey))
+	require.NoError(t, err)
+	_, err = keys.Key("enc-1")
+	assert.Error(t, err, "encryption keys must not be usable for signatures")
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	verifier := NewJWTVerifier(keys, "https://id.example.com", "urlshortner")
+	verifier.now = func() time.Time { return now }
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":   "https://id.example.com",
+			"aud":   []string{"urlshortner", "other"},
+			"sub":   "user-1",
+			"email": "jane@example.com",
+			"scope": "shorten read-stats",
+			"iat":   now.Add(-time.Minute).Unix(),
+			"exp":   now.Add(time.Hour).Unix(),
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name        string
+		token       string
+		expectError bool
+	}{
+		{"RS256", signToken(t, "RS256", "rsa-1", rsaKey, claims(nil)), false},
+		{"ES256", signToken(t, "ES256", "ec-1", ecKey, claims(nil)), false},
+		{"String audience", signToken(t, "RS256", "rsa-1", rsaKey, claims(map[string]interface{}{"aud": "urlshortner"})), false},
+		{"Expired", signToken(t, "RS256", "rsa-1", rsaKey, claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()})), true},
+		{"Missing exp", signToken(t, "RS256", "rsa-1", rsaKey, claims(map[string]interface{}{"exp": nil})), true},
+		{"Not valid yet", signToken(t, "RS256", "rsa-1", rsaKey, claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})), true},
+		{"Wrong issuer", signToken(t, "RS256", "rsa-1", rsaKey, claims(map[string]interface{}{"iss": "https://evil.example.com"})), true},
+		{"Wrong audience", signToken(t, "RS256", "rsa-1", rsaKey, claims(map[string]interface{}{"aud": "someone-else"})), true},
+		{"Signed by unknown key", signToken(t, "RS256", "rsa-1", otherKey, claims(nil)), true},
+		{"Unknown key ID", signToken(t, "RS256", "rsa-2", rsaKey, claims(nil)), true},
+		{"Algorithm does not match key", signToken(t, "RS256", "ec-1", rsaKey, claims(nil)), true},
+		{"Unsigned", b64([]byte(`{"alg":"none"}`)) + "." + b64([]byte(`{}`)) + ".", true},
+		{"Malformed", "not-a-token", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifier.Verify(tt.token)
+			if tt.expectError {
+				assert.ErrorIs(t, err, ErrInvalidToken)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "jane@example.com", claims.String("email"))
+				assert.Equal(t, []string{"shorten", "read-stats"}, claims.Strings("scope"))
+			}
+		})
+	}
+}
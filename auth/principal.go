@@ -0,0 +1,44 @@
+package auth
+
+const (
+	ScopeShorten   = "shorten"
+	ScopeReadStats = "read-stats"
+	ScopeAdmin     = "admin"
+)
+
+// Scopes lists every scope that can be granted to an API caller.
+var Scopes = []string{ScopeShorten, ScopeReadStats, ScopeAdmin}
+
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal is the authenticated caller of an API request.
+type Principal struct {
+	APIKeyID uint
+	// Name is the name of the API key, or the subject of the token.
+	Name string
+	// Issuer is the issuer of the token; empty for API keys.
+	Issuer string
+	Scopes []string
+	// UserID is nil for keys that are not attributed to a user account.
+	UserID *uint
+	// WorkspaceID is the workspace the caller acts in, if any.
+	WorkspaceID *uint
+}
+
+// HasScope reports whether the principal was granted scope. The admin scope
+// implies every other scope.
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
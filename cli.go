@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"urlshortner/linkio"
+	"urlshortner/models"
+	"urlshortner/repository"
+	"urlshortner/service"
+)
+
+const usage = `usage:
+  urlshortner                                   start the HTTP server
+  urlshortner apikey create -name NAME -scopes shorten,read-stats,admin [-user ID [-workspace ID]]
+  urlshortner apikey list
+  urlshortner apikey revoke ID
+  urlshortner user create -email EMAIL [-name NAME]
+  urlshortner user list
+  urlshortner workspace create -name NAME
+  urlshortner workspace list
+  urlshortner workspace set-member -workspace ID -user ID -role viewer|editor|admin
+  urlshortner workspace members ID
+  urlshortner workspace set-fallback -workspace ID [-url URL | -page FILE]
+  urlshortner link export [-format csv|ndjson] [-user ID] [-workspace ID] [-o FILE]
+  urlshortner link import [-format csv|ndjson] [-user ID] [-workspace ID] [-rename] FILE`
+
+// commandServices are the services the admin subcommands operate on.
+type commandServices struct {
+	apiKeys    service.APIKeyService
+	users      service.UserService
+	workspaces service.WorkspaceService
+	urls       service.URLService
+}
+
+func runCommand(ctx context.Context, args []string, services commandServices) error {
+	switch args[0] {
+	case "apikey":
+		return runAPIKeyCommand(ctx, args[1:], services)
+	case "user":
+		return runUserCommand(ctx, args[1:], services.users)
+	case "workspace":
+		return runWorkspaceCommand(ctx, args[1:], services.workspaces)
+	case "link":
+		return runLinkCommand(ctx, args[1:], services.urls)
+	default:
+		return errors.New(usage)
+	}
+}
+
+func runAPIKeyCommand(ctx context.Context, args []string, services commandServices) error {
+	apiKeys := services.apiKeys
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+		name := flags.String("name", "", "human readable name of the key")
+		scopes := flags.String("scopes", "", "comma-separated list of scopes")
+		userID := flags.Uint("user", 0, "ID of the user the key acts as")
+		workspaceID := flags.Uint("workspace", 0, "ID of the workspace the key acts in")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *name == "" {
+			return errors.New("apikey create: -name is required")
+		}
+
+		var owner, workspace *uint
+		if *userID != 0 {
+			user, err := services.users.GetUser(ctx, *userID)
+			if err != nil {
+				return fmt.Errorf("user %d: %w", *userID, err)
+			}
+			owner = &user.ID
+		}
+		if *workspaceID != 0 {
+			if owner == nil {
+				return errors.New("apikey create: -workspace requires -user")
+			}
+			role, err := services.workspaces.MemberRole(ctx, *workspaceID, *owner)
+			if err != nil {
+				return err
+			}
+			if role == "" {
+				return fmt.Errorf("user %d is not a member of workspace %d", *owner, *workspaceID)
+			}
+			workspace = workspaceID
+		}
+
+		key, rawKey, err := apiKeys.CreateKey(ctx, *name, strings.Split(*scopes, ","), owner, workspace)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created API key %d (%s) with scopes %s\n", key.ID, key.Name, key.Scopes)
+		fmt.Printf("Key: %s\n", rawKey)
+		fmt.Println("Store it now, it cannot be shown again.")
+		return nil
+
+	case "list":
+		keys, err := apiKeys.ListKeys(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tPREFIX\tSCOPES\tUSER\tWORKSPACE\tLAST USED\tREVOKED")
+		for _, key := range keys {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				key.ID, key.Name, key.Prefix, key.Scopes, formatID(key.UserID), formatID(key.WorkspaceID),
+				formatTime(key.LastUsedAt), formatTime(key.RevokedAt))
+		}
+		return w.Flush()
+
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New("usage: urlshortner apikey revoke ID")
+		}
+		id, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid key id %q", args[1])
+		}
+		if err := apiKeys.RevokeKey(ctx, uint(id)); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked API key %d\n", id)
+		return nil
+
+	default:
+		return errors.New(usage)
+	}
+}
+
+func runUserCommand(ctx context.Context, args []string, users service.UserService) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("user create", flag.ContinueOnError)
+		email := flags.String("email", "", "email address of the user")
+		name := flags.String("name", "", "display name of the user")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		user, err := users.CreateUser(ctx, *email, *name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created user %d (%s)\n", user.ID, user.Email)
+		return nil
+
+	case "list":
+		list, err := users.ListUsers(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tEMAIL\tNAME\tCREATED")
+		for _, user := range list {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", user.ID, user.Email, user.Name, user.CreatedAt.Format(time.RFC3339))
+		}
+		return w.Flush()
+
+	default:
+		return errors.New(usage)
+	}
+}
+
+func runWorkspaceCommand(ctx context.Context, args []string, workspaces service.WorkspaceService) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("workspace create", flag.ContinueOnError)
+		name := flags.String("name", "", "name of the workspace")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		workspace, err := workspaces.CreateWorkspace(ctx, *name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created workspace %d (%s)\n", workspace.ID, workspace.Name)
+		return nil
+
+	case "list":
+		list, err := workspaces.ListWorkspaces(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tCREATED")
+		for _, workspace := range list {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", workspace.ID, workspace.Name, workspace.CreatedAt.Format(time.RFC3339))
+		}
+		return w.Flush()
+
+	case "set-member":
+		flags := flag.NewFlagSet("workspace set-member", flag.ContinueOnError)
+		workspaceID := flags.Uint("workspace", 0, "ID of the workspace")
+		userID := flags.Uint("user", 0, "ID of the user")
+		role := flags.String("role", "", "viewer, editor or admin")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if err := workspaces.SetMember(ctx, *workspaceID, *userID, models.Role(*role)); err != nil {
+			return err
+		}
+		fmt.Printf("User %d is now %s of workspace %d\n", *userID, *role, *workspaceID)
+		return nil
+
+	case "set-fallback":
+		flags := flag.NewFlagSet("workspace set-fallback", flag.ContinueOnError)
+		workspaceID := flags.Uint("workspace", 0, "ID of the workspace")
+		fallbackURL := flags.String("url", "", "URL to send visitors of dead links to")
+		pagePath := flags.String("page", "", "HTML file to show visitors of dead links")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		var page string
+		if *pagePath != "" {
+			content, err := os.ReadFile(*pagePath)
+			if err != nil {
+				return err
+			}
+			page = string(content)
+		}
+		if err := workspaces.SetFallback(ctx, *workspaceID, *fallbackURL, page); err != nil {
+			return err
+		}
+		if *fallbackURL == "" && page == "" {
+			fmt.Printf("Workspace %d now uses the default fallback\n", *workspaceID)
+		} else {
+			fmt.Printf("Set the fallback of workspace %d\n", *workspaceID)
+		}
+		return nil
+
+	case "members":
+		if len(args) != 2 {
+			return errors.New("usage: urlshortner workspace members ID")
+		}
+		id, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid workspace id %q", args[1])
+		}
+		members, err := workspaces.ListMembers(ctx, uint(id))
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "USER\tROLE\tSINCE")
+		for _, member := range members {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", member.UserID, member.Role, member.CreatedAt.Format(time.RFC3339))
+		}
+		return w.Flush()
+
+	default:
+		return errors.New(usage)
+	}
+}
+
+func runLinkCommand(ctx context.Context, args []string, urls service.URLService) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	// Links are those of the workspace if given, else of the user, else
+	// those created by keys without a user
+	flags := flag.NewFlagSet("link "+args[0], flag.ContinueOnError)
+	format := flags.String("format", linkio.FormatCSV, "csv or ndjson")
+	userID := flags.Uint("user", 0, "ID of the user owning the links")
+	workspaceID := flags.Uint("workspace", 0, "ID of the workspace of the links")
+	var output *string
+	var rename *bool
+	switch args[0] {
+	case "export":
+		output = flags.String("o", "", "file to write to instead of standard output")
+	case "import":
+		rename = flags.Bool("rename", false, "import links whose code is taken under a new code")
+	default:
+		return errors.New(usage)
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	var owner, workspace *uint
+	if *userID != 0 {
+		owner = userID
+	}
+	if *workspaceID != 0 {
+		workspace = workspaceID
+	}
+
+	if args[0] == "export" {
+		out := os.Stdout
+		if *output != "" {
+			file, err := os.Create(*output)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			out = file
+		}
+		w, err := linkio.NewWriter(out, *format)
+		if err != nil {
+			return err
+		}
+		scope := repository.URLScope{OwnerID: owner}
+		if workspace != nil {
+			scope = repository.URLScope{WorkspaceID: workspace}
+		}
+		return urls.ExportURLs(ctx, w, scope)
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("usage: urlshortner link import [-format csv|ndjson] [-user ID] [-workspace ID] [-rename] FILE")
+	}
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r, err := linkio.NewReader(file, *format)
+	if err != nil {
+		return err
+	}
+
+	report, err := urls.ImportURLs(ctx, r, service.ImportOptions{OwnerID: owner, WorkspaceID: workspace, RenameConflicts: *rename})
+	if report != nil {
+		fmt.Printf("Imported %d links, %d conflicts, %d errors\n", report.Imported, len(report.Conflicts), len(report.Errors))
+	}
+	if err != nil {
+		return err
+	}
+	if len(report.Conflicts) == 0 && len(report.Errors) == 0 {
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LINE\tSHORT CODE\tOUTCOME")
+	for _, conflict := range report.Conflicts {
+		outcome := "skipped, code taken"
+		if conflict.NewShortCode != "" {
+			outcome = "code taken, imported as " + conflict.NewShortCode
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", conflict.Line, conflict.ShortCode, outcome)
+	}
+	for _, failure := range report.Errors {
+		fmt.Fprintf(w, "%d\t\t%v\n", failure.Line, failure.Err)
+	}
+	return w.Flush()
+}
+
+func formatID(id *uint) string {
+	if id == nil {
+		return "-"
+	}
+	return strconv.FormatUint(uint64(*id), 10)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
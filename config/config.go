@@ -1,13 +1,41 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"log/slog"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
 	Server struct {
 		Port string
 		Host string
+		// TrustedProxies lists the addresses or CIDR ranges whose
+		// X-Forwarded-For header is believed. Empty trusts none.
+		TrustedProxies []string
+	}
+
+	// Timeout bounds the time spent serving a request, database queries
+	// included: Redirect for visits to short links, API for API calls and
+	// Bulk for batch shortening, exports and imports. Zero means no limit.
+	Timeout struct {
+		Redirect time.Duration
+		API      time.Duration
+		Bulk     time.Duration
+	}
+
+	// Log configures the structured log written to standard error.
+	Log struct {
+		Level slog.Level
+		// Format is "json" or "text".
+		Format string
+		// SlowQuery is how long a database query may take before it is
+		// logged as slow; zero logs none.
+		SlowQuery time.Duration
 	}
 
 	Database struct {
@@ -22,6 +50,57 @@ type Config struct {
 		Length  int
 		BaseURL string
 	}
+
+	Redirect struct {
+		// StatusCode is used for links that do not choose their own.
+		StatusCode int
+		// PermanentMaxAge is the Cache-Control max-age, in seconds, sent
+		// with 301 and 308 redirects.
+		PermanentMaxAge int
+		// Interstitial shows the preview page before every redirect.
+		Interstitial bool
+	}
+
+	// Batch limits the number of URLs in one batch shorten request.
+	Batch struct {
+		MaxItems int
+	}
+
+	// Idempotency keeps the responses to requests sent with an
+	// Idempotency-Key header for TTL.
+	Idempotency struct {
+		TTL time.Duration
+	}
+
+	// Password limits failed unlock attempts on password-protected links.
+	Password struct {
+		MaxAttempts   int
+		AttemptWindow time.Duration
+	}
+
+	// Fallback is shown to browsers visiting an unknown, expired or
+	// disabled code instead of a JSON error: a redirect to URL, or Page,
+	// the HTML read from the file named by FALLBACK_PAGE.
+	Fallback struct {
+		URL  string
+		Page string
+	}
+
+	// GeoIP resolves visitors' countries when DatabasePath names a
+	// MaxMind-format database file.
+	GeoIP struct {
+		DatabasePath string
+	}
+
+	// JWT authentication is enabled when JWKSSource is set.
+	JWT struct {
+		JWKSSource     string
+		Issuer         string
+		Audience       string
+		UserClaim      string
+		WorkspaceClaim string
+		ScopeClaim     string
+	}
 }
 
 func LoadConfig() (*Config, error) {
@@ -29,6 +108,29 @@ func LoadConfig() (*Config, error) {
 
 	cfg.Server.Port = getEnv("SERVER_PORT", "8080")
 	cfg.Server.Host = getEnv("SERVER_HOST", "localhost")
+	cfg.Server.TrustedProxies = getEnvList("TRUSTED_PROXIES")
+
+	if err := cfg.Log.Level.UnmarshalText([]byte(getEnv("LOG_LEVEL", "info"))); err != nil {
+		return nil, fmt.Errorf("LOG_LEVEL: %w", err)
+	}
+	cfg.Log.Format = getEnv("LOG_FORMAT", "json")
+	if cfg.Log.Format != "json" && cfg.Log.Format != "text" {
+		return nil, fmt.Errorf("LOG_FORMAT must be json or text, got %q", cfg.Log.Format)
+	}
+	var err error
+	if cfg.Log.SlowQuery, err = getEnvDuration("LOG_SLOW_QUERY", 200*time.Millisecond); err != nil {
+		return nil, err
+	}
+
+	if cfg.Timeout.Redirect, err = getEnvDuration("REDIRECT_TIMEOUT", 2*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.Timeout.API, err = getEnvDuration("API_TIMEOUT", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.Timeout.Bulk, err = getEnvDuration("BULK_TIMEOUT", 5*time.Minute); err != nil {
+		return nil, err
+	}
 
 	cfg.Database.Host = getEnv("DB_HOST", "localhost")
 	cfg.Database.Port = getEnv("DB_PORT", "3306")
@@ -39,6 +141,73 @@ func LoadConfig() (*Config, error) {
 	cfg.ShortURL.Length = 6
 	cfg.ShortURL.BaseURL = "http://localhost:" + cfg.Server.Port
 
+	if cfg.Redirect.StatusCode, err = getEnvInt("REDIRECT_STATUS", 302); err != nil {
+		return nil, err
+	}
+	switch cfg.Redirect.StatusCode {
+	case 301, 302, 307, 308:
+	default:
+		return nil, fmt.Errorf("REDIRECT_STATUS must be 301, 302, 307 or 308, got %d", cfg.Redirect.StatusCode)
+	}
+	if cfg.Redirect.PermanentMaxAge, err = getEnvInt("REDIRECT_PERMANENT_MAX_AGE", 86400); err != nil {
+		return nil, err
+	}
+
+	if cfg.Redirect.Interstitial, err = getEnvBool("REDIRECT_INTERSTITIAL", false); err != nil {
+		return nil, err
+	}
+
+	if cfg.Batch.MaxItems, err = getEnvInt("SHORTEN_BATCH_MAX", 1000); err != nil {
+		return nil, err
+	}
+	if cfg.Batch.MaxItems <= 0 {
+		return nil, fmt.Errorf("SHORTEN_BATCH_MAX must be positive, got %d", cfg.Batch.MaxItems)
+	}
+
+	if cfg.Idempotency.TTL, err = getEnvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.Idempotency.TTL <= 0 {
+		return nil, fmt.Errorf("IDEMPOTENCY_KEY_TTL must be positive, got %v", cfg.Idempotency.TTL)
+	}
+
+	if cfg.Password.MaxAttempts, err = getEnvInt("PASSWORD_MAX_ATTEMPTS", 5); err != nil {
+		return nil, err
+	}
+	if cfg.Password.MaxAttempts <= 0 {
+		return nil, fmt.Errorf("PASSWORD_MAX_ATTEMPTS must be positive, got %d", cfg.Password.MaxAttempts)
+	}
+	if cfg.Password.AttemptWindow, err = getEnvDuration("PASSWORD_ATTEMPT_WINDOW", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.Password.AttemptWindow <= 0 {
+		return nil, fmt.Errorf("PASSWORD_ATTEMPT_WINDOW must be positive, got %v", cfg.Password.AttemptWindow)
+	}
+
+	cfg.Fallback.URL = getEnv("FALLBACK_URL", "")
+	if path := getEnv("FALLBACK_PAGE", ""); path != "" {
+		if cfg.Fallback.URL != "" {
+			return nil, errors.New("set only one of FALLBACK_URL and FALLBACK_PAGE")
+		}
+		page, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("FALLBACK_PAGE: %w", err)
+		}
+		cfg.Fallback.Page = string(page)
+	}
+
+	cfg.GeoIP.DatabasePath = getEnv("GEOIP_DATABASE", "")
+
+	cfg.JWT.JWKSSource = getEnv("JWT_JWKS", "")
+	cfg.JWT.Issuer = getEnv("JWT_ISSUER", "")
+	cfg.JWT.Audience = getEnv("JWT_AUDIENCE", "")
+	cfg.JWT.UserClaim = getEnv("JWT_USER_CLAIM", "email")
+	cfg.JWT.WorkspaceClaim = getEnv("JWT_WORKSPACE_CLAIM", "workspace_id")
+	cfg.JWT.ScopeClaim = getEnv("JWT_SCOPE_CLAIM", "scope")
+	if cfg.JWT.JWKSSource != "" && (cfg.JWT.Issuer == "" || cfg.JWT.Audience == "") {
+		return nil, errors.New("JWT_ISSUER and JWT_AUDIENCE are required when JWT_JWKS is set")
+	}
+
 	return cfg, nil
 }
 
@@ -48,3 +217,50 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvList splits a comma-separated variable, dropping empty items.
+func getEnvList(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getEnvInt(key string, defaultValue int) (int, error) {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return n, nil
+}
+
+func getEnvBool(key string, defaultValue bool) (bool, error) {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", key, err)
+	}
+	return b, nil
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+	return d, nil
+}
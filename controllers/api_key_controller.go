@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"urlshortner/auth"
+	"urlshortner/models"
+	"urlshortner/problem"
+	"urlshortner/service"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// APIKeyController lets workspace admins manage the keys of their workspace.
+type APIKeyController struct {
+	keyService service.APIKeyService
+	authorizer auth.Authorizer
+}
+
+func NewAPIKeyController(keyService service.APIKeyService, authorizer auth.Authorizer) *APIKeyController {
+	return &APIKeyController{
+		keyService: keyService,
+		authorizer: authorizer,
+	}
+}
+
+type apiKeyResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	UserID     *uint      `json:"user_id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+func toAPIKeyResponse(key *models.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.ScopeList(),
+		UserID:     key.UserID,
+		CreatedAt:  key.CreatedAt,
+		LastUsedAt: key.LastUsedAt,
+		RevokedAt:  key.RevokedAt,
+	}
+}
+
+// workspace returns the caller's workspace after checking that they may
+// manage its keys.
+func (c *APIKeyController) workspace(ctx *gin.Context) (*auth.Principal, bool) {
+	principal := currentPrincipal(ctx)
+	if principal.WorkspaceID == nil {
+		problem.Write(ctx, http.StatusForbidden, problem.Forbidden, "API key is not bound to a workspace")
+		return nil, false
+	}
+	if !authorize(ctx, c.authorizer, auth.ActionManageKeys, principal.Space()) {
+		return nil, false
+	}
+	return principal, true
+}
+
+func (c *APIKeyController) CreateKey(ctx *gin.Context) {
+	principal, ok := c.workspace(ctx)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name   string   `json:"name" binding:"required"`
+		Scopes []string `json:"scopes" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid request")
+		return
+	}
+
+	// Nobody can hand out more than they hold themselves.
+	for _, scope := range request.Scopes {
+		if !principal.HasScope(scope) {
+			problem.Write(ctx, http.StatusForbidden, problem.Forbidden, "Cannot grant scope "+scope)
+			return
+		}
+	}
+
+	key, rawKey, err := c.keyService.CreateKey(ctx.Request.Context(), request.Name, request.Scopes, principal.UserID, principal.WorkspaceID)
+	if errors.Is(err, service.ErrInvalidScope) {
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid scopes")
+		return
+	}
+	if err != nil {
+		problem.Write(ctx, http.StatusInternalServerError, problem.Internal, "Failed to create API key")
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"api_key": toAPIKeyResponse(key),
+		"key":     rawKey,
+	})
+}
+
+func (c *APIKeyController) ListKeys(ctx *gin.Context) {
+	principal, ok := c.workspace(ctx)
+	if !ok {
+		return
+	}
+
+	keys, err := c.keyService.ListWorkspaceKeys(ctx.Request.Context(), *principal.WorkspaceID)
+	if err != nil {
+		problem.Write(ctx, http.StatusInternalServerError, problem.Internal, "Failed to list API keys")
+		return
+	}
+
+	items := make([]apiKeyResponse, 0, len(keys))
+	for i := range keys {
+		items = append(items, toAPIKeyResponse(&keys[i]))
+	}
+	ctx.JSON(http.StatusOK, gin.H{"api_keys": items})
+}
+
+func (c *APIKeyController) RevokeKey(ctx *gin.Context) {
+	principal, ok := c.workspace(ctx)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		problem.Write(ctx, http.StatusNotFound, problem.NotFound, "API key not found")
+		return
+	}
+
+	// Keys of other workspaces are reported as missing, not forbidden.
+	key, err := c.keyService.GetKey(ctx.Request.Context(), uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) ||
+		(err == nil && (key.WorkspaceID == nil || *key.WorkspaceID != *principal.WorkspaceID)) {
+		problem.Write(ctx, http.StatusNotFound, problem.NotFound, "API key not found")
+		return
+	}
+	if err != nil {
+		problem.Write(ctx, http.StatusInternalServerError, problem.Internal, "Failed to revoke API key")
+		return
+	}
+
+	if err := c.keyService.RevokeKey(ctx.Request.Context(), key.ID); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		problem.Write(ctx, http.StatusInternalServerError, problem.Internal, "Failed to revoke API key")
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
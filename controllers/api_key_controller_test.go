@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"urlshortner/auth"
+	"urlshortner/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+type MockAPIKeyService struct {
+	mock.Mock
+}
+
+func (m *MockAPIKeyService) CreateKey(ctx context.Context, name string, scopes []string, userID, workspaceID *uint) (*models.APIKey, string, error) {
+	args := m.Called(name, scopes, userID, workspaceID)
+	if args.Get(0) == nil {
+		return nil, "", args.Error(2)
+	}
+	return args.Get(0).(*models.APIKey), args.String(1), args.Error(2)
+}
+
+func (m *MockAPIKeyService) Authenticate(ctx context.Context, rawKey string) (*models.APIKey, error) {
+	args := m.Called(rawKey)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) GetKey(ctx context.Context, id uint) (*models.APIKey, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) ListKeys(ctx context.Context) ([]models.APIKey, error) {
+	args := m.Called()
+	return args.Get(0).([]models.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) ListWorkspaceKeys(ctx context.Context, workspaceID uint) ([]models.APIKey, error) {
+	args := m.Called(workspaceID)
+	return args.Get(0).([]models.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) RevokeKey(ctx context.Context, id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func setupTestKeyController(principal *auth.Principal) (*MockAPIKeyService, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+	mockService := new(MockAPIKeyService)
+	roles := stubRoles{{1, 1}: models.RoleAdmin, {1, 2}: models.RoleEditor}
+	controller := NewAPIKeyController(mockService, auth.NewAuthorizer(roles))
+
+	router := gin.New()
+	keys := router.Group("/api/v1/keys", withPrincipal(principal))
+	keys.POST("", controller.CreateKey)
+	keys.GET("", controller.ListKeys)
+	keys.DELETE("/:id", controller.RevokeKey)
+	return mockService, router
+}
+
+func TestCreateKeyEndpoint(t *testing.T) {
+	adminID, editorID, workspaceID := uint(1), uint(2), uint(1)
+
+	tests := []struct {
+		name           string
+		principal      *auth.Principal
+		scopes         []string
+		setupMock      func(*MockAPIKeyService)
+		expectedStatus int
+	}{
+		{
+			name:      "Workspace admin creates key",
+			principal: &auth.Principal{UserID: &adminID, WorkspaceID: &workspaceID, Scopes: []string{"admin"}},
+			scopes:    []string{"shorten"},
+			setupMock: func(m *MockAPIKeyService) {
+				m.On("CreateKey", "ci", []string{"shorten"}, &adminID, &workspaceID).
+					Return(&models.APIKey{ID: 5, Name: "ci", Scopes: "shorten"}, "us_secret", nil)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Editor cannot manage keys",
+			principal:      &auth.Principal{UserID: &editorID, WorkspaceID: &workspaceID, Scopes: []string{"admin"}},
+			scopes:         []string{"shorten"},
+			setupMock:      func(m *MockAPIKeyService) {},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Cannot grant scopes the caller lacks",
+			principal:      &auth.Principal{UserID: &adminID, WorkspaceID: &workspaceID, Scopes: []string{"shorten"}},
+			scopes:         []string{"read-stats"},
+			setupMock:      func(m *MockAPIKeyService) {},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Key outside a workspace",
+			principal:      &auth.Principal{UserID: &adminID, Scopes: []string{"admin"}},
+			scopes:         []string{"shorten"},
+			setupMock:      func(m *MockAPIKeyService) {},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService, router := setupTestKeyController(tt.principal)
+			tt.setupMock(mockService)
+
+			body, _ := json.Marshal(map[string]interface{}{"name": "ci", "scopes": tt.scopes})
+			req := httptest.NewRequest("POST", "/api/v1/keys", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusCreated {
+				var response map[string]interface{}
+				json.Unmarshal(w.Body.Bytes(), &response)
+				assert.Equal(t, "us_secret", response["key"])
+			}
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRevokeKeyEndpoint(t *testing.T) {
+	adminID, workspaceID, otherWorkspaceID := uint(1), uint(1), uint(2)
+	principal := &auth.Principal{UserID: &adminID, WorkspaceID: &workspaceID, Scopes: []string{"admin"}}
+
+	tests := []struct {
+		name           string
+		id             string
+		setupMock      func(*MockAPIKeyService)
+		expectedStatus int
+	}{
+		{
+			name: "Revokes key of own workspace",
+			id:   "5",
+			setupMock: func(m *MockAPIKeyService) {
+				m.On("GetKey", uint(5)).Return(&models.APIKey{ID: 5, WorkspaceID: &workspaceID}, nil)
+				m.On("RevokeKey", uint(5)).Return(nil)
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name: "Key of another workspace is not found",
+			id:   "6",
+			setupMock: func(m *MockAPIKeyService) {
+				m.On("GetKey", uint(6)).Return(&models.APIKey{ID: 6, WorkspaceID: &otherWorkspaceID}, nil)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "Unknown key",
+			id:   "7",
+			setupMock: func(m *MockAPIKeyService) {
+				m.On("GetKey", uint(7)).Return(nil, gorm.ErrRecordNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService, router := setupTestKeyController(principal)
+			tt.setupMock(mockService)
+
+			req := httptest.NewRequest("DELETE", "/api/v1/keys/"+tt.id, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
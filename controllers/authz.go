@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"urlshortner/auth"
+	"urlshortner/middleware"
+	"urlshortner/problem"
+	"urlshortner/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// currentPrincipal returns the authenticated caller, or an anonymous
+// principal without scopes, user or workspace.
+func currentPrincipal(ctx *gin.Context) *auth.Principal {
+	if principal, ok := middleware.CurrentPrincipal(ctx); ok {
+		return principal
+	}
+	return &auth.Principal{}
+}
+
+// authorize consults the authorizer and writes the error response if the
+// caller may not perform action on res.
+func authorize(ctx *gin.Context, authorizer auth.Authorizer, action auth.Action, res auth.Resource) bool {
+	err := authorizer.Authorize(ctx.Request.Context(), currentPrincipal(ctx), action, res)
+	if errors.Is(err, auth.ErrForbidden) {
+		problem.Write(ctx, http.StatusForbidden, problem.Forbidden, "Forbidden")
+		return false
+	}
+	if err != nil {
+		problem.Write(ctx, http.StatusInternalServerError, problem.Internal, "Failed to authorize request")
+		return false
+	}
+	return true
+}
+
+func scopeOf(res auth.Resource) repository.URLScope {
+	return repository.URLScope{WorkspaceID: res.WorkspaceID, OwnerID: res.OwnerID}
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var passwordPage = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 24rem; margin: 4rem auto; padding: 0 1rem; }
+input, button { font: inherit; padding: .5rem; width: 100%; box-sizing: border-box; margin-top: .5rem; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>Password required</h1>
+<p>This link is protected. Enter its password to continue.</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post" action="{{.Action}}">
+<input type="password" name="password" autocomplete="current-password" autofocus required>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Link preview</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 36rem; margin: 4rem auto; padding: 0 1rem; }
+.destination { word-break: break-all; background: #f4f4f4; padding: .75rem; font-family: monospace; }
+dt { font-weight: bold; margin-top: .5rem; }
+.continue { display: inline-block; margin-top: 1.5rem; padding: .5rem 1rem; background: #1a73e8; color: #fff; text-decoration: none; }
+</style>
+</head>
+<body>
+<h1>Link preview</h1>
+<p>{{.ShortURL}} leads to:</p>
+<p class="destination">{{.Destination}}</p>
+<dl>
+<dt>Domain</dt><dd>{{.Domain}}</dd>
+<dt>Created</dt><dd>{{.CreatedAt.Format "2 January 2006"}}</dd>
+<dt>Clicks</dt><dd>{{.AccessCount}}</dd>
+</dl>
+<a class="continue" href="{{.ContinueURL}}" rel="noopener noreferrer nofollow">Continue to {{.Domain}}</a>
+</body>
+</html>
+`))
+
+type previewPageData struct {
+	ShortURL    string
+	Destination string
+	Domain      string
+	CreatedAt   time.Time
+	AccessCount int
+	// ContinueURL is the destination once the visit has been counted, and
+	// the short link otherwise.
+	ContinueURL string
+}
+
+type passwordPageData struct {
+	// Action is the path the form posts to.
+	Action string
+	Error  string
+}
+
+// renderPage writes an HTML page that must not be cached, since it stands in
+// for a redirect whose outcome may change.
+func renderPage(ctx *gin.Context, status int, page *template.Template, data interface{}) {
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, data); err != nil {
+		ctx.String(http.StatusInternalServerError, "Failed to render page")
+		return
+	}
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Data(status, "text/html; charset=utf-8", buf.Bytes())
+}
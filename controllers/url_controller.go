@@ -1,8 +1,23 @@
 package controllers
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"urlshortner/auth"
 	"urlshortner/config"
+	"urlshortner/linkio"
+	"urlshortner/models"
+	"urlshortner/problem"
+	"urlshortner/qr"
+	"urlshortner/repository"
 	"urlshortner/service"
 
 	"github.com/gin-gonic/gin"
@@ -10,29 +25,75 @@ import (
 
 type URLController struct {
 	urlService service.URLService
+	authorizer auth.Authorizer
 	config     *config.Config
 }
 
-func NewURLController(urlService service.URLService, cfg *config.Config) *URLController {
+func NewURLController(urlService service.URLService, authorizer auth.Authorizer, cfg *config.Config) *URLController {
 	return &URLController{
 		urlService: urlService,
+		authorizer: authorizer,
 		config:     cfg,
 	}
 }
 
 func (c *URLController) ShortenURL(ctx *gin.Context) {
 	var request struct {
-		URL string `json:"url" binding:"required,url"`
+		URL              string             `json:"url" binding:"required"`
+		RedirectCode     int                `json:"redirect_code"`
+		Password         string             `json:"password"`
+		MaxClicks        *int               `json:"max_clicks"`
+		Preview          bool               `json:"preview"`
+		DeviceRules      models.DeviceRules `json:"device_rules"`
+		GeoRules         models.GeoRules    `json:"geo_rules"`
+		Variants         models.Variants    `json:"variants"`
+		StickyVariants   bool               `json:"sticky_variants"`
+		PassthroughPath  bool               `json:"passthrough_path"`
+		PassthroughQuery bool               `json:"passthrough_query"`
+		QueryConflict    string             `json:"query_conflict"`
+		UTMTemplate      string             `json:"utm_template"`
+		ActiveFrom       *time.Time         `json:"active_from"`
+		ActiveUntil      *time.Time         `json:"active_until"`
+		Alias            string             `json:"alias"`
+		ExpiresAt        *time.Time         `json:"expires_at"`
 	}
 
+	// The URL itself is checked by the service, which reports ErrInvalidURL
 	if err := ctx.ShouldBindJSON(&request); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid URL"})
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, err.Error())
 		return
 	}
 
-	url, err := c.urlService.ShortenURL(request.URL)
+	principal := currentPrincipal(ctx)
+	space := principal.Space()
+	if !authorize(ctx, c.authorizer, auth.ActionCreateLink, space) {
+		return
+	}
+	opts := service.ShortenOptions{
+		OwnerID:          principal.UserID,
+		WorkspaceID:      space.WorkspaceID,
+		RedirectCode:     request.RedirectCode,
+		Password:         request.Password,
+		MaxClicks:        request.MaxClicks,
+		Preview:          request.Preview,
+		DeviceRules:      request.DeviceRules,
+		GeoRules:         request.GeoRules,
+		Variants:         request.Variants,
+		StickyVariants:   request.StickyVariants,
+		PassthroughPath:  request.PassthroughPath,
+		PassthroughQuery: request.PassthroughQuery,
+		QueryConflict:    request.QueryConflict,
+		UTMTemplate:      request.UTMTemplate,
+		ActiveFrom:       request.ActiveFrom,
+		ActiveUntil:      request.ActiveUntil,
+		Alias:            request.Alias,
+		ExpiresAt:        request.ExpiresAt,
+	}
+
+	url, err := c.urlService.ShortenURL(ctx.Request.Context(), request.URL, opts)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to shorten URL"})
+		status, code, message := shortenError(err)
+		problem.Write(ctx, status, code, message)
 		return
 	}
 
@@ -40,23 +101,987 @@ func (c *URLController) ShortenURL(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"short_url": shortURL})
 }
 
+// shortenError maps an error creating or updating a link to a status,
+// problem code and message.
+func shortenError(err error) (int, string, string) {
+	switch {
+	case errors.Is(err, service.ErrInvalidURL):
+		return http.StatusBadRequest, problem.InvalidURL, "Invalid URL"
+	case errors.Is(err, service.ErrInvalidStatus):
+		return http.StatusBadRequest, problem.InvalidRequest, "Invalid status"
+	case errors.Is(err, service.ErrInvalidAlias):
+		return http.StatusBadRequest, problem.InvalidAlias, "Invalid alias"
+	case errors.Is(err, service.ErrAliasTaken):
+		return http.StatusConflict, problem.AliasTaken, "Alias is already taken"
+	case errors.Is(err, service.ErrInvalidRedirectCode):
+		return http.StatusBadRequest, problem.InvalidRequest, "Invalid redirect code"
+	case errors.Is(err, service.ErrInvalidMaxClicks):
+		return http.StatusBadRequest, problem.InvalidRequest, "Invalid max_clicks"
+	case errors.Is(err, service.ErrInvalidDeviceRule):
+		return http.StatusBadRequest, problem.InvalidRequest, "Invalid device rule"
+	case errors.Is(err, service.ErrInvalidGeoRule):
+		return http.StatusBadRequest, problem.InvalidRequest, "Invalid geo rule"
+	case errors.Is(err, service.ErrInvalidVariants):
+		return http.StatusBadRequest, problem.InvalidRequest, "Invalid variants"
+	case errors.Is(err, service.ErrInvalidConflictRule):
+		return http.StatusBadRequest, problem.InvalidRequest, "Invalid query_conflict"
+	case errors.Is(err, service.ErrUTMTemplateNotFound):
+		return http.StatusBadRequest, problem.InvalidRequest, "Unknown UTM template"
+	case errors.Is(err, service.ErrInvalidActiveWindow):
+		return http.StatusBadRequest, problem.InvalidRequest, "active_until must be after active_from"
+	default:
+		return http.StatusInternalServerError, problem.Internal, "Failed to shorten URL"
+	}
+}
+
+type batchItemResponse struct {
+	URL       string `json:"url"`
+	ShortCode string `json:"short_code,omitempty"`
+	ShortURL  string `json:"short_url,omitempty"`
+	Code      string `json:"code,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ShortenBatch creates many links in one request. Each item succeeds or
+// fails on its own; the response lists the outcomes in request order.
+func (c *URLController) ShortenBatch(ctx *gin.Context) {
+	var request struct {
+		URLs []struct {
+			URL       string     `json:"url"`
+			Alias     string     `json:"alias"`
+			ExpiresAt *time.Time `json:"expires_at"`
+		} `json:"urls" binding:"required"`
+		RedirectCode int    `json:"redirect_code"`
+		UTMTemplate  string `json:"utm_template"`
+	}
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid request")
+		return
+	}
+
+	principal := currentPrincipal(ctx)
+	space := principal.Space()
+	if !authorize(ctx, c.authorizer, auth.ActionCreateLink, space) {
+		return
+	}
+
+	items := make([]service.BatchItem, len(request.URLs))
+	for i, item := range request.URLs {
+		items[i] = service.BatchItem{URL: item.URL, Alias: item.Alias, ExpiresAt: item.ExpiresAt}
+	}
+	opts := service.ShortenOptions{
+		OwnerID:      principal.UserID,
+		WorkspaceID:  space.WorkspaceID,
+		RedirectCode: request.RedirectCode,
+		UTMTemplate:  request.UTMTemplate,
+	}
+
+	results, err := c.urlService.ShortenBatch(ctx.Request.Context(), items, opts)
+	if errors.Is(err, service.ErrBatchTooLarge) {
+		problem.Write(ctx, http.StatusRequestEntityTooLarge, problem.TooLarge, fmt.Sprintf("At most %d URLs per batch", c.config.Batch.MaxItems))
+		return
+	}
+	if err != nil {
+		status, code, message := shortenError(err)
+		problem.Write(ctx, status, code, message)
+		return
+	}
+
+	response := make([]batchItemResponse, len(results))
+	failed := 0
+	for i, result := range results {
+		response[i].URL = items[i].URL
+		if result.Err != nil {
+			_, response[i].Code, response[i].Error = shortenError(result.Err)
+			failed++
+			continue
+		}
+		response[i].ShortCode = result.URL.ShortCode
+		response[i].ShortURL = c.config.ShortURL.BaseURL + "/" + result.URL.ShortCode
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"results":   response,
+		"succeeded": len(results) - failed,
+		"failed":    failed,
+	})
+}
+
+// RedirectURL sends the visitor to the destination of a short link. A
+// trailing "+" on the code or a "?preview=1" query shows the preview page
+// instead, without counting a visit.
 func (c *URLController) RedirectURL(ctx *gin.Context) {
 	shortCode := ctx.Param("shortCode")
-	originalURL, err := c.urlService.GetOriginalURL(shortCode)
+	preview := strings.HasSuffix(shortCode, "+") || ctx.Query("preview") == "1"
+	shortCode = strings.TrimSuffix(shortCode, "+")
+
+	if preview {
+		redirect, err := c.urlService.PreviewURL(ctx.Request.Context(), shortCode, visitOf(ctx, shortCode))
+		if err != nil {
+			c.redirectError(ctx, shortCode, err)
+			return
+		}
+		// Links that always show the interstitial are counted and
+		// disclosed below, like any other visit.
+		if !redirect.Interstitial {
+			c.renderPreview(ctx, redirect, previewContinue(ctx, shortCode, redirect.Variant))
+			return
+		}
+	}
+
+	redirect, err := c.urlService.GetOriginalURL(ctx.Request.Context(), shortCode, visitOf(ctx, shortCode))
+	if err != nil {
+		c.redirectError(ctx, shortCode, err)
+		return
+	}
+	c.rememberVariant(ctx, redirect)
+	if redirect.Interstitial {
+		c.renderPreview(ctx, redirect, redirect.Location)
+		return
+	}
+
+	// Browsers cache permanent redirects indefinitely unless told otherwise,
+	// which would make retargeting the link impossible. Shared caches
+	// cannot key on the visitor's location, so targeted links are private.
+	if redirect.Targeted {
+		ctx.Header("Vary", "User-Agent")
+	}
+	if redirect.StatusCode == http.StatusMovedPermanently || redirect.StatusCode == http.StatusPermanentRedirect {
+		ctx.Header("Cache-Control", c.permanentCacheControl(redirect, time.Now()))
+	}
+	ctx.Redirect(redirect.StatusCode, redirect.Location)
+}
+
+// permanentCacheControl is the Cache-Control of a permanent redirect. A
+// cached redirect is replayed without asking us, so links counting their
+// clicks are not cached at all, and links coming to an end only until then.
+func (c *URLController) permanentCacheControl(redirect *service.Redirect, now time.Time) string {
+	cacheability := "public"
+	if redirect.Targeted {
+		cacheability = "private"
+	}
+	maxAge := c.config.Redirect.PermanentMaxAge
+	if link := redirect.Link; link != nil {
+		if link.MaxClicks != nil {
+			return "no-store"
+		}
+		for _, end := range []*time.Time{link.ExpiresAt, link.ActiveUntil} {
+			if end != nil {
+				if left := int(end.Sub(now).Seconds()); left < maxAge {
+					maxAge = left
+				}
+			}
+		}
+	}
+	if maxAge <= 0 {
+		return "no-store"
+	}
+	return fmt.Sprintf("%s, max-age=%d", cacheability, maxAge)
+}
+
+// unlockAction is where the password form of a link posts to, keeping any
+// extra path and query to pass through.
+func unlockAction(ctx *gin.Context, shortCode string) string {
+	action := "/" + shortCode + ctx.Param("path")
+	if query := ctx.Request.URL.RawQuery; query != "" {
+		action += "?" + query
+	}
+	return action
+}
+
+// previewContinue is where the preview page of a link continues to: the
+// short link with the extra path and query that were requested, to pass
+// through, but without what asked for the preview. The variant previewed,
+// if any, goes along so that the visitor is served what they were shown.
+func previewContinue(ctx *gin.Context, shortCode, variant string) string {
+	target := "/" + shortCode + ctx.Param("path")
+	var query []string
+	for _, param := range strings.Split(ctx.Request.URL.RawQuery, "&") {
+		if param != "" && param != "preview=1" {
+			query = append(query, param)
+		}
+	}
+	if variant != "" {
+		query = append(query, previewedParam+"="+url.QueryEscape(variant))
+	}
+	if len(query) > 0 {
+		target += "?" + strings.Join(query, "&")
+	}
+	return target
+}
+
+// variantCookieMaxAge is how long, in seconds, a visitor keeps being served
+// the same variant of a link with sticky variants.
+const variantCookieMaxAge = 30 * 24 * 60 * 60
+
+// previewedParam carries the variant shown on the preview page to the
+// visit that follows it. Like qrParam, it is not passed on.
+const previewedParam = "previewed"
+
+func variantCookie(shortCode string) string {
+	return "variant_" + shortCode
+}
+
+func visitOf(ctx *gin.Context, shortCode string) service.Visit {
+	visit := service.Visit{
+		Header:   ctx.Request.Header,
+		ClientIP: ctx.ClientIP(),
+		Path:     ctx.Param("path"),
+		Query:    ctx.Request.URL.Query(),
+	}
+	visit.Variant, _ = ctx.Cookie(variantCookie(shortCode))
+	if previewed := visit.Query.Get(previewedParam); previewed != "" {
+		visit.Previewed = previewed
+		visit.Query.Del(previewedParam)
+	}
+	// The QR marker is ours, not to be passed on to the destination
+	if visit.Query.Get(qrParam) == "1" {
+		visit.Source = models.ClickSourceQR
+		visit.Query.Del(qrParam)
+	}
+	return visit
+}
+
+// rememberVariant has the visitor served the same variant next time, if the
+// link asks for it.
+func (c *URLController) rememberVariant(ctx *gin.Context, redirect *service.Redirect) {
+	if redirect.Variant == "" || redirect.Link == nil || !redirect.Link.StickyVariants {
+		return
+	}
+	shortCode := redirect.Link.ShortCode
+	secure := strings.HasPrefix(c.config.ShortURL.BaseURL, "https://")
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(variantCookie(shortCode), redirect.Variant, variantCookieMaxAge, "/"+shortCode, "", secure, true)
+}
+
+func (c *URLController) renderPreview(ctx *gin.Context, redirect *service.Redirect, continueURL string) {
+	link := redirect.Link
+	renderPage(ctx, http.StatusOK, previewPage, previewPageData{
+		ShortURL:    c.config.ShortURL.BaseURL + "/" + link.ShortCode,
+		Destination: redirect.Location,
+		Domain:      hostOf(redirect.Location, link.Domain),
+		CreatedAt:   link.CreatedAt,
+		AccessCount: link.AccessCount,
+		ContinueURL: continueURL,
+	})
+}
+
+// hostOf is the host a visitor following location ends up on, as links
+// record their domain, or fallback if location has none.
+func hostOf(location, fallback string) string {
+	parsed, err := url.Parse(location)
+	if err != nil || parsed.Host == "" {
+		return fallback
+	}
+	return strings.TrimPrefix(parsed.Host, "www.")
+}
+
+// UnlockURL handles the password form of a protected link.
+func (c *URLController) UnlockURL(ctx *gin.Context) {
+	shortCode := ctx.Param("shortCode")
+	redirect, err := c.urlService.UnlockURL(ctx.Request.Context(), shortCode, ctx.PostForm("password"), visitOf(ctx, shortCode))
+
+	var tooMany *service.TooManyAttemptsError
+	if errors.As(err, &tooMany) {
+		ctx.Header("Retry-After", strconv.Itoa(int(tooMany.RetryAfter.Seconds())+1))
+		if prefersJSON(ctx) {
+			problem.Write(ctx, http.StatusTooManyRequests, problem.RateLimited, "Too many failed attempts")
+			return
+		}
+		renderPage(ctx, http.StatusTooManyRequests, passwordPage, passwordPageData{
+			Action: unlockAction(ctx, shortCode),
+			Error:  "Too many failed attempts. Please try again later.",
+		})
+		return
+	}
+	if errors.Is(err, service.ErrWrongPassword) {
+		if prefersJSON(ctx) {
+			problem.Write(ctx, http.StatusUnauthorized, problem.WrongPassword, "Wrong password")
+			return
+		}
+		renderPage(ctx, http.StatusUnauthorized, passwordPage, passwordPageData{
+			Action: unlockAction(ctx, shortCode),
+			Error:  "Wrong password.",
+		})
+		return
+	}
 	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "URL not found"})
+		c.redirectError(ctx, shortCode, err)
+		return
+	}
+	c.rememberVariant(ctx, redirect)
+	if redirect.Interstitial {
+		c.renderPreview(ctx, redirect, redirect.Location)
+		return
+	}
+
+	// Always 303: a 307/308 would make the browser re-post the password to
+	// the destination.
+	ctx.Redirect(http.StatusSeeOther, redirect.Location)
+}
+
+func (c *URLController) redirectError(ctx *gin.Context, shortCode string, err error) {
+	if errors.Is(err, service.ErrPasswordRequired) {
+		if prefersJSON(ctx) {
+			problem.Write(ctx, http.StatusUnauthorized, problem.PasswordRequired, "URL is password protected")
+			return
+		}
+		renderPage(ctx, http.StatusOK, passwordPage, passwordPageData{Action: unlockAction(ctx, shortCode)})
+		return
+	}
+	var notActive *service.NotActiveError
+	if errors.As(err, &notActive) {
+		// Not live yet, but will be: tell clients when to come back rather
+		// than letting them cache a 404.
+		if wait := time.Until(notActive.ActiveFrom); wait > 0 {
+			ctx.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		}
+		ctx.Header("Cache-Control", "no-store")
+		problem.Write(ctx, http.StatusServiceUnavailable, problem.NotActive, "URL is not active yet",
+			gin.H{"active_from": notActive.ActiveFrom})
 		return
 	}
+	if errors.Is(err, service.ErrURLPaused) {
+		ctx.Header("Cache-Control", "no-store")
+		problem.Write(ctx, http.StatusServiceUnavailable, problem.Paused, "URL is paused")
+		return
+	}
+	switch {
+	case errors.Is(err, service.ErrURLDisabled):
+		c.deadLink(ctx, shortCode, http.StatusNotFound, problem.Disabled, "URL is disabled")
+	case errors.Is(err, service.ErrURLDeleted):
+		c.deadLink(ctx, shortCode, http.StatusGone, problem.Deleted, "URL has been deleted")
+	case errors.Is(err, service.ErrURLExpired):
+		c.deadLink(ctx, shortCode, http.StatusGone, problem.Expired, "URL has expired")
+	case errors.Is(err, service.ErrURLEnded):
+		c.deadLink(ctx, shortCode, http.StatusGone, problem.Expired, "URL is no longer active")
+	case errors.Is(err, service.ErrURLExhausted):
+		c.deadLink(ctx, shortCode, http.StatusGone, problem.ClickLimitReached, "URL has reached its click limit")
+	case errors.Is(err, service.ErrURLNotFound):
+		c.deadLink(ctx, shortCode, http.StatusNotFound, problem.NotFound, "URL not found")
+	default:
+		// The link may well exist: a 404 would be cached and mislead
+		problem.Write(ctx, http.StatusInternalServerError, problem.Internal, "Failed to resolve URL")
+	}
+}
+
+// deadLink answers a visit to a code that does not redirect. API clients get
+// the problem; browsers get the fallback URL or page, if one is set.
+func (c *URLController) deadLink(ctx *gin.Context, shortCode string, status int, code, message string) {
+	if ctx.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) != gin.MIMEHTML {
+		problem.Write(ctx, status, code, message)
+		return
+	}
+
+	fallback := c.urlService.Fallback(ctx.Request.Context(), shortCode)
+	switch {
+	case fallback.URL != "":
+		ctx.Header("Cache-Control", "no-store")
+		ctx.Redirect(http.StatusFound, fallback.URL)
+	case fallback.Page != "":
+		if fallback.FromWorkspace {
+			ctx.Header("Content-Security-Policy", "sandbox")
+		}
+		ctx.Header("Cache-Control", "no-store")
+		ctx.Data(status, "text/html; charset=utf-8", []byte(fallback.Page))
+	default:
+		problem.Write(ctx, status, code, message)
+	}
+}
 
-	ctx.Redirect(http.StatusFound, originalURL)
+// prefersJSON reports whether the client asks for JSON over the HTML pages
+// shown to browsers.
+func prefersJSON(ctx *gin.Context) bool {
+	return ctx.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON
 }
 
 func (c *URLController) GetTopDomains(ctx *gin.Context) {
-	metrics, err := c.urlService.GetTopDomains(3)
+	space := currentPrincipal(ctx).Space()
+	if !authorize(ctx, c.authorizer, auth.ActionViewStats, space) {
+		return
+	}
+
+	metrics, err := c.urlService.GetTopDomains(ctx.Request.Context(), 3, scopeOf(space))
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get metrics"})
+		problem.Write(ctx, http.StatusInternalServerError, problem.Internal, "Failed to get metrics")
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{"domains": metrics})
 }
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+type urlResponse struct {
+	ShortCode         string             `json:"short_code"`
+	ShortURL          string             `json:"short_url"`
+	OriginalURL       string             `json:"original_url"`
+	Domain            string             `json:"domain"`
+	CreatedAt         time.Time          `json:"created_at"`
+	AccessCount       int                `json:"access_count"`
+	Status            string             `json:"status"`
+	ExpiresAt         *time.Time         `json:"expires_at"`
+	ActiveFrom        *time.Time         `json:"active_from"`
+	ActiveUntil       *time.Time         `json:"active_until"`
+	RedirectCode      int                `json:"redirect_code"`
+	PasswordProtected bool               `json:"password_protected"`
+	MaxClicks         *int               `json:"max_clicks"`
+	Preview           bool               `json:"preview"`
+	DeviceRules       models.DeviceRules `json:"device_rules"`
+	GeoRules          models.GeoRules    `json:"geo_rules"`
+	Variants          models.Variants    `json:"variants"`
+	StickyVariants    bool               `json:"sticky_variants"`
+	PassthroughPath   bool               `json:"passthrough_path"`
+	PassthroughQuery  bool               `json:"passthrough_query"`
+	QueryConflict     string             `json:"query_conflict"`
+}
+
+func (c *URLController) toURLResponse(url *models.URL) urlResponse {
+	response := urlResponse{
+		ShortCode:         url.ShortCode,
+		ShortURL:          c.config.ShortURL.BaseURL + "/" + url.ShortCode,
+		OriginalURL:       url.OriginalURL,
+		Domain:            url.Domain,
+		CreatedAt:         url.CreatedAt,
+		AccessCount:       url.AccessCount,
+		Status:            url.Status,
+		ExpiresAt:         url.ExpiresAt,
+		ActiveFrom:        url.ActiveFrom,
+		ActiveUntil:       url.ActiveUntil,
+		PasswordProtected: url.PasswordHash != "",
+		MaxClicks:         url.MaxClicks,
+		Preview:           url.Preview,
+		DeviceRules:       url.DeviceRules,
+		GeoRules:          url.GeoRules,
+		Variants:          url.Variants,
+		StickyVariants:    url.StickyVariants,
+		PassthroughPath:   url.PassthroughPath,
+		PassthroughQuery:  url.PassthroughQuery,
+		QueryConflict:     url.QueryConflict,
+	}
+	if response.DeviceRules == nil {
+		response.DeviceRules = models.DeviceRules{}
+	}
+	if response.GeoRules == nil {
+		response.GeoRules = models.GeoRules{}
+	}
+	if response.QueryConflict == "" {
+		response.QueryConflict = models.QueryConflictDestination
+	}
+	if response.Variants == nil {
+		response.Variants = models.Variants{}
+	}
+	if response.RedirectCode = url.RedirectCode; response.RedirectCode == 0 {
+		response.RedirectCode = c.config.Redirect.StatusCode
+	}
+	return response
+}
+
+// loadURL fetches the link named in the path and checks that the caller may
+// perform action on it.
+func (c *URLController) loadURL(ctx *gin.Context, action auth.Action) (*models.URL, bool) {
+	url, err := c.urlService.GetURL(ctx.Request.Context(), ctx.Param("shortCode"))
+	if errors.Is(err, service.ErrURLNotFound) {
+		problem.Write(ctx, http.StatusNotFound, problem.NotFound, "URL not found")
+		return nil, false
+	}
+	if err != nil {
+		problem.Write(ctx, http.StatusInternalServerError, problem.Internal, "Failed to get URL")
+		return nil, false
+	}
+	if !authorize(ctx, c.authorizer, action, auth.ResourceOf(url)) {
+		return nil, false
+	}
+	return url, true
+}
+
+// UpdateURL changes the destination, status or expiry of a link. Sending
+// null for "expires_at", "active_from" or "active_until" removes that time.
+func (c *URLController) UpdateURL(ctx *gin.Context) {
+	var request struct {
+		URL              *string             `json:"url"`
+		Status           *string             `json:"status"`
+		ExpiresAt        json.RawMessage     `json:"expires_at"`
+		ActiveFrom       json.RawMessage     `json:"active_from"`
+		ActiveUntil      json.RawMessage     `json:"active_until"`
+		RedirectCode     *int                `json:"redirect_code"`
+		Preview          *bool               `json:"preview"`
+		DeviceRules      *models.DeviceRules `json:"device_rules"`
+		GeoRules         *models.GeoRules    `json:"geo_rules"`
+		Variants         *models.Variants    `json:"variants"`
+		StickyVariants   *bool               `json:"sticky_variants"`
+		PassthroughPath  *bool               `json:"passthrough_path"`
+		PassthroughQuery *bool               `json:"passthrough_query"`
+		QueryConflict    *string             `json:"query_conflict"`
+	}
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid request")
+		return
+	}
+
+	update := service.URLUpdate{
+		OriginalURL:      request.URL,
+		Status:           request.Status,
+		RedirectCode:     request.RedirectCode,
+		Preview:          request.Preview,
+		DeviceRules:      request.DeviceRules,
+		GeoRules:         request.GeoRules,
+		Variants:         request.Variants,
+		StickyVariants:   request.StickyVariants,
+		PassthroughPath:  request.PassthroughPath,
+		PassthroughQuery: request.PassthroughQuery,
+		QueryConflict:    request.QueryConflict,
+	}
+	var err error
+	if update.ExpiresAt, update.ClearExpiry, err = nullableTime(request.ExpiresAt); err != nil {
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid expires_at")
+		return
+	}
+	if update.ActiveFrom, update.ClearActiveFrom, err = nullableTime(request.ActiveFrom); err != nil {
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid active_from")
+		return
+	}
+	if update.ActiveUntil, update.ClearActiveUntil, err = nullableTime(request.ActiveUntil); err != nil {
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid active_until")
+		return
+	}
+
+	url, ok := c.loadURL(ctx, auth.ActionEditLink)
+	if !ok {
+		return
+	}
+
+	updated, err := c.urlService.UpdateURL(ctx.Request.Context(), url, update)
+	if err != nil {
+		status, code, message := shortenError(err)
+		if status == http.StatusInternalServerError {
+			message = "Failed to update URL"
+		}
+		problem.Write(ctx, status, code, message)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, c.toURLResponse(updated))
+}
+
+// nullableTime decodes an optional time field of a partial update: absent
+// leaves it alone, null clears it.
+func nullableTime(raw json.RawMessage) (t *time.Time, clear bool, err error) {
+	if len(raw) == 0 {
+		return nil, false, nil
+	}
+	if bytes.Equal(raw, []byte("null")) {
+		return nil, true, nil
+	}
+	err = json.Unmarshal(raw, &t)
+	return t, false, err
+}
+
+func (c *URLController) DeleteURL(ctx *gin.Context) {
+	url, ok := c.loadURL(ctx, auth.ActionDeleteLink)
+	if !ok {
+		return
+	}
+
+	if err := c.urlService.DeleteURL(ctx.Request.Context(), url); err != nil {
+		problem.Write(ctx, http.StatusInternalServerError, problem.Internal, "Failed to delete URL")
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// GetURL returns a link with all its attributes. Unlike following the
+// link, it counts no visit.
+func (c *URLController) GetURL(ctx *gin.Context) {
+	url, ok := c.loadURL(ctx, auth.ActionViewStats)
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, c.toURLResponse(url))
+}
+
+// GetURLStats breaks down the clicks on a link by variant served.
+func (c *URLController) GetURLStats(ctx *gin.Context) {
+	url, ok := c.loadURL(ctx, auth.ActionViewStats)
+	if !ok {
+		return
+	}
+
+	variants, err := c.urlService.GetVariantStats(ctx.Request.Context(), url)
+	if err != nil {
+		problem.Write(ctx, http.StatusInternalServerError, problem.Internal, "Failed to get stats")
+		return
+	}
+	sources, err := c.urlService.GetSourceStats(ctx.Request.Context(), url)
+	if err != nil {
+		problem.Write(ctx, http.StatusInternalServerError, problem.Internal, "Failed to get stats")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"short_code":   url.ShortCode,
+		"access_count": url.AccessCount,
+		"variants":     variants,
+		"sources":      sources,
+	})
+}
+
+// qrParam is added to the short URL encoded in QR codes, so that scans are
+// told apart from other visits.
+const qrParam = "qr"
+
+// GetQRCode renders the short URL of a link as a QR code, in PNG or, with
+// "format=svg", SVG. "size", "level", "margin", "fg" and "bg" override the
+// defaults of qr.DefaultOptions.
+func (c *URLController) GetQRCode(ctx *gin.Context) {
+	opts := qr.DefaultOptions()
+	var err error
+	if size := ctx.Query("size"); size != "" {
+		if opts.Size, err = strconv.Atoi(size); err != nil {
+			problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid size")
+			return
+		}
+	}
+	if level := ctx.Query("level"); level != "" {
+		opts.Level = strings.ToUpper(level)
+	}
+	if margin := ctx.Query("margin"); margin != "" {
+		if opts.Margin, err = strconv.Atoi(margin); err != nil {
+			problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid margin")
+			return
+		}
+	}
+	if fg := ctx.Query("fg"); fg != "" {
+		if opts.Foreground, err = qr.ParseColor(fg); err != nil {
+			problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid fg")
+			return
+		}
+	}
+	if bg := ctx.Query("bg"); bg != "" {
+		if opts.Background, err = qr.ParseColor(bg); err != nil {
+			problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid bg")
+			return
+		}
+	}
+	format := ctx.DefaultQuery("format", "png")
+	if format != "png" && format != "svg" {
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid format")
+		return
+	}
+
+	url, ok := c.loadURL(ctx, auth.ActionViewStats)
+	if !ok {
+		return
+	}
+
+	content := c.config.ShortURL.BaseURL + "/" + url.ShortCode + "?" + qrParam + "=1"
+	var image []byte
+	contentType := "image/png"
+	if format == "svg" {
+		image, err = qr.SVG(content, opts)
+		contentType = "image/svg+xml"
+	} else {
+		image, err = qr.PNG(content, opts)
+	}
+	if errors.Is(err, qr.ErrInvalidOptions) {
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+		return
+	}
+	if err != nil {
+		problem.Write(ctx, http.StatusInternalServerError, problem.Internal, "Failed to render QR code")
+		return
+	}
+
+	ctx.Data(http.StatusOK, contentType, image)
+}
+
+// ListURLs returns a page of the links of the caller's workspace, or of
+// their own links if they act outside a workspace, newest first unless
+// asked otherwise. Pages are chained by cursor: each response carries the
+// cursor of the next page, null on the last one.
+func (c *URLController) ListURLs(ctx *gin.Context) {
+	principal := currentPrincipal(ctx)
+	if principal.UserID == nil {
+		problem.Write(ctx, http.StatusForbidden, problem.Forbidden, "API key is not associated with a user")
+		return
+	}
+	space := principal.Space()
+	if !authorize(ctx, c.authorizer, auth.ActionViewStats, space) {
+		return
+	}
+
+	perPage, err := strconv.Atoi(ctx.DefaultQuery("per_page", strconv.Itoa(defaultPageSize)))
+	if err != nil || perPage < 1 || perPage > maxPageSize {
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid per_page")
+		return
+	}
+
+	filter := repository.URLFilter{
+		Scope:  scopeOf(space),
+		Domain: ctx.Query("domain"),
+		Status: ctx.Query("status"),
+		Search: ctx.Query("q"),
+		Sort:   ctx.DefaultQuery("sort", repository.SortCreatedAt),
+		// One more than asked tells whether there is a next page
+		Limit: perPage + 1,
+	}
+	switch filter.Status {
+	case "", models.URLStatusActive, models.URLStatusPaused, models.URLStatusDisabled:
+	default:
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid status")
+		return
+	}
+	if filter.Sort != repository.SortCreatedAt && filter.Sort != repository.SortClicks {
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid sort")
+		return
+	}
+	switch ctx.DefaultQuery("order", "desc") {
+	case "desc":
+	case "asc":
+		filter.Ascending = true
+	default:
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid order")
+		return
+	}
+	if filter.CreatedAfter, err = parseTimeQuery(ctx, "created_after"); err != nil {
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid created_after")
+		return
+	}
+	if filter.CreatedBefore, err = parseTimeQuery(ctx, "created_before"); err != nil {
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid created_before")
+		return
+	}
+	if value := ctx.Query("min_clicks"); value != "" {
+		minClicks, err := strconv.Atoi(value)
+		if err != nil || minClicks < 0 {
+			problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid min_clicks")
+			return
+		}
+		filter.MinClicks = &minClicks
+	}
+	if value := ctx.Query("cursor"); value != "" {
+		if filter.After, err = decodeCursor(value, filter.Sort, filter.Ascending); err != nil {
+			problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid cursor")
+			return
+		}
+	}
+
+	urls, total, err := c.urlService.ListURLs(ctx.Request.Context(), filter)
+	if err != nil {
+		problem.Write(ctx, http.StatusInternalServerError, problem.Internal, "Failed to list URLs")
+		return
+	}
+
+	var nextCursor *string
+	if len(urls) > perPage {
+		urls = urls[:perPage]
+		cursor := encodeCursor(repository.CursorOf(&urls[perPage-1]), filter.Sort, filter.Ascending)
+		nextCursor = &cursor
+	}
+	items := make([]urlResponse, 0, len(urls))
+	for i := range urls {
+		items = append(items, c.toURLResponse(&urls[i]))
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"urls":        items,
+		"per_page":    perPage,
+		"total":       total,
+		"next_cursor": nextCursor,
+	})
+}
+
+// listCursor is the content of a ListURLs cursor. It records the ordering
+// it was made for, as it means nothing in another.
+type listCursor struct {
+	Sort        string    `json:"s"`
+	Ascending   bool      `json:"a,omitempty"`
+	ID          uint      `json:"i"`
+	CreatedAt   time.Time `json:"t"`
+	AccessCount int       `json:"c"`
+}
+
+func encodeCursor(cursor *repository.URLCursor, sort string, ascending bool) string {
+	data, _ := json.Marshal(listCursor{
+		Sort:        sort,
+		Ascending:   ascending,
+		ID:          cursor.ID,
+		CreatedAt:   cursor.CreatedAt,
+		AccessCount: cursor.AccessCount,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value, sort string, ascending bool) (*repository.URLCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor listCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	if cursor.Sort != sort || cursor.Ascending != ascending {
+		return nil, errors.New("cursor is for another ordering")
+	}
+	return &repository.URLCursor{ID: cursor.ID, CreatedAt: cursor.CreatedAt, AccessCount: cursor.AccessCount}, nil
+}
+
+// parseTimeQuery accepts either a full RFC 3339 timestamp or a plain date.
+func parseTimeQuery(ctx *gin.Context, key string) (*time.Time, error) {
+	value := ctx.Query(key)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		if t, err = time.Parse(time.DateOnly, value); err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// ExportURLs streams every link of the caller's space as CSV or NDJSON.
+func (c *URLController) ExportURLs(ctx *gin.Context) {
+	principal := currentPrincipal(ctx)
+	if principal.UserID == nil {
+		problem.Write(ctx, http.StatusForbidden, problem.Forbidden, "API key is not associated with a user")
+		return
+	}
+	space := principal.Space()
+	if !authorize(ctx, c.authorizer, auth.ActionViewStats, space) {
+		return
+	}
+
+	format := ctx.DefaultQuery("format", linkio.FormatCSV)
+	w, err := linkio.NewWriter(ctx.Writer, format)
+	if err != nil {
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid format")
+		return
+	}
+	contentType := "text/csv; charset=utf-8"
+	if format == linkio.FormatNDJSON {
+		contentType = "application/x-ndjson"
+	}
+	ctx.Header("Content-Type", contentType)
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="links.%s"`, format))
+
+	if err := c.urlService.ExportURLs(ctx.Request.Context(), w, scopeOf(space)); err != nil {
+		// Once part of the export is sent, the status cannot change and
+		// the truncated body is all the client gets
+		if !ctx.Writer.Written() {
+			ctx.Header("Content-Disposition", "")
+			problem.Write(ctx, http.StatusInternalServerError, problem.Internal, "Failed to export URLs")
+		}
+		return
+	}
+}
+
+type importConflictResponse struct {
+	Line         int    `json:"line"`
+	ShortCode    string `json:"short_code"`
+	NewShortCode string `json:"new_short_code,omitempty"`
+}
+
+type importErrorResponse struct {
+	Line  int    `json:"line"`
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+// ImportURLs creates links from a CSV or NDJSON body, keeping their short
+// codes, in the space ExportURLs would export. Records whose code is taken
+// are skipped, or created under a new code with on_conflict=rename, and
+// reported either way.
+func (c *URLController) ImportURLs(ctx *gin.Context) {
+	principal := currentPrincipal(ctx)
+	if principal.UserID == nil {
+		problem.Write(ctx, http.StatusForbidden, problem.Forbidden, "API key is not associated with a user")
+		return
+	}
+	// The caller must be able to export the space as well as write to it
+	space := principal.Space()
+	if !authorize(ctx, c.authorizer, auth.ActionViewStats, space) ||
+		!authorize(ctx, c.authorizer, auth.ActionCreateLink, space) {
+		return
+	}
+
+	format := ctx.Query("format")
+	if format == "" {
+		switch ctx.ContentType() {
+		case "application/x-ndjson":
+			format = linkio.FormatNDJSON
+		default:
+			format = linkio.FormatCSV
+		}
+	}
+	opts := service.ImportOptions{OwnerID: principal.UserID, WorkspaceID: space.WorkspaceID}
+	switch ctx.DefaultQuery("on_conflict", "skip") {
+	case "skip":
+	case "rename":
+		opts.RenameConflicts = true
+	default:
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid on_conflict")
+		return
+	}
+
+	r, err := linkio.NewReader(ctx.Request.Body, format)
+	if errors.Is(err, linkio.ErrUnknownFormat) {
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid format")
+		return
+	}
+	if err != nil {
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+		return
+	}
+
+	report, err := c.urlService.ImportURLs(ctx.Request.Context(), r, opts)
+	if err != nil {
+		status, code, message := http.StatusInternalServerError, problem.Internal, "Failed to import URLs"
+		if errors.Is(err, service.ErrUnreadableImport) {
+			status, code, message = http.StatusBadRequest, problem.InvalidRequest, err.Error()
+		}
+		// Chunks imported before the failure stay imported
+		problem.Write(ctx, status, code, message, gin.H{"imported": report.Imported})
+		return
+	}
+
+	conflicts := make([]importConflictResponse, len(report.Conflicts))
+	for i, conflict := range report.Conflicts {
+		conflicts[i] = importConflictResponse{
+			Line:         conflict.Line,
+			ShortCode:    conflict.ShortCode,
+			NewShortCode: conflict.NewShortCode,
+		}
+	}
+	failures := make([]importErrorResponse, len(report.Errors))
+	for i, failure := range report.Errors {
+		code, message := importError(failure.Err)
+		failures[i] = importErrorResponse{Line: failure.Line, Code: code, Error: message}
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"imported":  report.Imported,
+		"conflicts": conflicts,
+		"errors":    failures,
+	})
+}
+
+// importError gives the problem code of, and describes, why a record was
+// not imported.
+func importError(err error) (string, string) {
+	switch {
+	case errors.Is(err, service.ErrInvalidAccessCount):
+		return problem.InvalidRequest, "Invalid access_count"
+	case errors.Is(err, service.ErrInvalidPasswordHash):
+		return problem.InvalidRequest, "Invalid password_hash"
+	}
+	if status, code, message := shortenError(err); status != http.StatusInternalServerError {
+		return code, message
+	}
+	// A malformed record
+	return problem.InvalidRequest, err.Error()
+}
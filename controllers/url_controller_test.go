@@ -2,47 +2,150 @@ package controllers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"image/color"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
+	"urlshortner/auth"
 	"urlshortner/config"
+	"urlshortner/linkio"
+	"urlshortner/middleware"
 	"urlshortner/models"
+	"urlshortner/problem"
+	"urlshortner/qr"
+	"urlshortner/repository"
+	"urlshortner/service"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 type MockURLService struct {
 	mock.Mock
 }
 
-func (m *MockURLService) ShortenURL(longURL string) (*models.URL, error) {
-	args := m.Called(longURL)
+func (m *MockURLService) ShortenURL(ctx context.Context, longURL string, opts service.ShortenOptions) (*models.URL, error) {
+	args := m.Called(longURL, opts)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*models.URL), args.Error(1)
 }
 
-func (m *MockURLService) GetOriginalURL(shortCode string) (string, error) {
-	args := m.Called(shortCode)
-	return args.String(0), args.Error(1)
+func (m *MockURLService) ShortenBatch(ctx context.Context, items []service.BatchItem, opts service.ShortenOptions) ([]service.BatchResult, error) {
+	args := m.Called(items, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]service.BatchResult), args.Error(1)
+}
+
+func (m *MockURLService) GetOriginalURL(ctx context.Context, shortCode string, visit service.Visit) (*service.Redirect, error) {
+	args := m.Called(shortCode, visit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.Redirect), args.Error(1)
+}
+
+func (m *MockURLService) UnlockURL(ctx context.Context, shortCode, password string, visit service.Visit) (*service.Redirect, error) {
+	args := m.Called(shortCode, password, visit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.Redirect), args.Error(1)
+}
+
+func (m *MockURLService) PreviewURL(ctx context.Context, shortCode string, visit service.Visit) (*service.Redirect, error) {
+	args := m.Called(shortCode, visit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.Redirect), args.Error(1)
 }
 
-func (m *MockURLService) GetTopDomains(limit int) ([]models.DomainMetric, error) {
-	args := m.Called(limit)
+func (m *MockURLService) GetTopDomains(ctx context.Context, limit int, scope repository.URLScope) ([]models.DomainMetric, error) {
+	args := m.Called(limit, scope)
 	return args.Get(0).([]models.DomainMetric), args.Error(1)
 }
 
+func (m *MockURLService) ListURLs(ctx context.Context, filter repository.URLFilter) ([]models.URL, int64, error) {
+	args := m.Called(filter)
+	return args.Get(0).([]models.URL), args.Get(1).(int64), args.Error(2)
+}
+
+func (m *MockURLService) ExportURLs(ctx context.Context, w linkio.Writer, scope repository.URLScope) error {
+	args := m.Called(w, scope)
+	return args.Error(0)
+}
+
+func (m *MockURLService) ImportURLs(ctx context.Context, r linkio.Reader, opts service.ImportOptions) (*service.ImportReport, error) {
+	args := m.Called(r, opts)
+	return args.Get(0).(*service.ImportReport), args.Error(1)
+}
+
+func (m *MockURLService) GetURL(ctx context.Context, shortCode string) (*models.URL, error) {
+	args := m.Called(shortCode)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.URL), args.Error(1)
+}
+
+func (m *MockURLService) UpdateURL(ctx context.Context, link *models.URL, update service.URLUpdate) (*models.URL, error) {
+	args := m.Called(link, update)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.URL), args.Error(1)
+}
+
+func (m *MockURLService) DeleteURL(ctx context.Context, link *models.URL) error {
+	args := m.Called(link)
+	return args.Error(0)
+}
+
+func (m *MockURLService) Fallback(ctx context.Context, shortCode string) service.Fallback {
+	args := m.Called(shortCode)
+	return args.Get(0).(service.Fallback)
+}
+
+func (m *MockURLService) GetSourceStats(ctx context.Context, link *models.URL) ([]models.SourceMetric, error) {
+	args := m.Called(link)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.SourceMetric), args.Error(1)
+}
+
+func (m *MockURLService) GetVariantStats(ctx context.Context, link *models.URL) ([]models.VariantMetric, error) {
+	args := m.Called(link)
+	return args.Get(0).([]models.VariantMetric), args.Error(1)
+}
+
+// stubRoles maps workspace ID and user ID to the user's role.
+type stubRoles map[[2]uint]models.Role
+
+func (s stubRoles) MemberRole(ctx context.Context, workspaceID, userID uint) (models.Role, error) {
+	return s[[2]uint{workspaceID, userID}], nil
+}
+
 func setupTestController() (*URLController, *MockURLService, *gin.Engine) {
 	gin.SetMode(gin.TestMode)
 	mockService := new(MockURLService)
 	cfg := &config.Config{}
 	cfg.ShortURL.BaseURL = "http://localhost:8080"
-	controller := NewURLController(mockService, cfg)
+	cfg.Redirect.StatusCode = http.StatusFound
+	cfg.Redirect.PermanentMaxAge = 3600
+	roles := stubRoles{{1, 7}: models.RoleEditor, {1, 8}: models.RoleViewer}
+	controller := NewURLController(mockService, auth.NewAuthorizer(roles), cfg)
 	router := gin.New()
 	return controller, mockService, router
 }
@@ -61,7 +164,7 @@ func TestShortenURLEndpoint(t *testing.T) {
 				"url": "https://example.com/page",
 			},
 			setupMock: func(m *MockURLService) {
-				m.On("ShortenURL", "https://example.com/page").Return(&models.URL{
+				m.On("ShortenURL", "https://example.com/page", service.ShortenOptions{}).Return(&models.URL{
 					OriginalURL: "https://example.com/page",
 					ShortCode:   "abc123",
 				}, nil)
@@ -77,12 +180,45 @@ func TestShortenURLEndpoint(t *testing.T) {
 				"url": "not-a-valid-url",
 			},
 			setupMock: func(m *MockURLService) {
-				// No mock needed - validation will fail
+				m.On("ShortenURL", "not-a-valid-url", service.ShortenOptions{}).Return(nil, service.ErrInvalidURL)
 			},
 			expectedStatus: http.StatusBadRequest,
-			expectedBody: map[string]interface{}{
-				"error": "Invalid URL",
+			expectedBody:   problemBody(http.StatusBadRequest, problem.InvalidURL, "Invalid URL", "/api/v1/shorten"),
+		},
+		{
+			name: "Malformed setting",
+			requestBody: map[string]interface{}{
+				"url":        "https://example.com/page",
+				"max_clicks": "ten",
+			},
+			setupMock:      func(m *MockURLService) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: problemBody(http.StatusBadRequest, problem.InvalidRequest,
+				"json: cannot unmarshal string into Go struct field .max_clicks of type int", "/api/v1/shorten"),
+		},
+		{
+			name: "Unknown UTM template",
+			requestBody: map[string]interface{}{
+				"url":          "https://example.com/page",
+				"utm_template": "newsletter",
+			},
+			setupMock: func(m *MockURLService) {
+				m.On("ShortenURL", "https://example.com/page", service.ShortenOptions{UTMTemplate: "newsletter"}).Return(nil, service.ErrUTMTemplateNotFound)
 			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   problemBody(http.StatusBadRequest, problem.InvalidRequest, "Unknown UTM template", "/api/v1/shorten"),
+		},
+		{
+			name: "Alias already taken",
+			requestBody: map[string]interface{}{
+				"url":   "https://example.com/page",
+				"alias": "spring-sale",
+			},
+			setupMock: func(m *MockURLService) {
+				m.On("ShortenURL", "https://example.com/page", service.ShortenOptions{Alias: "spring-sale"}).Return(nil, service.ErrAliasTaken)
+			},
+			expectedStatus: http.StatusConflict,
+			expectedBody:   problemBody(http.StatusConflict, problem.AliasTaken, "Alias is already taken", "/api/v1/shorten"),
 		},
 		{
 			name: "Service error",
@@ -90,12 +226,10 @@ func TestShortenURLEndpoint(t *testing.T) {
 				"url": "https://example.com/page",
 			},
 			setupMock: func(m *MockURLService) {
-				m.On("ShortenURL", "https://example.com/page").Return(nil, errors.New("service error"))
+				m.On("ShortenURL", "https://example.com/page", service.ShortenOptions{}).Return(nil, errors.New("service error"))
 			},
 			expectedStatus: http.StatusInternalServerError,
-			expectedBody: map[string]interface{}{
-				"error": "Failed to shorten URL",
-			},
+			expectedBody:   problemBody(http.StatusInternalServerError, problem.Internal, "Failed to shorten URL", "/api/v1/shorten"),
 		},
 	}
 
@@ -123,6 +257,180 @@ func TestShortenURLEndpoint(t *testing.T) {
 	}
 }
 
+func TestShortenBatchEndpoint(t *testing.T) {
+	expiry := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	items := []service.BatchItem{
+		{URL: "https://example.com/a", Alias: "launch", ExpiresAt: &expiry},
+		{URL: "https://example.com/b", Alias: "taken"},
+		{URL: "ftp://example.com/c"},
+	}
+
+	t.Run("Reports each item", func(t *testing.T) {
+		controller, mockService, router := setupTestController()
+		mockService.On("ShortenBatch", items, service.ShortenOptions{RedirectCode: 301}).Return([]service.BatchResult{
+			{URL: &models.URL{ShortCode: "launch"}},
+			{Err: service.ErrAliasTaken},
+			{Err: service.ErrInvalidURL},
+		}, nil)
+		router.POST("/api/v1/shorten/batch", controller.ShortenBatch)
+
+		body := `{"redirect_code": 301, "urls": [
+			{"url": "https://example.com/a", "alias": "launch", "expires_at": "2030-01-01T00:00:00Z"},
+			{"url": "https://example.com/b", "alias": "taken"},
+			{"url": "ftp://example.com/c"}]}`
+		req := httptest.NewRequest("POST", "/api/v1/shorten/batch", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, map[string]interface{}{
+			"results": []interface{}{
+				map[string]interface{}{"url": "https://example.com/a", "short_code": "launch", "short_url": "http://localhost:8080/launch"},
+				map[string]interface{}{"url": "https://example.com/b", "code": "alias_taken", "error": "Alias is already taken"},
+				map[string]interface{}{"url": "ftp://example.com/c", "code": "invalid_url", "error": "Invalid URL"},
+			},
+			"succeeded": float64(1),
+			"failed":    float64(2),
+		}, response)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("Too many URLs", func(t *testing.T) {
+		controller, mockService, router := setupTestController()
+		mockService.On("ShortenBatch", mock.Anything, mock.Anything).Return(nil, service.ErrBatchTooLarge)
+		router.POST("/api/v1/shorten/batch", controller.ShortenBatch)
+
+		req := httptest.NewRequest("POST", "/api/v1/shorten/batch", bytes.NewBufferString(`{"urls": [{"url": "https://example.com/a"}]}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+}
+
+func TestExportEndpoint(t *testing.T) {
+	userID := uint(7)
+	workspaceID := uint(1)
+
+	t.Run("Streams the workspace links", func(t *testing.T) {
+		controller, mockService, router := setupTestController()
+		mockService.On("ExportURLs", mock.Anything, repository.URLScope{WorkspaceID: &workspaceID}).Run(func(args mock.Arguments) {
+			w := args.Get(0).(linkio.Writer)
+			require.NoError(t, w.Write(linkio.Record{ShortCode: "abc123", OriginalURL: "https://example.com/a", AccessCount: 2}))
+			require.NoError(t, w.Flush())
+		}).Return(nil)
+		router.GET("/api/v1/export", withPrincipal(&auth.Principal{UserID: &userID, WorkspaceID: &workspaceID}), controller.ExportURLs)
+
+		req := httptest.NewRequest("GET", "/api/v1/export?format=ndjson", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="links.ndjson"`, w.Header().Get("Content-Disposition"))
+		assert.Equal(t, `{"short_code":"abc123","original_url":"https://example.com/a","access_count":2}`+"\n", w.Body.String())
+	})
+
+	t.Run("Unknown format", func(t *testing.T) {
+		controller, _, router := setupTestController()
+		router.GET("/api/v1/export", withPrincipal(&auth.Principal{UserID: &userID}), controller.ExportURLs)
+
+		req := httptest.NewRequest("GET", "/api/v1/export?format=xml", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestImportEndpoint(t *testing.T) {
+	userID := uint(7)
+
+	t.Run("Reports conflicts and errors", func(t *testing.T) {
+		controller, mockService, router := setupTestController()
+		mockService.On("ImportURLs", mock.Anything, service.ImportOptions{OwnerID: &userID, RenameConflicts: true}).Return(&service.ImportReport{
+			Imported:  2,
+			Conflicts: []service.ImportConflict{{Line: 3, ShortCode: "taken", NewShortCode: "xyz789"}},
+			Errors: []service.ImportError{
+				{Line: 4, Err: service.ErrInvalidURL},
+				{Line: 5, Err: errors.New("access_count: invalid syntax")},
+			},
+		}, nil)
+		router.POST("/api/v1/import", withPrincipal(&auth.Principal{UserID: &userID}), controller.ImportURLs)
+
+		req := httptest.NewRequest("POST", "/api/v1/import?on_conflict=rename", bytes.NewBufferString("short_code,original_url\n"))
+		req.Header.Set("Content-Type", "text/csv")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, map[string]interface{}{
+			"imported": float64(2),
+			"conflicts": []interface{}{
+				map[string]interface{}{"line": float64(3), "short_code": "taken", "new_short_code": "xyz789"},
+			},
+			"errors": []interface{}{
+				map[string]interface{}{"line": float64(4), "code": "invalid_url", "error": "Invalid URL"},
+				map[string]interface{}{"line": float64(5), "code": "invalid_request", "error": "access_count: invalid syntax"},
+			},
+		}, response)
+	})
+
+	t.Run("CSV without the required columns", func(t *testing.T) {
+		controller, mockService, router := setupTestController()
+		router.POST("/api/v1/import", withPrincipal(&auth.Principal{UserID: &userID}), controller.ImportURLs)
+
+		req := httptest.NewRequest("POST", "/api/v1/import", bytes.NewBufferString("code,url\nabc,https://example.com\n"))
+		req.Header.Set("Content-Type", "text/csv")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		mockService.AssertNotCalled(t, "ImportURLs", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Invalid on_conflict", func(t *testing.T) {
+		controller, _, router := setupTestController()
+		router.POST("/api/v1/import", withPrincipal(&auth.Principal{UserID: &userID}), controller.ImportURLs)
+
+		req := httptest.NewRequest("POST", "/api/v1/import?on_conflict=overwrite", bytes.NewBufferString("short_code,original_url\n"))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+	t.Run("Keys without a user", func(t *testing.T) {
+		controller, mockService, router := setupTestController()
+		router.POST("/api/v1/import", withPrincipal(&auth.Principal{APIKeyID: 4}), controller.ImportURLs)
+
+		req := httptest.NewRequest("POST", "/api/v1/import", bytes.NewBufferString("short_code,original_url\n"))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		mockService.AssertNotCalled(t, "ImportURLs", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Workspace viewers", func(t *testing.T) {
+		viewerID, workspaceID := uint(8), uint(1)
+		controller, mockService, router := setupTestController()
+		router.POST("/api/v1/import", withPrincipal(&auth.Principal{UserID: &viewerID, WorkspaceID: &workspaceID}), controller.ImportURLs)
+
+		req := httptest.NewRequest("POST", "/api/v1/import", bytes.NewBufferString("short_code,original_url\n"))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		mockService.AssertNotCalled(t, "ImportURLs", mock.Anything, mock.Anything)
+	})
+}
+
 func TestRedirectEndpoint(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -130,24 +438,122 @@ func TestRedirectEndpoint(t *testing.T) {
 		setupMock      func(*MockURLService)
 		expectedStatus int
 		expectedURL    string
+		expectedCache  string
+		expectedCode   string
 	}{
 		{
 			name:      "Successful redirect",
 			shortCode: "abc123",
 			setupMock: func(m *MockURLService) {
-				m.On("GetOriginalURL", "abc123").Return("https://example.com/page", nil)
+				m.On("GetOriginalURL", "abc123", mock.Anything).Return(&service.Redirect{Location: "https://example.com/page", StatusCode: http.StatusFound}, nil)
 			},
 			expectedStatus: http.StatusFound,
 			expectedURL:    "https://example.com/page",
 		},
+		{
+			name:      "Permanent redirect is cacheable",
+			shortCode: "perm",
+			setupMock: func(m *MockURLService) {
+				m.On("GetOriginalURL", "perm", mock.Anything).Return(&service.Redirect{Location: "https://example.com/canonical", StatusCode: http.StatusMovedPermanently}, nil)
+			},
+			expectedStatus: http.StatusMovedPermanently,
+			expectedURL:    "https://example.com/canonical",
+			expectedCache:  "public, max-age=3600",
+		},
+		{
+			name:      "Permanent one-time link is not cached",
+			shortCode: "once",
+			setupMock: func(m *MockURLService) {
+				maxClicks := 1
+				m.On("GetOriginalURL", "once", mock.Anything).Return(&service.Redirect{
+					Location: "https://example.com/invite", StatusCode: http.StatusPermanentRedirect,
+					Link: &models.URL{ShortCode: "once", MaxClicks: &maxClicks},
+				}, nil)
+			},
+			expectedStatus: http.StatusPermanentRedirect,
+			expectedURL:    "https://example.com/invite",
+			expectedCache:  "no-store",
+		},
+		{
+			name:      "Temporary redirect preserving method",
+			shortCode: "api",
+			setupMock: func(m *MockURLService) {
+				m.On("GetOriginalURL", "api", mock.Anything).Return(&service.Redirect{Location: "https://api.example.com/v2", StatusCode: http.StatusTemporaryRedirect}, nil)
+			},
+			expectedStatus: http.StatusTemporaryRedirect,
+			expectedURL:    "https://api.example.com/v2",
+		},
 		{
 			name:      "Short code not found",
 			shortCode: "notfound",
 			setupMock: func(m *MockURLService) {
-				m.On("GetOriginalURL", "notfound").Return("", errors.New("not found"))
+				m.On("GetOriginalURL", "notfound", mock.Anything).Return(nil, service.ErrURLNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   problem.NotFound,
+		},
+		{
+			name:      "Storage failure is not a missing link",
+			shortCode: "abc123",
+			setupMock: func(m *MockURLService) {
+				m.On("GetOriginalURL", "abc123", mock.Anything).Return(nil, &service.StorageError{Err: errors.New("connection refused")})
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   problem.Internal,
+		},
+		{
+			name:      "Deleted link",
+			shortCode: "deleted",
+			setupMock: func(m *MockURLService) {
+				m.On("GetOriginalURL", "deleted", mock.Anything).Return(nil, service.ErrURLDeleted)
+			},
+			expectedStatus: http.StatusGone,
+			expectedCode:   problem.Deleted,
+		},
+		{
+			name:      "One-time link already used",
+			shortCode: "invite",
+			setupMock: func(m *MockURLService) {
+				m.On("GetOriginalURL", "invite", mock.Anything).Return(nil, service.ErrURLExhausted)
+			},
+			expectedStatus: http.StatusGone,
+			expectedCode:   problem.ClickLimitReached,
+		},
+		{
+			name:      "Expired link",
+			shortCode: "expired",
+			setupMock: func(m *MockURLService) {
+				m.On("GetOriginalURL", "expired", mock.Anything).Return(nil, service.ErrURLExpired)
+			},
+			expectedStatus: http.StatusGone,
+			expectedCode:   problem.Expired,
+		},
+		{
+			name:      "Disabled link",
+			shortCode: "off",
+			setupMock: func(m *MockURLService) {
+				m.On("GetOriginalURL", "off", mock.Anything).Return(nil, service.ErrURLDisabled)
 			},
 			expectedStatus: http.StatusNotFound,
 		},
+		{
+			name:      "Paused link",
+			shortCode: "paused",
+			setupMock: func(m *MockURLService) {
+				m.On("GetOriginalURL", "paused", mock.Anything).Return(nil, service.ErrURLPaused)
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCache:  "no-store",
+			expectedCode:   problem.Paused,
+		},
+		{
+			name:      "Campaign over",
+			shortCode: "summer",
+			setupMock: func(m *MockURLService) {
+				m.On("GetOriginalURL", "summer", mock.Anything).Return(nil, service.ErrURLEnded)
+			},
+			expectedStatus: http.StatusGone,
+		},
 	}
 
 	for _, tt := range tests {
@@ -165,107 +571,1200 @@ func TestRedirectEndpoint(t *testing.T) {
 			if tt.expectedURL != "" {
 				assert.Equal(t, tt.expectedURL, w.Header().Get("Location"))
 			}
+			assert.Equal(t, tt.expectedCache, w.Header().Get("Cache-Control"))
+			if tt.expectedCode != "" {
+				var body map[string]interface{}
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+				assert.Equal(t, tt.expectedCode, body["code"])
+			}
 
 			mockService.AssertExpectations(t)
 		})
 	}
 }
 
-func TestGetTopDomainsEndpoint(t *testing.T) {
+func TestDeadLinkFallback(t *testing.T) {
+	const browser = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
 	tests := []struct {
 		name           string
-		setupMock      func(*MockURLService)
+		accept         string
+		fallback       *service.Fallback
 		expectedStatus int
-		expectedBody   map[string]interface{}
+		expectedType   string
+		expectedURL    string
+		expectedCSP    string
 	}{
 		{
-			name: "Successfully get top domains",
-			setupMock: func(m *MockURLService) {
-				metrics := []models.DomainMetric{
-					{Domain: "example.com", Count: 5},
-					{Domain: "test.com", Count: 3},
-					{Domain: "demo.com", Count: 1},
-				}
-				m.On("GetTopDomains", 3).Return(metrics, nil)
-			},
-			expectedStatus: http.StatusOK,
-			expectedBody: map[string]interface{}{
-				"domains": []interface{}{
-					map[string]interface{}{"domain": "example.com", "count": float64(5)},
-					map[string]interface{}{"domain": "test.com", "count": float64(3)},
-					map[string]interface{}{"domain": "demo.com", "count": float64(1)},
-				},
-			},
+			name:           "API client gets a problem",
+			accept:         "application/json",
+			expectedStatus: http.StatusGone,
+			expectedType:   problem.ContentType,
 		},
 		{
-			name: "Service returns empty result",
-			setupMock: func(m *MockURLService) {
-				m.On("GetTopDomains", 3).Return([]models.DomainMetric{}, nil)
-			},
-			expectedStatus: http.StatusOK,
-			expectedBody: map[string]interface{}{
-				"domains": []interface{}{},
-			},
+			name:           "Browser is redirected to the fallback URL",
+			accept:         browser,
+			fallback:       &service.Fallback{URL: "https://example.com/"},
+			expectedStatus: http.StatusFound,
+			expectedURL:    "https://example.com/",
 		},
 		{
-			name: "Service error",
-			setupMock: func(m *MockURLService) {
-				m.On("GetTopDomains", 3).Return([]models.DomainMetric{}, errors.New("service error"))
-			},
-			expectedStatus: http.StatusInternalServerError,
-			expectedBody: map[string]interface{}{
-				"error": "Failed to get metrics",
-			},
+			name:           "Browser sees the workspace page, sandboxed",
+			accept:         browser,
+			fallback:       &service.Fallback{Page: "<p>This offer has ended.</p>", FromWorkspace: true},
+			expectedStatus: http.StatusGone,
+			expectedType:   "text/html; charset=utf-8",
+			expectedCSP:    "sandbox",
+		},
+		{
+			name:           "Browser gets a problem without a fallback",
+			accept:         browser,
+			fallback:       &service.Fallback{},
+			expectedStatus: http.StatusGone,
+			expectedType:   problem.ContentType,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			controller, mockService, router := setupTestController()
-			tt.setupMock(mockService)
-
-			router.GET("/api/v1/metrics/top-domains", controller.GetTopDomains)
+			mockService.On("GetOriginalURL", "promo", mock.Anything).Return(nil, service.ErrURLExpired)
+			if tt.fallback != nil {
+				mockService.On("Fallback", "promo").Return(*tt.fallback)
+			}
+			router.GET("/:shortCode", controller.RedirectURL)
 
-			req := httptest.NewRequest("GET", "/api/v1/metrics/top-domains", nil)
+			req := httptest.NewRequest("GET", "/promo", nil)
+			req.Header.Set("Accept", tt.accept)
 			w := httptest.NewRecorder()
 			router.ServeHTTP(w, req)
 
 			assert.Equal(t, tt.expectedStatus, w.Code)
-
-			var response map[string]interface{}
-			json.Unmarshal(w.Body.Bytes(), &response)
-			assert.Equal(t, tt.expectedBody, response)
-
+			if tt.expectedType != "" {
+				assert.Equal(t, tt.expectedType, w.Header().Get("Content-Type"))
+			}
+			assert.Equal(t, tt.expectedURL, w.Header().Get("Location"))
+			assert.Equal(t, tt.expectedCSP, w.Header().Get("Content-Security-Policy"))
 			mockService.AssertExpectations(t)
 		})
 	}
 }
 
-func TestInvalidJSONRequest(t *testing.T) {
-	controller, _, router := setupTestController()
-	router.POST("/api/v1/shorten", controller.ShortenURL)
+func TestNotYetActiveRedirect(t *testing.T) {
+	controller, mockService, router := setupTestController()
+	launch := time.Now().Add(time.Hour).Truncate(time.Second)
+	mockService.On("GetOriginalURL", "launch", mock.Anything).Return(nil, &service.NotActiveError{ActiveFrom: launch})
+	router.GET("/:shortCode", controller.RedirectURL)
 
-	// Send invalid JSON
-	req := httptest.NewRequest("POST", "/api/v1/shorten", bytes.NewBufferString("invalid json"))
-	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
-	router.ServeHTTP(w, req)
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/launch", nil))
 
-	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.InDelta(t, 3600, retryAfter, 2)
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, launch.UTC().Format(time.RFC3339), body["active_from"])
+	assert.Equal(t, problem.NotActive, body["code"])
 }
 
-func TestMissingURLParameter(t *testing.T) {
-	controller, _, router := setupTestController()
-	router.POST("/api/v1/shorten", controller.ShortenURL)
+func TestDeviceTargetedRedirect(t *testing.T) {
+	controller, mockService, router := setupTestController()
+	router.GET("/:shortCode", controller.RedirectURL)
+	iPhone := "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) Mobile/15E148"
+	mockService.On("GetOriginalURL", "app", mock.MatchedBy(func(v service.Visit) bool {
+		return v.Header.Get("User-Agent") == iPhone
+	})).Return(&service.Redirect{Location: "https://apps.apple.com/app/id123", StatusCode: http.StatusFound, Targeted: true}, nil)
 
-	// Send JSON without URL parameter
-	body, _ := json.Marshal(map[string]interface{}{
-		"not_url": "https://example.com",
-	})
-	req := httptest.NewRequest("POST", "/api/v1/shorten", bytes.NewBuffer(body))
-	req.Header.Set("Content-Type", "application/json")
+	req := httptest.NewRequest("GET", "/app", nil)
+	req.Header.Set("User-Agent", iPhone)
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://apps.apple.com/app/id123", w.Header().Get("Location"))
+	assert.Equal(t, "User-Agent", w.Header().Get("Vary"))
+	mockService.AssertExpectations(t)
+}
+
+func TestRedirectClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		expectedIP string
+	}{
+		{"Forwarded by a trusted proxy", "10.0.0.1:41000", "203.0.113.7"},
+		{"Forwarded header from anyone else is ignored", "192.0.2.1:41000", "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, mockService, router := setupTestController()
+			require.NoError(t, router.SetTrustedProxies([]string{"10.0.0.0/8"}))
+			router.GET("/:shortCode", controller.RedirectURL)
+			mockService.On("GetOriginalURL", "shop", mock.MatchedBy(func(v service.Visit) bool {
+				return v.ClientIP == tt.expectedIP
+			})).Return(&service.Redirect{Location: "https://shop.example.de", StatusCode: http.StatusMovedPermanently, Targeted: true}, nil)
+
+			req := httptest.NewRequest("GET", "/shop", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Forwarded-For", "203.0.113.7")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusMovedPermanently, w.Code)
+			assert.Equal(t, "private, max-age=3600", w.Header().Get("Cache-Control"))
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestStickyVariantRedirect(t *testing.T) {
+	link := &models.URL{ShortCode: "promo", StickyVariants: true}
+
+	t.Run("Assigns a variant", func(t *testing.T) {
+		controller, mockService, router := setupTestController()
+		router.GET("/:shortCode", controller.RedirectURL)
+		mockService.On("GetOriginalURL", "promo", mock.MatchedBy(func(v service.Visit) bool {
+			return v.Variant == ""
+		})).Return(&service.Redirect{Location: "https://example.com/landing-b", StatusCode: http.StatusFound, Link: link, Variant: "b"}, nil)
+
+		req := httptest.NewRequest("GET", "/promo", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusFound, w.Code)
+		cookie := w.Header().Get("Set-Cookie")
+		assert.Contains(t, cookie, "variant_promo=b")
+		assert.Contains(t, cookie, "Path=/promo")
+		assert.Contains(t, cookie, "HttpOnly")
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("Returning visitor", func(t *testing.T) {
+		controller, mockService, router := setupTestController()
+		router.GET("/:shortCode", controller.RedirectURL)
+		mockService.On("GetOriginalURL", "promo", mock.MatchedBy(func(v service.Visit) bool {
+			return v.Variant == "b"
+		})).Return(&service.Redirect{Location: "https://example.com/landing-b", StatusCode: http.StatusFound, Link: link, Variant: "b"}, nil)
+
+		req := httptest.NewRequest("GET", "/promo", nil)
+		req.AddCookie(&http.Cookie{Name: "variant_promo", Value: "b"})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, "https://example.com/landing-b", w.Header().Get("Location"))
+		mockService.AssertExpectations(t)
+	})
+}
+
+func TestRedirectPassthrough(t *testing.T) {
+	controller, mockService, router := setupTestController()
+	router.GET("/:shortCode", controller.RedirectURL)
+	router.GET("/:shortCode/*path", controller.RedirectURL)
+	mockService.On("GetOriginalURL", "abc123", mock.MatchedBy(func(v service.Visit) bool {
+		return v.Path == "/extra/path" && v.Query.Get("ref") == "x"
+	})).Return(&service.Redirect{Location: "https://example.com/docs/extra/path?ref=x", StatusCode: http.StatusFound}, nil)
+	mockService.On("GetOriginalURL", "locked", mock.Anything).Return(nil, service.ErrPasswordRequired)
+
+	req := httptest.NewRequest("GET", "/abc123/extra/path?ref=x", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://example.com/docs/extra/path?ref=x", w.Header().Get("Location"))
+
+	// The password form keeps what is to be passed through
+	req = httptest.NewRequest("GET", "/locked/extra?ref=x", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `action="/locked/extra?ref=x"`)
+
+	mockService.AssertExpectations(t)
+}
+
+func TestPasswordProtectedRedirect(t *testing.T) {
+	t.Run("Serves the password form", func(t *testing.T) {
+		controller, mockService, router := setupTestController()
+		router.GET("/:shortCode", controller.RedirectURL)
+		mockService.On("GetOriginalURL", "abc123", mock.Anything).Return(nil, service.ErrPasswordRequired)
+
+		req := httptest.NewRequest("GET", "/abc123", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		assert.Contains(t, w.Body.String(), `action="/abc123"`)
+		assert.Empty(t, w.Header().Get("Location"))
+	})
+
+	t.Run("API clients get problems", func(t *testing.T) {
+		controller, mockService, router := setupTestController()
+		router.GET("/:shortCode", controller.RedirectURL)
+		router.POST("/:shortCode", controller.UnlockURL)
+		mockService.On("GetOriginalURL", "abc123", mock.Anything).Return(nil, service.ErrPasswordRequired)
+		mockService.On("UnlockURL", "abc123", "guess", mock.Anything).Return(nil, &service.TooManyAttemptsError{RetryAfter: 30 * time.Second})
+
+		req := httptest.NewRequest("GET", "/abc123", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, problemBody(http.StatusUnauthorized, problem.PasswordRequired, "URL is password protected", "/abc123"), decodeBody(t, w))
+
+		req = httptest.NewRequest("POST", "/abc123", bytes.NewBufferString("password=guess"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "31", w.Header().Get("Retry-After"))
+		assert.Equal(t, problemBody(http.StatusTooManyRequests, problem.RateLimited, "Too many failed attempts", "/abc123"), decodeBody(t, w))
+		mockService.AssertExpectations(t)
+	})
+
+	tests := []struct {
+		name           string
+		setupMock      func(*MockURLService)
+		expectedStatus int
+		expectedURL    string
+	}{
+		{
+			name: "Correct password",
+			setupMock: func(m *MockURLService) {
+				m.On("UnlockURL", "abc123", "s3cret", mock.Anything).Return(&service.Redirect{Location: "https://example.com/doc", StatusCode: http.StatusPermanentRedirect}, nil)
+			},
+			expectedStatus: http.StatusSeeOther,
+			expectedURL:    "https://example.com/doc",
+		},
+		{
+			name: "Wrong password",
+			setupMock: func(m *MockURLService) {
+				m.On("UnlockURL", "abc123", "s3cret", mock.Anything).Return(nil, service.ErrWrongPassword)
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Too many attempts",
+			setupMock: func(m *MockURLService) {
+				m.On("UnlockURL", "abc123", "s3cret", mock.Anything).Return(nil, &service.TooManyAttemptsError{RetryAfter: 30 * time.Second})
+			},
+			expectedStatus: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, mockService, router := setupTestController()
+			tt.setupMock(mockService)
+			router.POST("/:shortCode", controller.UnlockURL)
+
+			req := httptest.NewRequest("POST", "/abc123", bytes.NewBufferString("password=s3cret"))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedURL, w.Header().Get("Location"))
+			if tt.expectedStatus == http.StatusTooManyRequests {
+				assert.Equal(t, "31", w.Header().Get("Retry-After"))
+			}
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPermanentCacheControl(t *testing.T) {
+	controller, _, _ := setupTestController()
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	soon, later, past := now.Add(10*time.Minute), now.Add(48*time.Hour), now.Add(-time.Minute)
+	maxClicks := 5
+
+	tests := []struct {
+		name     string
+		redirect *service.Redirect
+		expected string
+	}{
+		{"Plain link", &service.Redirect{Link: &models.URL{}}, "public, max-age=3600"},
+		{"Targeted link", &service.Redirect{Link: &models.URL{}, Targeted: true}, "private, max-age=3600"},
+		{"Click limit", &service.Redirect{Link: &models.URL{MaxClicks: &maxClicks}}, "no-store"},
+		{"Expiring before max-age", &service.Redirect{Link: &models.URL{ExpiresAt: &soon}}, "public, max-age=600"},
+		{"Window ending before max-age", &service.Redirect{Link: &models.URL{ExpiresAt: &later, ActiveUntil: &soon}, Targeted: true}, "private, max-age=600"},
+		{"Ending after max-age", &service.Redirect{Link: &models.URL{ActiveUntil: &later}}, "public, max-age=3600"},
+		{"Already over", &service.Redirect{Link: &models.URL{ExpiresAt: &past}}, "no-store"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, controller.permanentCacheControl(tt.redirect, now))
+		})
+	}
+}
+
+func TestPreviewPage(t *testing.T) {
+	link := &models.URL{
+		ShortCode:   "abc123",
+		OriginalURL: "https://example.com/some/long/path",
+		Domain:      "example.com",
+		CreatedAt:   time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		AccessCount: 42,
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		setupMock    func(*MockURLService)
+		expectedBody []string
+	}{
+		{
+			name: "Trailing plus",
+			path: "/abc123+",
+			setupMock: func(m *MockURLService) {
+				m.On("PreviewURL", "abc123", mock.Anything).Return(&service.Redirect{Location: link.OriginalURL, StatusCode: http.StatusFound, Link: link}, nil)
+			},
+			expectedBody: []string{"https://example.com/some/long/path", "1 May 2024", "42", `href="/abc123"`},
+		},
+		{
+			name: "Preview query",
+			path: "/abc123?preview=1",
+			setupMock: func(m *MockURLService) {
+				m.On("PreviewURL", "abc123", mock.Anything).Return(&service.Redirect{Location: link.OriginalURL, StatusCode: http.StatusFound, Link: link}, nil)
+			},
+			expectedBody: []string{"https://example.com/some/long/path", `href="/abc123"`},
+		},
+		{
+			name: "Passthrough path and query are kept",
+			path: "/abc123+/docs/intro?ref=mail",
+			setupMock: func(m *MockURLService) {
+				m.On("PreviewURL", "abc123", mock.Anything).Return(&service.Redirect{Location: link.OriginalURL, StatusCode: http.StatusFound, Link: link}, nil)
+			},
+			expectedBody: []string{`href="/abc123/docs/intro?ref=mail"`},
+		},
+		{
+			name: "Preview query is dropped from the rest",
+			path: "/abc123/docs?preview=1&ref=mail",
+			setupMock: func(m *MockURLService) {
+				m.On("PreviewURL", "abc123", mock.Anything).Return(&service.Redirect{Location: link.OriginalURL, StatusCode: http.StatusFound, Link: link}, nil)
+			},
+			expectedBody: []string{`href="/abc123/docs?ref=mail"`},
+		},
+		{
+			name: "Shows where the visitor is actually sent and keeps the variant",
+			path: "/abc123+",
+			setupMock: func(m *MockURLService) {
+				m.On("PreviewURL", "abc123", mock.Anything).Return(&service.Redirect{
+					Location: "https://www.example.org/b", StatusCode: http.StatusFound, Link: link, Variant: "b&c",
+				}, nil)
+			},
+			expectedBody: []string{"<dd>example.org</dd>", "Continue to example.org", `href="/abc123?previewed=b%26c"`},
+		},
+		{
+			name: "Forced interstitial counts the visit and links to the destination",
+			path: "/abc123",
+			setupMock: func(m *MockURLService) {
+				m.On("GetOriginalURL", "abc123", mock.Anything).Return(&service.Redirect{Location: link.OriginalURL, StatusCode: http.StatusFound, Link: link, Interstitial: true}, nil)
+			},
+			expectedBody: []string{`href="https://example.com/some/long/path"`},
+		},
+		{
+			name: "Previewing a forced interstitial",
+			path: "/abc123+",
+			setupMock: func(m *MockURLService) {
+				m.On("PreviewURL", "abc123", mock.Anything).Return(&service.Redirect{Location: link.OriginalURL, StatusCode: http.StatusFound, Link: link, Interstitial: true}, nil)
+				m.On("GetOriginalURL", "abc123", mock.Anything).Return(&service.Redirect{Location: link.OriginalURL, StatusCode: http.StatusFound, Link: link, Interstitial: true}, nil)
+			},
+			expectedBody: []string{`href="https://example.com/some/long/path"`},
+		},
+		{
+			name: "Password-protected link",
+			path: "/abc123+",
+			setupMock: func(m *MockURLService) {
+				m.On("PreviewURL", "abc123", mock.Anything).Return(nil, service.ErrPasswordRequired)
+			},
+			expectedBody: []string{`action="/abc123"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, mockService, router := setupTestController()
+			tt.setupMock(mockService)
+			router.GET("/:shortCode", controller.RedirectURL)
+			router.GET("/:shortCode/*path", controller.RedirectURL)
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+			assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+			assert.Empty(t, w.Header().Get("Location"))
+			for _, want := range tt.expectedBody {
+				assert.Contains(t, w.Body.String(), want)
+			}
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPreviewedVariant(t *testing.T) {
+	controller, mockService, router := setupTestController()
+	router.GET("/:shortCode", controller.RedirectURL)
+	mockService.On("GetOriginalURL", "abc123", mock.MatchedBy(func(visit service.Visit) bool {
+		return visit.Previewed == "b&c" && visit.Query.Encode() == "ref=mail"
+	})).Return(&service.Redirect{Location: "https://example.org/b", StatusCode: http.StatusFound}, nil)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/abc123?previewed=b%26c&ref=mail", nil))
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestGetTopDomainsEndpoint(t *testing.T) {
+	tests := []struct {
+		name           string
+		setupMock      func(*MockURLService)
+		expectedStatus int
+		expectedBody   map[string]interface{}
+	}{
+		{
+			name: "Successfully get top domains",
+			setupMock: func(m *MockURLService) {
+				metrics := []models.DomainMetric{
+					{Domain: "example.com", Count: 5},
+					{Domain: "test.com", Count: 3},
+					{Domain: "demo.com", Count: 1},
+				}
+				m.On("GetTopDomains", 3, repository.URLScope{}).Return(metrics, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"domains": []interface{}{
+					map[string]interface{}{"domain": "example.com", "count": float64(5)},
+					map[string]interface{}{"domain": "test.com", "count": float64(3)},
+					map[string]interface{}{"domain": "demo.com", "count": float64(1)},
+				},
+			},
+		},
+		{
+			name: "Service returns empty result",
+			setupMock: func(m *MockURLService) {
+				m.On("GetTopDomains", 3, repository.URLScope{}).Return([]models.DomainMetric{}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"domains": []interface{}{},
+			},
+		},
+		{
+			name: "Service error",
+			setupMock: func(m *MockURLService) {
+				m.On("GetTopDomains", 3, repository.URLScope{}).Return([]models.DomainMetric{}, errors.New("service error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   problemBody(http.StatusInternalServerError, problem.Internal, "Failed to get metrics", "/api/v1/metrics/top-domains"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, mockService, router := setupTestController()
+			tt.setupMock(mockService)
+
+			router.GET("/api/v1/metrics/top-domains", controller.GetTopDomains)
+
+			req := httptest.NewRequest("GET", "/api/v1/metrics/top-domains", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			var response map[string]interface{}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			assert.Equal(t, tt.expectedBody, response)
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestInvalidJSONRequest(t *testing.T) {
+	controller, _, router := setupTestController()
+	router.POST("/api/v1/shorten", controller.ShortenURL)
+
+	// Send invalid JSON
+	req := httptest.NewRequest("POST", "/api/v1/shorten", bytes.NewBufferString("invalid json"))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestMissingURLParameter(t *testing.T) {
+	controller, _, router := setupTestController()
+	router.POST("/api/v1/shorten", controller.ShortenURL)
+
+	// Send JSON without URL parameter
+	body, _ := json.Marshal(map[string]interface{}{
+		"not_url": "https://example.com",
+	})
+	req := httptest.NewRequest("POST", "/api/v1/shorten", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// withPrincipal mimics the API key middleware for handlers under test.
+func withPrincipal(principal *auth.Principal) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		middleware.SetPrincipal(ctx, principal)
+		ctx.Next()
+	}
+}
+
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return body
+}
+
+// problemBody is the problem a handler under test answers with. Test
+// routers do not assign request IDs.
+func problemBody(status int, code, detail, instance string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "about:blank",
+		"title":      http.StatusText(status),
+		"status":     float64(status),
+		"detail":     detail,
+		"code":       code,
+		"instance":   instance,
+		"request_id": "",
+	}
+}
+
+func TestShortenURLAttributesOwner(t *testing.T) {
+	controller, mockService, router := setupTestController()
+	userID := uint(7)
+	router.POST("/api/v1/shorten", withPrincipal(&auth.Principal{UserID: &userID}), controller.ShortenURL)
+
+	mockService.On("ShortenURL", "https://example.com/page", service.ShortenOptions{OwnerID: &userID}).
+		Return(&models.URL{ShortCode: "abc123"}, nil)
+
+	body, _ := json.Marshal(map[string]interface{}{"url": "https://example.com/page"})
+	req := httptest.NewRequest("POST", "/api/v1/shorten", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestWorkspaceRoles(t *testing.T) {
+	editorID, viewerID := uint(7), uint(8)
+	workspaceID := uint(1)
+
+	t.Run("Editor creates links in the workspace", func(t *testing.T) {
+		controller, mockService, router := setupTestController()
+		router.POST("/api/v1/shorten", withPrincipal(&auth.Principal{UserID: &editorID, WorkspaceID: &workspaceID}), controller.ShortenURL)
+
+		mockService.On("ShortenURL", "https://example.com/page", service.ShortenOptions{OwnerID: &editorID, WorkspaceID: &workspaceID}).
+			Return(&models.URL{ShortCode: "abc123"}, nil)
+
+		body, _ := json.Marshal(map[string]interface{}{"url": "https://example.com/page"})
+		req := httptest.NewRequest("POST", "/api/v1/shorten", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("Viewer cannot create links", func(t *testing.T) {
+		controller, mockService, router := setupTestController()
+		router.POST("/api/v1/shorten", withPrincipal(&auth.Principal{UserID: &viewerID, WorkspaceID: &workspaceID}), controller.ShortenURL)
+
+		body, _ := json.Marshal(map[string]interface{}{"url": "https://example.com/page"})
+		req := httptest.NewRequest("POST", "/api/v1/shorten", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		mockService.AssertNotCalled(t, "ShortenURL", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Viewer sees workspace stats", func(t *testing.T) {
+		controller, mockService, router := setupTestController()
+		router.GET("/api/v1/metrics/top-domains", withPrincipal(&auth.Principal{UserID: &viewerID, WorkspaceID: &workspaceID}), controller.GetTopDomains)
+
+		mockService.On("GetTopDomains", 3, repository.URLScope{WorkspaceID: &workspaceID}).Return([]models.DomainMetric{}, nil)
+
+		req := httptest.NewRequest("GET", "/api/v1/metrics/top-domains", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockService.AssertExpectations(t)
+	})
+}
+
+func TestListURLsEndpoint(t *testing.T) {
+	userID := uint(7)
+	viewerID := uint(8)
+	workspaceID := uint(1)
+	otherWorkspaceID := uint(2)
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	after := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	minClicks := 2
+
+	tests := []struct {
+		name           string
+		principal      *auth.Principal
+		query          string
+		setupMock      func(*MockURLService)
+		expectedStatus int
+		expectedBody   map[string]interface{}
+	}{
+		{
+			name:      "Lists own links with filters",
+			principal: &auth.Principal{UserID: &userID},
+			query:     "?per_page=1&domain=example.com&created_after=2024-04-01&status=active&min_clicks=2&q=page&sort=clicks&order=asc",
+			setupMock: func(m *MockURLService) {
+				m.On("ListURLs", repository.URLFilter{
+					Scope:        repository.URLScope{OwnerID: &userID},
+					Domain:       "example.com",
+					Status:       models.URLStatusActive,
+					CreatedAfter: &after,
+					MinClicks:    &minClicks,
+					Search:       "page",
+					Sort:         repository.SortClicks,
+					Ascending:    true,
+					Limit:        2,
+				}).Return([]models.URL{{
+					ID:          3,
+					OriginalURL: "https://example.com/page",
+					ShortCode:   "abc123",
+					Domain:      "example.com",
+					CreatedAt:   createdAt,
+					AccessCount: 4,
+					Status:      models.URLStatusActive,
+				}, {
+					ID:          5,
+					OriginalURL: "https://example.com/page/2",
+					ShortCode:   "def456",
+					AccessCount: 6,
+				}}, int64(11), nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"urls": []interface{}{
+					map[string]interface{}{
+						"short_code":         "abc123",
+						"short_url":          "http://localhost:8080/abc123",
+						"original_url":       "https://example.com/page",
+						"domain":             "example.com",
+						"created_at":         "2024-05-01T12:00:00Z",
+						"access_count":       float64(4),
+						"status":             "active",
+						"expires_at":         nil,
+						"active_from":        nil,
+						"active_until":       nil,
+						"redirect_code":      float64(302),
+						"password_protected": false,
+						"max_clicks":         nil,
+						"preview":            false,
+						"device_rules":       []interface{}{},
+						"geo_rules":          []interface{}{},
+						"variants":           []interface{}{},
+						"sticky_variants":    false,
+						"passthrough_path":   false,
+						"passthrough_query":  false,
+						"query_conflict":     "destination",
+					},
+				},
+				"per_page":    float64(1),
+				"total":       float64(11),
+				"next_cursor": encodeCursor(&repository.URLCursor{ID: 3, CreatedAt: createdAt, AccessCount: 4}, repository.SortClicks, true),
+			},
+		},
+		{
+			name:      "Lists workspace links",
+			principal: &auth.Principal{UserID: &viewerID, WorkspaceID: &workspaceID},
+			setupMock: func(m *MockURLService) {
+				m.On("ListURLs", repository.URLFilter{
+					Scope: repository.URLScope{WorkspaceID: &workspaceID},
+					Sort:  repository.SortCreatedAt,
+					Limit: defaultPageSize + 1,
+				}).Return([]models.URL{}, int64(0), nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"urls":        []interface{}{},
+				"per_page":    float64(defaultPageSize),
+				"total":       float64(0),
+				"next_cursor": nil,
+			},
+		},
+		{
+			name:      "Continues from a cursor",
+			principal: &auth.Principal{UserID: &userID},
+			query:     "?cursor=" + encodeCursor(&repository.URLCursor{ID: 3, CreatedAt: createdAt}, repository.SortCreatedAt, false),
+			setupMock: func(m *MockURLService) {
+				m.On("ListURLs", repository.URLFilter{
+					Scope: repository.URLScope{OwnerID: &userID},
+					Sort:  repository.SortCreatedAt,
+					After: &repository.URLCursor{ID: 3, CreatedAt: createdAt},
+					Limit: defaultPageSize + 1,
+				}).Return([]models.URL{}, int64(0), nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"urls":        []interface{}{},
+				"per_page":    float64(defaultPageSize),
+				"total":       float64(0),
+				"next_cursor": nil,
+			},
+		},
+		{
+			name:           "Cursor of another ordering",
+			principal:      &auth.Principal{UserID: &userID},
+			query:          "?sort=clicks&cursor=" + encodeCursor(&repository.URLCursor{ID: 3, CreatedAt: createdAt}, repository.SortCreatedAt, false),
+			setupMock:      func(m *MockURLService) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   problemBody(http.StatusBadRequest, problem.InvalidRequest, "Invalid cursor", "/api/v1/urls"),
+		},
+		{
+			name:           "Invalid sort",
+			principal:      &auth.Principal{UserID: &userID},
+			query:          "?sort=domain",
+			setupMock:      func(m *MockURLService) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   problemBody(http.StatusBadRequest, problem.InvalidRequest, "Invalid sort", "/api/v1/urls"),
+		},
+		{
+			name:           "Non-member cannot list workspace links",
+			principal:      &auth.Principal{UserID: &userID, WorkspaceID: &otherWorkspaceID},
+			setupMock:      func(m *MockURLService) {},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   problemBody(http.StatusForbidden, problem.Forbidden, "Forbidden", "/api/v1/urls"),
+		},
+		{
+			name:           "Key without a user",
+			principal:      &auth.Principal{},
+			setupMock:      func(m *MockURLService) {},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   problemBody(http.StatusForbidden, problem.Forbidden, "API key is not associated with a user", "/api/v1/urls"),
+		},
+		{
+			name:           "Invalid page size",
+			principal:      &auth.Principal{UserID: &userID},
+			query:          "?per_page=1000",
+			setupMock:      func(m *MockURLService) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   problemBody(http.StatusBadRequest, problem.InvalidRequest, "Invalid per_page", "/api/v1/urls"),
+		},
+		{
+			name:           "Invalid date",
+			principal:      &auth.Principal{UserID: &userID},
+			query:          "?created_before=yesterday",
+			setupMock:      func(m *MockURLService) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   problemBody(http.StatusBadRequest, problem.InvalidRequest, "Invalid created_before", "/api/v1/urls"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, mockService, router := setupTestController()
+			tt.setupMock(mockService)
+
+			router.GET("/api/v1/urls", withPrincipal(tt.principal), controller.ListURLs)
+
+			req := httptest.NewRequest("GET", "/api/v1/urls"+tt.query, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			var response map[string]interface{}
+			json.Unmarshal(w.Body.Bytes(), &response)
+			assert.Equal(t, tt.expectedBody, response)
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUpdateURLEndpoint(t *testing.T) {
+	ownerID, otherID := uint(7), uint(9)
+	expiry := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	newURL := "https://example.com/moved"
+	disabled := models.URLStatusDisabled
+	launch := time.Date(2029, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	owned := func() *models.URL {
+		return &models.URL{ShortCode: "abc123", OriginalURL: "https://example.com/page", OwnerID: &ownerID, ExpiresAt: &expiry}
+	}
+
+	tests := []struct {
+		name           string
+		principal      *auth.Principal
+		body           string
+		setupMock      func(*MockURLService)
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:      "Owner retargets link",
+			principal: &auth.Principal{UserID: &ownerID},
+			body:      `{"url": "https://example.com/moved", "expires_at": "2030-01-01T00:00:00Z"}`,
+			setupMock: func(m *MockURLService) {
+				m.On("GetURL", "abc123").Return(owned(), nil)
+				m.On("UpdateURL", owned(), service.URLUpdate{OriginalURL: &newURL, ExpiresAt: &expiry}).
+					Return(&models.URL{ShortCode: "abc123", OriginalURL: newURL}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:      "Null expiry clears it",
+			principal: &auth.Principal{UserID: &ownerID},
+			body:      `{"status": "disabled", "expires_at": null}`,
+			setupMock: func(m *MockURLService) {
+				m.On("GetURL", "abc123").Return(owned(), nil)
+				m.On("UpdateURL", owned(), service.URLUpdate{Status: &disabled, ClearExpiry: true}).
+					Return(owned(), nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:      "Schedule launch and drop end",
+			principal: &auth.Principal{UserID: &ownerID},
+			body:      `{"active_from": "2029-06-01T09:00:00Z", "active_until": null}`,
+			setupMock: func(m *MockURLService) {
+				m.On("GetURL", "abc123").Return(owned(), nil)
+				m.On("UpdateURL", owned(), service.URLUpdate{ActiveFrom: &launch, ClearActiveUntil: true}).
+					Return(owned(), nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:      "Active window ending before it starts",
+			principal: &auth.Principal{UserID: &ownerID},
+			body:      `{"active_from": "2029-06-01T09:00:00Z", "active_until": "2029-05-01T09:00:00Z"}`,
+			setupMock: func(m *MockURLService) {
+				m.On("GetURL", "abc123").Return(owned(), nil)
+				m.On("UpdateURL", owned(), mock.Anything).Return(nil, service.ErrInvalidActiveWindow)
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "Someone else's link",
+			principal: &auth.Principal{UserID: &otherID},
+			body:      `{"url": "https://evil.example.com"}`,
+			setupMock: func(m *MockURLService) {
+				m.On("GetURL", "abc123").Return(owned(), nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:      "Invalid destination",
+			principal: &auth.Principal{UserID: &ownerID},
+			body:      `{"url": "ftp://example.com"}`,
+			setupMock: func(m *MockURLService) {
+				m.On("GetURL", "abc123").Return(owned(), nil)
+				m.On("UpdateURL", owned(), mock.Anything).Return(nil, service.ErrInvalidURL)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.InvalidURL,
+		},
+		{
+			name:      "Invalid status",
+			principal: &auth.Principal{UserID: &ownerID},
+			body:      `{"status": "archived"}`,
+			setupMock: func(m *MockURLService) {
+				m.On("GetURL", "abc123").Return(owned(), nil)
+				m.On("UpdateURL", owned(), mock.Anything).Return(nil, service.ErrInvalidStatus)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   problem.InvalidRequest,
+		},
+		{
+			name:      "Storage failure",
+			principal: &auth.Principal{UserID: &ownerID},
+			body:      `{"status": "disabled"}`,
+			setupMock: func(m *MockURLService) {
+				m.On("GetURL", "abc123").Return(owned(), nil)
+				m.On("UpdateURL", owned(), mock.Anything).Return(nil, &service.StorageError{Err: errors.New("database error")})
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   problem.Internal,
+		},
+		{
+			name:           "Invalid expiry",
+			principal:      &auth.Principal{UserID: &ownerID},
+			body:           `{"expires_at": "tomorrow"}`,
+			setupMock:      func(m *MockURLService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:      "Unknown link",
+			principal: &auth.Principal{UserID: &ownerID},
+			body:      `{"status": "disabled"}`,
+			setupMock: func(m *MockURLService) {
+				m.On("GetURL", "abc123").Return(nil, service.ErrURLNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, mockService, router := setupTestController()
+			tt.setupMock(mockService)
+
+			router.PATCH("/api/v1/urls/:shortCode", withPrincipal(tt.principal), controller.UpdateURL)
+
+			req := httptest.NewRequest("PATCH", "/api/v1/urls/abc123", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedCode != "" {
+				assert.Equal(t, tt.expectedCode, decodeBody(t, w)["code"])
+			}
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDeleteURLEndpoint(t *testing.T) {
+	viewerID, editorID, workspaceID := uint(8), uint(7), uint(1)
+	link := &models.URL{ShortCode: "abc123", WorkspaceID: &workspaceID}
+
+	tests := []struct {
+		name           string
+		principal      *auth.Principal
+		setupMock      func(*MockURLService)
+		expectedStatus int
+	}{
+		{
+			name:      "Editor deletes workspace link",
+			principal: &auth.Principal{UserID: &editorID, WorkspaceID: &workspaceID},
+			setupMock: func(m *MockURLService) {
+				m.On("GetURL", "abc123").Return(link, nil)
+				m.On("DeleteURL", link).Return(nil)
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:      "Viewer cannot delete",
+			principal: &auth.Principal{UserID: &viewerID, WorkspaceID: &workspaceID},
+			setupMock: func(m *MockURLService) {
+				m.On("GetURL", "abc123").Return(link, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:      "Unscoped link needs admin scope",
+			principal: &auth.Principal{Scopes: []string{auth.ScopeShorten}},
+			setupMock: func(m *MockURLService) {
+				m.On("GetURL", "abc123").Return(&models.URL{ShortCode: "abc123"}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, mockService, router := setupTestController()
+			tt.setupMock(mockService)
+
+			router.DELETE("/api/v1/urls/:shortCode", withPrincipal(tt.principal), controller.DeleteURL)
+
+			req := httptest.NewRequest("DELETE", "/api/v1/urls/abc123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetURLEndpoint(t *testing.T) {
+	viewerID, otherID, workspaceID := uint(8), uint(9), uint(1)
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	expiry := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	link := &models.URL{
+		ShortCode:   "promo",
+		OriginalURL: "https://example.com/sale",
+		Domain:      "example.com",
+		WorkspaceID: &workspaceID,
+		CreatedAt:   createdAt,
+		ExpiresAt:   &expiry,
+		AccessCount: 12,
+		Status:      models.URLStatusPaused,
+	}
+
+	t.Run("Returns the link without counting a visit", func(t *testing.T) {
+		controller, mockService, router := setupTestController()
+		mockService.On("GetURL", "promo").Return(link, nil)
+		router.GET("/api/v1/urls/:shortCode", withPrincipal(&auth.Principal{UserID: &viewerID, WorkspaceID: &workspaceID}), controller.GetURL)
+
+		req := httptest.NewRequest("GET", "/api/v1/urls/promo", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "https://example.com/sale", response["original_url"])
+		assert.Equal(t, "example.com", response["domain"])
+		assert.Equal(t, "2024-05-01T12:00:00Z", response["created_at"])
+		assert.Equal(t, "2030-01-01T00:00:00Z", response["expires_at"])
+		assert.Equal(t, float64(12), response["access_count"])
+		assert.Equal(t, "paused", response["status"])
+		mockService.AssertExpectations(t)
+		mockService.AssertNotCalled(t, "GetOriginalURL", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Link of another workspace", func(t *testing.T) {
+		controller, mockService, router := setupTestController()
+		mockService.On("GetURL", "promo").Return(link, nil)
+		router.GET("/api/v1/urls/:shortCode", withPrincipal(&auth.Principal{UserID: &otherID}), controller.GetURL)
+
+		req := httptest.NewRequest("GET", "/api/v1/urls/promo", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("Unknown code", func(t *testing.T) {
+		controller, mockService, router := setupTestController()
+		mockService.On("GetURL", "nope42").Return(nil, service.ErrURLNotFound)
+		router.GET("/api/v1/urls/:shortCode", withPrincipal(&auth.Principal{UserID: &viewerID}), controller.GetURL)
+
+		req := httptest.NewRequest("GET", "/api/v1/urls/nope42", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestGetURLStatsEndpoint(t *testing.T) {
+	viewerID, workspaceID := uint(8), uint(1)
+	link := &models.URL{ShortCode: "promo", WorkspaceID: &workspaceID, AccessCount: 12}
+
+	controller, mockService, router := setupTestController()
+	mockService.On("GetURL", "promo").Return(link, nil)
+	mockService.On("GetVariantStats", link).Return([]models.VariantMetric{
+		{Variant: "a", Clicks: 9},
+		{Variant: "b", Clicks: 3},
+	}, nil)
+	mockService.On("GetSourceStats", link).Return([]models.SourceMetric{
+		{Source: models.ClickSourceQR, Clicks: 8},
+		{Source: models.ClickSourceDirect, Clicks: 4},
+	}, nil)
+
+	principal := &auth.Principal{UserID: &viewerID, WorkspaceID: &workspaceID}
+	router.GET("/api/v1/urls/:shortCode/stats", withPrincipal(principal), controller.GetURLStats)
+
+	req := httptest.NewRequest("GET", "/api/v1/urls/promo/stats", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, map[string]interface{}{
+		"short_code":   "promo",
+		"access_count": float64(12),
+		"variants": []interface{}{
+			map[string]interface{}{"variant": "a", "clicks": float64(9)},
+			map[string]interface{}{"variant": "b", "clicks": float64(3)},
+		},
+		"sources": []interface{}{
+			map[string]interface{}{"source": "qr", "clicks": float64(8)},
+			map[string]interface{}{"source": "direct", "clicks": float64(4)},
+		},
+	}, response)
+	mockService.AssertExpectations(t)
+}
+
+func TestGetQRCodeEndpoint(t *testing.T) {
+	ownerID := uint(7)
+	link := &models.URL{ShortCode: "abc123", OwnerID: &ownerID}
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedType   string
+	}{
+		{"PNG by default", "", http.StatusOK, "image/png"},
+		{"SVG with options", "?format=svg&size=512&level=h&margin=2&fg=1a73e8&bg=fff", http.StatusOK, "image/svg+xml"},
+		{"Size out of range", "?size=10000", http.StatusBadRequest, ""},
+		{"Unknown level", "?level=X", http.StatusBadRequest, ""},
+		{"Bad colour", "?fg=blue", http.StatusBadRequest, ""},
+		{"Unknown format", "?format=gif", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, mockService, router := setupTestController()
+			mockService.On("GetURL", "abc123").Return(link, nil).Maybe()
+			router.GET("/api/v1/urls/:shortCode/qr", withPrincipal(&auth.Principal{UserID: &ownerID}), controller.GetQRCode)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/urls/abc123/qr"+tt.query, nil))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedType != "" {
+				assert.Equal(t, tt.expectedType, w.Header().Get("Content-Type"))
+			}
+		})
+	}
+
+	t.Run("SVG encodes the tagged short URL", func(t *testing.T) {
+		controller, mockService, router := setupTestController()
+		mockService.On("GetURL", "abc123").Return(link, nil)
+		router.GET("/api/v1/urls/:shortCode/qr", withPrincipal(&auth.Principal{UserID: &ownerID}), controller.GetQRCode)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/urls/abc123/qr?format=svg&fg=1a73e8", nil))
+
+		opts := qr.DefaultOptions()
+		opts.Foreground = color.RGBA{R: 0x1a, G: 0x73, B: 0xe8, A: 0xff}
+		expected, err := qr.SVG("http://localhost:8080/abc123?qr=1", opts)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, string(expected), w.Body.String())
+	})
+}
+
+func TestQRScanRedirect(t *testing.T) {
+	controller, mockService, router := setupTestController()
+	mockService.On("GetOriginalURL", "abc123", mock.MatchedBy(func(v service.Visit) bool {
+		return v.Source == models.ClickSourceQR && v.Query.Encode() == "ref=flyer"
+	})).Return(&service.Redirect{Location: "https://example.com/page?ref=flyer", StatusCode: http.StatusFound}, nil)
+	router.GET("/:shortCode", controller.RedirectURL)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/abc123?qr=1&ref=flyer", nil))
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	mockService.AssertExpectations(t)
 }
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"urlshortner/auth"
+	"urlshortner/models"
+	"urlshortner/problem"
+	"urlshortner/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UTMController manages the UTM templates of the caller's workspace.
+type UTMController struct {
+	utmService service.UTMService
+	authorizer auth.Authorizer
+}
+
+func NewUTMController(utmService service.UTMService, authorizer auth.Authorizer) *UTMController {
+	return &UTMController{
+		utmService: utmService,
+		authorizer: authorizer,
+	}
+}
+
+type utmTemplateResponse struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	Source    string    `json:"source"`
+	Medium    string    `json:"medium"`
+	Campaign  string    `json:"campaign"`
+	Term      string    `json:"term"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func toUTMTemplateResponse(template *models.UTMTemplate) utmTemplateResponse {
+	return utmTemplateResponse{
+		ID:        template.ID,
+		Name:      template.Name,
+		Source:    template.Source,
+		Medium:    template.Medium,
+		Campaign:  template.Campaign,
+		Term:      template.Term,
+		Content:   template.Content,
+		CreatedAt: template.CreatedAt,
+	}
+}
+
+// workspace returns the caller's workspace after checking that they may
+// perform action on its templates.
+func (c *UTMController) workspace(ctx *gin.Context, action auth.Action) (uint, bool) {
+	principal := currentPrincipal(ctx)
+	if principal.WorkspaceID == nil {
+		problem.Write(ctx, http.StatusForbidden, problem.Forbidden, "API key is not bound to a workspace")
+		return 0, false
+	}
+	if !authorize(ctx, c.authorizer, action, principal.Space()) {
+		return 0, false
+	}
+	return *principal.WorkspaceID, true
+}
+
+func (c *UTMController) CreateTemplate(ctx *gin.Context) {
+	workspaceID, ok := c.workspace(ctx, auth.ActionManageTemplates)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name     string `json:"name" binding:"required"`
+		Source   string `json:"source" binding:"required"`
+		Medium   string `json:"medium"`
+		Campaign string `json:"campaign"`
+		Term     string `json:"term"`
+		Content  string `json:"content"`
+	}
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid request")
+		return
+	}
+
+	template := &models.UTMTemplate{
+		WorkspaceID: workspaceID,
+		Name:        request.Name,
+		Source:      request.Source,
+		Medium:      request.Medium,
+		Campaign:    request.Campaign,
+		Term:        request.Term,
+		Content:     request.Content,
+	}
+	err := c.utmService.CreateTemplate(ctx.Request.Context(), template)
+	if errors.Is(err, service.ErrInvalidUTMTemplate) {
+		problem.Write(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid request")
+		return
+	}
+	if errors.Is(err, service.ErrUTMTemplateExists) {
+		problem.Write(ctx, http.StatusConflict, problem.Conflict, "UTM template already exists")
+		return
+	}
+	if err != nil {
+		problem.Write(ctx, http.StatusInternalServerError, problem.Internal, "Failed to create UTM template")
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, toUTMTemplateResponse(template))
+}
+
+func (c *UTMController) ListTemplates(ctx *gin.Context) {
+	workspaceID, ok := c.workspace(ctx, auth.ActionViewStats)
+	if !ok {
+		return
+	}
+
+	templates, err := c.utmService.ListTemplates(ctx.Request.Context(), workspaceID)
+	if err != nil {
+		problem.Write(ctx, http.StatusInternalServerError, problem.Internal, "Failed to list UTM templates")
+		return
+	}
+
+	items := make([]utmTemplateResponse, 0, len(templates))
+	for i := range templates {
+		items = append(items, toUTMTemplateResponse(&templates[i]))
+	}
+	ctx.JSON(http.StatusOK, gin.H{"utm_templates": items})
+}
+
+func (c *UTMController) DeleteTemplate(ctx *gin.Context) {
+	workspaceID, ok := c.workspace(ctx, auth.ActionManageTemplates)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		problem.Write(ctx, http.StatusNotFound, problem.NotFound, "UTM template not found")
+		return
+	}
+
+	err = c.utmService.DeleteTemplate(ctx.Request.Context(), workspaceID, uint(id))
+	if errors.Is(err, service.ErrUTMTemplateNotFound) {
+		problem.Write(ctx, http.StatusNotFound, problem.NotFound, "UTM template not found")
+		return
+	}
+	if err != nil {
+		problem.Write(ctx, http.StatusInternalServerError, problem.Internal, "Failed to delete UTM template")
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"urlshortner/auth"
+	"urlshortner/models"
+	"urlshortner/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockUTMService struct {
+	mock.Mock
+}
+
+func (m *MockUTMService) CreateTemplate(ctx context.Context, template *models.UTMTemplate) error {
+	args := m.Called(template)
+	return args.Error(0)
+}
+
+func (m *MockUTMService) ListTemplates(ctx context.Context, workspaceID uint) ([]models.UTMTemplate, error) {
+	args := m.Called(workspaceID)
+	return args.Get(0).([]models.UTMTemplate), args.Error(1)
+}
+
+func (m *MockUTMService) DeleteTemplate(ctx context.Context, workspaceID, id uint) error {
+	args := m.Called(workspaceID, id)
+	return args.Error(0)
+}
+
+func setupTestUTMController(principal *auth.Principal) (*MockUTMService, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+	mockService := new(MockUTMService)
+	roles := stubRoles{{1, 7}: models.RoleEditor, {1, 8}: models.RoleViewer}
+	controller := NewUTMController(mockService, auth.NewAuthorizer(roles))
+
+	router := gin.New()
+	templates := router.Group("/api/v1/utm-templates", withPrincipal(principal))
+	templates.POST("", controller.CreateTemplate)
+	templates.GET("", controller.ListTemplates)
+	templates.DELETE("/:id", controller.DeleteTemplate)
+	return mockService, router
+}
+
+func TestCreateTemplateEndpoint(t *testing.T) {
+	editorID, viewerID, workspaceID := uint(7), uint(8), uint(1)
+	body := `{"name": "newsletter", "source": "newsletter", "medium": "email", "campaign": "spring"}`
+
+	tests := []struct {
+		name           string
+		principal      *auth.Principal
+		body           string
+		setupMock      func(*MockUTMService)
+		expectedStatus int
+	}{
+		{
+			name:      "Editor creates a template",
+			principal: &auth.Principal{UserID: &editorID, WorkspaceID: &workspaceID},
+			body:      body,
+			setupMock: func(m *MockUTMService) {
+				m.On("CreateTemplate", &models.UTMTemplate{
+					WorkspaceID: 1,
+					Name:        "newsletter",
+					Source:      "newsletter",
+					Medium:      "email",
+					Campaign:    "spring",
+				}).Return(nil)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:      "Name already taken",
+			principal: &auth.Principal{UserID: &editorID, WorkspaceID: &workspaceID},
+			body:      body,
+			setupMock: func(m *MockUTMService) {
+				m.On("CreateTemplate", mock.Anything).Return(service.ErrUTMTemplateExists)
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Source is required",
+			principal:      &auth.Principal{UserID: &editorID, WorkspaceID: &workspaceID},
+			body:           `{"name": "newsletter"}`,
+			setupMock:      func(m *MockUTMService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Viewer cannot create",
+			principal:      &auth.Principal{UserID: &viewerID, WorkspaceID: &workspaceID},
+			body:           body,
+			setupMock:      func(m *MockUTMService) {},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Key outside a workspace",
+			principal:      &auth.Principal{UserID: &editorID},
+			body:           body,
+			setupMock:      func(m *MockUTMService) {},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService, router := setupTestUTMController(tt.principal)
+			tt.setupMock(mockService)
+
+			req := httptest.NewRequest("POST", "/api/v1/utm-templates", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListTemplatesEndpoint(t *testing.T) {
+	viewerID, workspaceID := uint(8), uint(1)
+	mockService, router := setupTestUTMController(&auth.Principal{UserID: &viewerID, WorkspaceID: &workspaceID})
+	mockService.On("ListTemplates", workspaceID).Return([]models.UTMTemplate{
+		{ID: 3, WorkspaceID: 1, Name: "newsletter", Source: "newsletter", Medium: "email"},
+	}, nil)
+
+	req := httptest.NewRequest("GET", "/api/v1/utm-templates", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Templates []utmTemplateResponse `json:"utm_templates"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	if assert.Len(t, response.Templates, 1) {
+		assert.Equal(t, "email", response.Templates[0].Medium)
+	}
+}
+
+func TestDeleteTemplateEndpoint(t *testing.T) {
+	editorID, workspaceID := uint(7), uint(1)
+	mockService, router := setupTestUTMController(&auth.Principal{UserID: &editorID, WorkspaceID: &workspaceID})
+	mockService.On("DeleteTemplate", workspaceID, uint(3)).Return(nil)
+	mockService.On("DeleteTemplate", workspaceID, uint(4)).Return(service.ErrUTMTemplateNotFound)
+
+	for id, expectedStatus := range map[string]int{"3": http.StatusNoContent, "4": http.StatusNotFound, "x": http.StatusNotFound} {
+		req := httptest.NewRequest("DELETE", "/api/v1/utm-templates/"+id, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, expectedStatus, w.Code, id)
+	}
+	mockService.AssertExpectations(t)
+}
@@ -0,0 +1,46 @@
+// Package geo resolves client IP addresses to countries.
+package geo
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Locator finds the country an IP address is in.
+type Locator interface {
+	// Country returns the ISO 3166-1 alpha-2 code of the country ip is
+	// located in, or "" if it is unknown.
+	Country(ip net.IP) (string, error)
+}
+
+// Database is a Locator backed by a MaxMind-format (.mmdb) file, such as
+// GeoLite2-Country or GeoIP2-City. It is safe for concurrent use.
+type Database struct {
+	reader *maxminddb.Reader
+}
+
+// OpenDatabase loads the database file at path.
+func OpenDatabase(path string) (*Database, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Database{reader: reader}, nil
+}
+
+func (d *Database) Country(ip net.IP) (string, error) {
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := d.reader.Lookup(ip, &record); err != nil {
+		return "", err
+	}
+	return record.Country.ISOCode, nil
+}
+
+func (d *Database) Close() error {
+	return d.reader.Close()
+}
@@ -0,0 +1,414 @@
+// Package linkio reads and writes links as CSV or NDJSON, for exporting
+// them and importing them from elsewhere.
+package linkio
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"urlshortner/models"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+var ErrUnknownFormat = errors.New("unknown format, use csv or ndjson")
+
+// RecordError reports a malformed record. Reading can go on past it; any
+// other error from a Reader ends the input.
+type RecordError struct {
+	Line int
+	Err  error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// Record is one link as exported or imported, with every setting needed to
+// recreate it. Domain is informational and ignored on import. PasswordHash
+// is the bcrypt hash of the link's password, never the password itself.
+type Record struct {
+	ShortCode    string     `json:"short_code"`
+	OriginalURL  string     `json:"original_url"`
+	Domain       string     `json:"domain,omitempty"`
+	Status       string     `json:"status,omitempty"`
+	CreatedAt    *time.Time `json:"created_at,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	ActiveFrom   *time.Time `json:"active_from,omitempty"`
+	ActiveUntil  *time.Time `json:"active_until,omitempty"`
+	RedirectCode int        `json:"redirect_code,omitempty"`
+	MaxClicks    *int       `json:"max_clicks,omitempty"`
+	PasswordHash string     `json:"password_hash,omitempty"`
+	Preview      bool       `json:"preview,omitempty"`
+
+	DeviceRules      models.DeviceRules `json:"device_rules,omitempty"`
+	GeoRules         models.GeoRules    `json:"geo_rules,omitempty"`
+	Variants         models.Variants    `json:"variants,omitempty"`
+	StickyVariants   bool               `json:"sticky_variants,omitempty"`
+	PassthroughPath  bool               `json:"passthrough_path,omitempty"`
+	PassthroughQuery bool               `json:"passthrough_query,omitempty"`
+	QueryConflict    string             `json:"query_conflict,omitempty"`
+
+	AccessCount int `json:"access_count"`
+}
+
+// RecordOf describes an existing link.
+func RecordOf(url *models.URL) Record {
+	return Record{
+		ShortCode:    url.ShortCode,
+		OriginalURL:  url.OriginalURL,
+		Domain:       url.Domain,
+		Status:       url.Status,
+		CreatedAt:    &url.CreatedAt,
+		ExpiresAt:    url.ExpiresAt,
+		ActiveFrom:   url.ActiveFrom,
+		ActiveUntil:  url.ActiveUntil,
+		RedirectCode: url.RedirectCode,
+		MaxClicks:    url.MaxClicks,
+		PasswordHash: url.PasswordHash,
+		Preview:      url.Preview,
+
+		DeviceRules:      url.DeviceRules,
+		GeoRules:         url.GeoRules,
+		Variants:         url.Variants,
+		StickyVariants:   url.StickyVariants,
+		PassthroughPath:  url.PassthroughPath,
+		PassthroughQuery: url.PassthroughQuery,
+		QueryConflict:    url.QueryConflict,
+
+		AccessCount: url.AccessCount,
+	}
+}
+
+// columns are the CSV header, in the order written. Rules and variants are
+// written as JSON arrays, flags as true or empty.
+var columns = []string{
+	"short_code", "original_url", "domain", "status", "created_at", "expires_at",
+	"active_from", "active_until", "redirect_code", "max_clicks", "password_hash",
+	"preview", "device_rules", "geo_rules", "variants", "sticky_variants",
+	"passthrough_path", "passthrough_query", "query_conflict", "access_count",
+}
+
+// Writer writes records one at a time. Flush must be called at the end.
+type Writer interface {
+	Write(record Record) error
+	Flush() error
+}
+
+// NewWriter returns a Writer for format.
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case FormatNDJSON:
+		buf := bufio.NewWriter(w)
+		return &ndjsonWriter{buf: buf, enc: json.NewEncoder(buf)}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+type csvWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (cw *csvWriter) Write(record Record) error {
+	if !cw.headerWritten {
+		if err := cw.w.Write(columns); err != nil {
+			return err
+		}
+		cw.headerWritten = true
+	}
+	deviceRules, err := formatJSON(record.DeviceRules, len(record.DeviceRules) == 0)
+	if err != nil {
+		return err
+	}
+	geoRules, err := formatJSON(record.GeoRules, len(record.GeoRules) == 0)
+	if err != nil {
+		return err
+	}
+	variants, err := formatJSON(record.Variants, len(record.Variants) == 0)
+	if err != nil {
+		return err
+	}
+	return cw.w.Write([]string{
+		record.ShortCode,
+		record.OriginalURL,
+		record.Domain,
+		record.Status,
+		formatTime(record.CreatedAt),
+		formatTime(record.ExpiresAt),
+		formatTime(record.ActiveFrom),
+		formatTime(record.ActiveUntil),
+		formatInt(record.RedirectCode),
+		formatIntPtr(record.MaxClicks),
+		record.PasswordHash,
+		formatBool(record.Preview),
+		deviceRules,
+		geoRules,
+		variants,
+		formatBool(record.StickyVariants),
+		formatBool(record.PassthroughPath),
+		formatBool(record.PassthroughQuery),
+		record.QueryConflict,
+		strconv.Itoa(record.AccessCount),
+	})
+}
+
+func (cw *csvWriter) Flush() error {
+	if !cw.headerWritten {
+		if err := cw.w.Write(columns); err != nil {
+			return err
+		}
+		cw.headerWritten = true
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type ndjsonWriter struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func (nw *ndjsonWriter) Write(record Record) error {
+	return nw.enc.Encode(record)
+}
+
+func (nw *ndjsonWriter) Flush() error {
+	return nw.buf.Flush()
+}
+
+// Reader reads records one at a time, returning io.EOF at the end and a
+// *RecordError for each malformed record.
+type Reader interface {
+	Read() (Record, error)
+	// Line is the line number the last record read starts on.
+	Line() int
+}
+
+// NewReader returns a Reader for format. CSV input must start with a header
+// naming its columns, which may come in any order; short_code and
+// original_url are required.
+func NewReader(r io.Reader, format string) (Reader, error) {
+	switch format {
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		header, err := cr.Read()
+		if err != nil {
+			return nil, fmt.Errorf("reading CSV header: %w", err)
+		}
+		index := make(map[string]int, len(header))
+		for i, name := range header {
+			index[strings.TrimSpace(strings.ToLower(name))] = i
+		}
+		for _, required := range []string{"short_code", "original_url"} {
+			if _, ok := index[required]; !ok {
+				return nil, fmt.Errorf("CSV header lacks the %s column", required)
+			}
+		}
+		return &csvReader{r: cr, index: index, line: 1}, nil
+	case FormatNDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		return &ndjsonReader{scanner: scanner}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+type csvReader struct {
+	r     *csv.Reader
+	index map[string]int
+	line  int
+}
+
+func (cr *csvReader) Read() (Record, error) {
+	fields, err := cr.r.Read()
+	if err == io.EOF {
+		return Record{}, err
+	}
+	if fields != nil {
+		cr.line, _ = cr.r.FieldPos(0)
+	}
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			cr.line = parseErr.StartLine
+			return Record{}, &RecordError{Line: cr.line, Err: parseErr.Err}
+		}
+		return Record{}, err
+	}
+
+	field := func(name string) string {
+		if i, ok := cr.index[name]; ok && i < len(fields) {
+			return strings.TrimSpace(fields[i])
+		}
+		return ""
+	}
+	record := Record{
+		ShortCode:     field("short_code"),
+		OriginalURL:   field("original_url"),
+		Domain:        field("domain"),
+		Status:        field("status"),
+		PasswordHash:  field("password_hash"),
+		QueryConflict: field("query_conflict"),
+	}
+	for name, target := range map[string]**time.Time{
+		"created_at":   &record.CreatedAt,
+		"expires_at":   &record.ExpiresAt,
+		"active_from":  &record.ActiveFrom,
+		"active_until": &record.ActiveUntil,
+	} {
+		if *target, err = parseTime(field(name)); err != nil {
+			return Record{}, cr.invalid(name, err)
+		}
+	}
+	if record.RedirectCode, err = parseInt(field("redirect_code")); err != nil {
+		return Record{}, cr.invalid("redirect_code", err)
+	}
+	if value := field("max_clicks"); value != "" {
+		maxClicks, err := strconv.Atoi(value)
+		if err != nil {
+			return Record{}, cr.invalid("max_clicks", err)
+		}
+		record.MaxClicks = &maxClicks
+	}
+	for name, target := range map[string]*bool{
+		"preview":           &record.Preview,
+		"sticky_variants":   &record.StickyVariants,
+		"passthrough_path":  &record.PassthroughPath,
+		"passthrough_query": &record.PassthroughQuery,
+	} {
+		if *target, err = parseBool(field(name)); err != nil {
+			return Record{}, cr.invalid(name, err)
+		}
+	}
+	for name, target := range map[string]interface{}{
+		"device_rules": &record.DeviceRules,
+		"geo_rules":    &record.GeoRules,
+		"variants":     &record.Variants,
+	} {
+		if value := field(name); value != "" {
+			if err := json.Unmarshal([]byte(value), target); err != nil {
+				return Record{}, cr.invalid(name, err)
+			}
+		}
+	}
+	if record.AccessCount, err = parseInt(field("access_count")); err != nil {
+		return Record{}, cr.invalid("access_count", err)
+	}
+	return record, nil
+}
+
+func (cr *csvReader) Line() int {
+	return cr.line
+}
+
+func (cr *csvReader) invalid(column string, err error) error {
+	return &RecordError{Line: cr.line, Err: fmt.Errorf("%s: %w", column, err)}
+}
+
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (nr *ndjsonReader) Read() (Record, error) {
+	for nr.scanner.Scan() {
+		nr.line++
+		line := strings.TrimSpace(nr.scanner.Text())
+		if line == "" {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return Record{}, &RecordError{Line: nr.line, Err: err}
+		}
+		return record, nil
+	}
+	if err := nr.scanner.Err(); err != nil {
+		return Record{}, err
+	}
+	return Record{}, io.EOF
+}
+
+func (nr *ndjsonReader) Line() int {
+	return nr.line
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatInt(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+func formatIntPtr(n *int) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.Itoa(*n)
+}
+
+func formatBool(b bool) string {
+	if !b {
+		return ""
+	}
+	return "true"
+}
+
+func formatJSON(v interface{}, empty bool) (string, error) {
+	if empty {
+		return "", nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func parseTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func parseInt(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func parseBool(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
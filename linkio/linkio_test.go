@@ -0,0 +1,131 @@
+package linkio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+	"urlshortner/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func roundTrip(t *testing.T, format string, records ...Record) []Record {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, format)
+	require.NoError(t, err)
+	for _, record := range records {
+		require.NoError(t, w.Write(record))
+	}
+	require.NoError(t, w.Flush())
+
+	r, err := NewReader(&buf, format)
+	require.NoError(t, err)
+	var read []Record
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		read = append(read, record)
+	}
+	return read
+}
+
+func TestRoundTrip(t *testing.T) {
+	created := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	activeFrom := created.Add(time.Hour)
+	maxClicks := 3
+	protected := Record{
+		ShortCode:    "launch",
+		OriginalURL:  "https://example.com/a,b",
+		Domain:       "example.com",
+		Status:       models.URLStatusPaused,
+		CreatedAt:    &created,
+		ActiveFrom:   &activeFrom,
+		RedirectCode: 301,
+		MaxClicks:    &maxClicks,
+		PasswordHash: "$2a$10$abcdefghijklmnopqrstuuVWXYZ0123456789abcdefghijklmnopq",
+		Preview:      true,
+		DeviceRules:  models.DeviceRules{{Platform: "ios", URL: "https://apps.apple.com/app"}},
+		GeoRules:     models.GeoRules{{Countries: []string{"DE", "AT"}, URL: "https://example.de"}},
+		Variants: models.Variants{
+			{Name: "a", URL: "https://example.com/a", Weight: 3},
+			{Name: "b", URL: "https://example.com/b", Weight: 1},
+		},
+		StickyVariants:   true,
+		PassthroughPath:  true,
+		PassthroughQuery: true,
+		QueryConflict:    models.QueryConflictIncoming,
+		AccessCount:      42,
+	}
+	plain := Record{ShortCode: "abc123", OriginalURL: "https://example.com", CreatedAt: &created}
+
+	for _, format := range []string{FormatCSV, FormatNDJSON} {
+		t.Run(format, func(t *testing.T) {
+			assert.Equal(t, []Record{protected, plain}, roundTrip(t, format, protected, plain))
+		})
+	}
+}
+
+func TestCSVHeaderOnlyExport(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, FormatCSV)
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+
+	assert.Equal(t, strings.Join(columns, ",")+"\n", buf.String())
+}
+
+func TestCSVReader(t *testing.T) {
+	t.Run("Columns in any order, unknown ones ignored", func(t *testing.T) {
+		r, err := NewReader(strings.NewReader("original_url,extra,short_code,preview\nhttps://example.com,x,abc123,true\n"), FormatCSV)
+		require.NoError(t, err)
+
+		record, err := r.Read()
+
+		require.NoError(t, err)
+		assert.Equal(t, Record{ShortCode: "abc123", OriginalURL: "https://example.com", Preview: true}, record)
+		assert.Equal(t, 2, r.Line())
+	})
+
+	t.Run("Missing required column", func(t *testing.T) {
+		_, err := NewReader(strings.NewReader("short_code\nabc123\n"), FormatCSV)
+
+		assert.ErrorContains(t, err, "original_url")
+	})
+
+	t.Run("Malformed records are reported by line", func(t *testing.T) {
+		r, err := NewReader(strings.NewReader("short_code,original_url,device_rules,sticky_variants\n"+
+			"a,https://example.com,{oops,\n"+
+			"b,https://example.com,,maybe\n"+
+			"c,https://example.com,,\n"), FormatCSV)
+		require.NoError(t, err)
+
+		for _, want := range []struct {
+			line   int
+			column string
+		}{{2, "device_rules"}, {3, "sticky_variants"}} {
+			_, err := r.Read()
+			var recordErr *RecordError
+			require.True(t, errors.As(err, &recordErr))
+			assert.Equal(t, want.line, recordErr.Line)
+			assert.ErrorContains(t, err, want.column)
+		}
+		record, err := r.Read()
+		require.NoError(t, err)
+		assert.Equal(t, "c", record.ShortCode)
+	})
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := NewWriter(io.Discard, "xml")
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+	_, err = NewReader(strings.NewReader(""), "xml")
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
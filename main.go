@@ -1,9 +1,17 @@
 package main
 
 import (
-	"log"
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"urlshortner/auth"
 	"urlshortner/config"
 	"urlshortner/controllers"
+	"urlshortner/geo"
+	"urlshortner/logging"
+	"urlshortner/middleware"
 	"urlshortner/models"
 	"urlshortner/repository"
 	"urlshortner/service"
@@ -14,24 +22,46 @@ import (
 	"gorm.io/gorm"
 )
 
-func setupRouter(controller *controllers.URLController) *gin.Engine {
-	router := gin.Default()
+func setupRouter(cfg *config.Config, controller *controllers.URLController, keyController *controllers.APIKeyController, utmController *controllers.UTMController, authenticate, idempotent gin.HandlerFunc) *gin.Engine {
+	router := gin.New()
+	router.Use(middleware.RequestID(), middleware.AccessLog(), middleware.Recover())
 
-	router.POST("/api/v1/shorten", controller.ShortenURL)
-	router.GET("/:shortCode", controller.RedirectURL)
-	router.GET("/api/v1/metrics/top-domains", controller.GetTopDomains)
+	visits := router.Group("", middleware.Timeout(cfg.Timeout.Redirect))
+	visits.GET("/:shortCode", controller.RedirectURL)
+	visits.GET("/:shortCode/*path", controller.RedirectURL)
+	visits.POST("/:shortCode", controller.UnlockURL)
+	visits.POST("/:shortCode/*path", controller.UnlockURL)
+
+	// The deadline is set before authenticating, which queries too
+	bulk := router.Group("/api/v1", middleware.Timeout(cfg.Timeout.Bulk), authenticate)
+	bulk.POST("/shorten/batch", middleware.RequireScope(auth.ScopeShorten), idempotent, controller.ShortenBatch)
+	bulk.GET("/export", middleware.RequireScope(auth.ScopeReadStats), controller.ExportURLs)
+	bulk.POST("/import", middleware.RequireScope(auth.ScopeShorten), controller.ImportURLs)
+
+	api := router.Group("/api/v1", middleware.Timeout(cfg.Timeout.API), authenticate)
+	api.POST("/shorten", middleware.RequireScope(auth.ScopeShorten), idempotent, controller.ShortenURL)
+	api.GET("/metrics/top-domains", middleware.RequireScope(auth.ScopeReadStats), controller.GetTopDomains)
+	api.GET("/urls", middleware.RequireScope(auth.ScopeReadStats), controller.ListURLs)
+	api.GET("/urls/:shortCode", middleware.RequireScope(auth.ScopeReadStats), controller.GetURL)
+	api.GET("/urls/:shortCode/stats", middleware.RequireScope(auth.ScopeReadStats), controller.GetURLStats)
+	api.GET("/urls/:shortCode/qr", middleware.RequireScope(auth.ScopeReadStats), controller.GetQRCode)
+	api.PATCH("/urls/:shortCode", middleware.RequireScope(auth.ScopeShorten), controller.UpdateURL)
+	api.DELETE("/urls/:shortCode", middleware.RequireScope(auth.ScopeShorten), controller.DeleteURL)
+
+	keys := api.Group("/keys", middleware.RequireScope(auth.ScopeAdmin))
+	keys.POST("", keyController.CreateKey)
+	keys.GET("", keyController.ListKeys)
+	keys.DELETE("/:id", keyController.RevokeKey)
+
+	templates := api.Group("/utm-templates", middleware.RequireScope(auth.ScopeShorten))
+	templates.POST("", utmController.CreateTemplate)
+	templates.GET("", utmController.ListTemplates)
+	templates.DELETE("/:id", utmController.DeleteTemplate)
 
 	return router
 }
 
-func main() {
-	// Load configuration
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		log.Fatal("Failed to load configuration:", err)
-	}
-
-	// Setup database connection
+func openDatabase(cfg *config.Config) (*gorm.DB, error) {
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
 		cfg.Database.User,
 		cfg.Database.Password,
@@ -40,21 +70,111 @@ func main() {
 		cfg.Database.Name,
 	)
 
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
+		Logger: logging.GormLogger(slog.Default(), cfg.Log.SlowQuery),
+		// Report unique key violations as gorm.ErrDuplicatedKey
+		TranslateError: true,
+	})
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		return nil, err
 	}
 
-	if err := db.AutoMigrate(&models.URL{}); err != nil {
-		log.Fatal("Failed to migrate database:", err)
+	if err := db.AutoMigrate(
+		&models.URL{}, &models.APIKey{}, &models.User{}, &models.Workspace{}, &models.WorkspaceMember{},
+		&models.ClickEvent{}, &models.UTMTemplate{}, &models.IdempotencyKey{},
+	); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func main() {
+	// Load configuration
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fatal("Failed to load configuration", err)
+	}
+	slog.SetDefault(logging.New(os.Stderr, cfg.Log.Format, cfg.Log.Level))
+
+	// Setup database connection
+	db, err := openDatabase(cfg)
+	if err != nil {
+		fatal("Failed to connect to database", err)
+	}
+
+	var locator geo.Locator
+	if cfg.GeoIP.DatabasePath != "" {
+		database, err := geo.OpenDatabase(cfg.GeoIP.DatabasePath)
+		if err != nil {
+			fatal("Failed to open GeoIP database", err)
+		}
+		defer database.Close()
+		locator = database
 	}
 
 	urlRepo := repository.NewURLRepository(db)
-	urlService := service.NewURLService(urlRepo, cfg)
-	urlController := controllers.NewURLController(urlService, cfg)
+	clickRepo := repository.NewClickRepository(db)
+	utmTemplateRepo := repository.NewUTMTemplateRepository(db)
+	apiKeyRepo := repository.NewAPIKeyRepository(db)
+	userRepo := repository.NewUserRepository(db)
+	workspaceRepo := repository.NewWorkspaceRepository(db)
+	idempotencyRepo := repository.NewIdempotencyRepository(db)
+	urlService := service.NewURLService(urlRepo, clickRepo, utmTemplateRepo, workspaceRepo, locator, cfg)
+	apiKeyService := service.NewAPIKeyService(apiKeyRepo)
+	userService := service.NewUserService(userRepo)
+	workspaceService := service.NewWorkspaceService(workspaceRepo, userRepo)
+	utmService := service.NewUTMService(utmTemplateRepo)
+	idempotencyService := service.NewIdempotencyService(idempotencyRepo, cfg.Idempotency.TTL)
 
-	router := setupRouter(urlController)
+	// Admin subcommands run against the same database and exit
+	if len(os.Args) > 1 {
+		services := commandServices{
+			apiKeys:    apiKeyService,
+			users:      userService,
+			workspaces: workspaceService,
+			urls:       urlService,
+		}
+		// Interrupting a command cancels its queries
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := runCommand(ctx, os.Args[1:], services); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	authorizer := auth.NewAuthorizer(workspaceService)
+	urlController := controllers.NewURLController(urlService, authorizer, cfg)
+	keyController := controllers.NewAPIKeyController(apiKeyService, authorizer)
+	utmController := controllers.NewUTMController(utmService, authorizer)
+
+	var tokenService service.TokenService
+	if cfg.JWT.JWKSSource != "" {
+		keySet, err := auth.LoadKeySet(cfg.JWT.JWKSSource)
+		if err != nil {
+			fatal("Failed to load JWKS", err)
+		}
+		verifier := auth.NewJWTVerifier(keySet, cfg.JWT.Issuer, cfg.JWT.Audience)
+		tokenService = service.NewTokenService(verifier, userRepo, cfg)
+	}
+
+	router := setupRouter(cfg, urlController, keyController, utmController,
+		middleware.Authenticate(apiKeyService, tokenService), middleware.Idempotent(idempotencyService))
+	// gin also believes X-Real-IP by default; only X-Forwarded-For is set by
+	// the proxies we trust
+	router.RemoteIPHeaders = []string{"X-Forwarded-For"}
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		fatal("Invalid TRUSTED_PROXIES", err)
+	}
 	if err := router.Run(":" + cfg.Server.Port); err != nil {
-		log.Fatal("Failed to start server:", err)
+		fatal("Failed to start server", err)
 	}
 }
+
+// fatal logs err and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"urlshortner/auth"
+	"urlshortner/problem"
+	"urlshortner/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+const principalKey = "principal"
+
+// Authenticate rejects requests that do not carry a valid
+// "Authorization: Bearer <credential>" header and records the caller for the
+// handlers further down the chain. The credential is either an API key or,
+// if tokens is not nil, a JWT from the identity provider.
+func Authenticate(keys service.APIKeyService, tokens service.TokenService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		rawKey, ok := bearerToken(ctx.GetHeader("Authorization"))
+		if !ok {
+			ctx.Header("WWW-Authenticate", "Bearer")
+			problem.Abort(ctx, http.StatusUnauthorized, problem.Unauthorized, "Missing API key")
+			return
+		}
+
+		if tokens != nil && auth.LooksLikeJWT(rawKey) {
+			principal, err := tokens.AuthenticateToken(ctx.Request.Context(), rawKey)
+			if errors.Is(err, auth.ErrInvalidToken) {
+				ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+				problem.Abort(ctx, http.StatusUnauthorized, problem.Unauthorized, "Invalid token")
+				return
+			}
+			if err != nil {
+				problem.Abort(ctx, http.StatusInternalServerError, problem.Internal, "Failed to authenticate")
+				return
+			}
+			SetPrincipal(ctx, principal)
+			ctx.Next()
+			return
+		}
+
+		key, err := keys.Authenticate(ctx.Request.Context(), rawKey)
+		if errors.Is(err, service.ErrInvalidAPIKey) {
+			ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			problem.Abort(ctx, http.StatusUnauthorized, problem.Unauthorized, "Invalid API key")
+			return
+		}
+		if err != nil {
+			problem.Abort(ctx, http.StatusInternalServerError, problem.Internal, "Failed to authenticate")
+			return
+		}
+
+		SetPrincipal(ctx, &auth.Principal{
+			APIKeyID:    key.ID,
+			Name:        key.Name,
+			Scopes:      key.ScopeList(),
+			UserID:      key.UserID,
+			WorkspaceID: key.WorkspaceID,
+		})
+		ctx.Next()
+	}
+}
+
+// RequireScope must run after Authenticate.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		principal, ok := CurrentPrincipal(ctx)
+		if !ok || !principal.HasScope(scope) {
+			problem.Abort(ctx, http.StatusForbidden, problem.Forbidden, "Insufficient scope")
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// SetPrincipal records the authenticated caller of the request.
+func SetPrincipal(ctx *gin.Context, principal *auth.Principal) {
+	ctx.Set(principalKey, principal)
+}
+
+// CurrentPrincipal returns the caller authenticated by Authenticate.
+func CurrentPrincipal(ctx *gin.Context) (*auth.Principal, bool) {
+	value, exists := ctx.Get(principalKey)
+	if !exists {
+		return nil, false
+	}
+	principal, ok := value.(*auth.Principal)
+	return principal, ok
+}
+
+func bearerToken(header string) (string, bool) {
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
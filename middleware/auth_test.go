@@ -0,0 +1,221 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"urlshortner/auth"
+	"urlshortner/models"
+	"urlshortner/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockAPIKeyService struct {
+	mock.Mock
+}
+
+func (m *MockAPIKeyService) CreateKey(ctx context.Context, name string, scopes []string, userID, workspaceID *uint) (*models.APIKey, string, error) {
+	args := m.Called(name, scopes, userID, workspaceID)
+	if args.Get(0) == nil {
+		return nil, "", args.Error(2)
+	}
+	return args.Get(0).(*models.APIKey), args.String(1), args.Error(2)
+}
+
+func (m *MockAPIKeyService) Authenticate(ctx context.Context, rawKey string) (*models.APIKey, error) {
+	args := m.Called(rawKey)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) GetKey(ctx context.Context, id uint) (*models.APIKey, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) ListKeys(ctx context.Context) ([]models.APIKey, error) {
+	args := m.Called()
+	return args.Get(0).([]models.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) ListWorkspaceKeys(ctx context.Context, workspaceID uint) ([]models.APIKey, error) {
+	args := m.Called(workspaceID)
+	return args.Get(0).([]models.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) RevokeKey(ctx context.Context, id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+type MockTokenService struct {
+	mock.Mock
+}
+
+func (m *MockTokenService) AuthenticateToken(ctx context.Context, token string) (*auth.Principal, error) {
+	args := m.Called(token)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*auth.Principal), args.Error(1)
+}
+
+func setupTestRouter(keys service.APIKeyService, tokens service.TokenService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api := router.Group("/api/v1", Authenticate(keys, tokens))
+	api.POST("/shorten", RequireScope("shorten"), func(ctx *gin.Context) {
+		principal, _ := CurrentPrincipal(ctx)
+		ctx.String(http.StatusOK, principal.Name)
+	})
+	return router
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	tests := []struct {
+		name           string
+		authorization  string
+		setupMock      func(*MockAPIKeyService)
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:          "Valid key with scope",
+			authorization: "Bearer us_shorten",
+			setupMock: func(m *MockAPIKeyService) {
+				m.On("Authenticate", "us_shorten").Return(&models.APIKey{ID: 1, Name: "ci", Scopes: "shorten"}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ci",
+		},
+		{
+			name:          "Admin key implies every scope",
+			authorization: "bearer us_admin",
+			setupMock: func(m *MockAPIKeyService) {
+				m.On("Authenticate", "us_admin").Return(&models.APIKey{ID: 2, Name: "ops", Scopes: "admin"}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ops",
+		},
+		{
+			name:          "Valid key without scope",
+			authorization: "Bearer us_stats",
+			setupMock: func(m *MockAPIKeyService) {
+				m.On("Authenticate", "us_stats").Return(&models.APIKey{ID: 3, Name: "dash", Scopes: "read-stats"}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:          "Invalid key",
+			authorization: "Bearer us_bogus",
+			setupMock: func(m *MockAPIKeyService) {
+				m.On("Authenticate", "us_bogus").Return(nil, service.ErrInvalidAPIKey)
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:          "Key lookup failure",
+			authorization: "Bearer us_shorten",
+			setupMock: func(m *MockAPIKeyService) {
+				m.On("Authenticate", "us_shorten").Return(nil, &service.StorageError{Err: errors.New("database error")})
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "Missing header",
+			setupMock:      func(m *MockAPIKeyService) {},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Wrong scheme",
+			authorization:  "Basic dXNlcjpwYXNz",
+			setupMock:      func(m *MockAPIKeyService) {},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockAPIKeyService)
+			tt.setupMock(mockService)
+			router := setupTestRouter(mockService, nil)
+
+			req := httptest.NewRequest("POST", "/api/v1/shorten", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, w.Body.String())
+			}
+			if tt.expectedStatus == http.StatusUnauthorized {
+				assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+			}
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	const jwt = "eyJhbGciOiJSUzI1NiJ9.eyJzdWIiOiJhYmMifQ.c2ln"
+
+	tests := []struct {
+		name           string
+		setupMock      func(*MockTokenService)
+		expectedStatus int
+	}{
+		{
+			name: "Valid token",
+			setupMock: func(m *MockTokenService) {
+				m.On("AuthenticateToken", jwt).Return(&auth.Principal{Name: "svc", Scopes: []string{"shorten"}}, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Invalid token",
+			setupMock: func(m *MockTokenService) {
+				m.On("AuthenticateToken", jwt).Return(nil, fmt.Errorf("%w: token expired", auth.ErrInvalidToken))
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "User lookup failure",
+			setupMock: func(m *MockTokenService) {
+				m.On("AuthenticateToken", jwt).Return(nil, fmt.Errorf("database error"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyService := new(MockAPIKeyService)
+			tokenService := new(MockTokenService)
+			tt.setupMock(tokenService)
+			router := setupTestRouter(keyService, tokenService)
+
+			req := httptest.NewRequest("POST", "/api/v1/shorten", nil)
+			req.Header.Set("Authorization", "Bearer "+jwt)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			tokenService.AssertExpectations(t)
+			keyService.AssertNotCalled(t, "Authenticate", mock.Anything)
+		})
+	}
+}
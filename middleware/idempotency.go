@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"urlshortner/auth"
+	"urlshortner/problem"
+	"urlshortner/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// maxIdempotencyKeyLength matches the database column.
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentBodySize bounds the request bodies read into memory to
+	// hash them, well above that of the largest batch.
+	maxIdempotentBodySize = 8 << 20
+)
+
+// Idempotent makes retries of a request sent with an Idempotency-Key header
+// get the response of the first attempt instead of repeating it. Reusing a
+// key for a different request is refused with 422, and retrying while the
+// first attempt is still running with 409. Server errors are not recorded,
+// so such requests can be retried for real. Callers without a stable
+// identity, such as tokens without a subject, cannot use keys. Must run
+// after Authenticate.
+func Idempotent(idempotency service.IdempotencyService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(idempotencyKeyHeader)
+		if key == "" {
+			ctx.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			problem.Abort(ctx, http.StatusBadRequest, problem.InvalidRequest, "Idempotency-Key is too long")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxIdempotentBodySize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			problem.Abort(ctx, http.StatusRequestEntityTooLarge, problem.TooLarge,
+				fmt.Sprintf("Requests with an Idempotency-Key may have at most %d bytes", tooLarge.Limit))
+			return
+		}
+		if err != nil {
+			problem.Abort(ctx, http.StatusBadRequest, problem.InvalidRequest, "Invalid request")
+			return
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		principal, _ := CurrentPrincipal(ctx)
+		caller, ok := callerOf(principal)
+		if !ok {
+			problem.Abort(ctx, http.StatusBadRequest, problem.InvalidRequest, "Idempotency-Key needs a caller with a stable identity")
+			return
+		}
+		record, replay, err := idempotency.Begin(ctx.Request.Context(), caller, key, requestHash(ctx.Request, body))
+		switch {
+		case errors.Is(err, service.ErrIdempotencyKeyReused):
+			problem.Abort(ctx, http.StatusUnprocessableEntity, problem.IdempotencyKeyReused, "Idempotency-Key was used for a different request")
+			return
+		case errors.Is(err, service.ErrIdempotencyKeyInProgress):
+			problem.Abort(ctx, http.StatusConflict, problem.Conflict, "A request with this Idempotency-Key is in progress")
+			return
+		case err != nil:
+			problem.Abort(ctx, http.StatusInternalServerError, problem.Internal, "Failed to check Idempotency-Key")
+			return
+		case replay:
+			ctx.Header("Idempotent-Replayed", "true")
+			ctx.Data(record.StatusCode, record.ContentType, record.Body)
+			ctx.Abort()
+			return
+		}
+
+		// The outcome is stored even if the request timed out or the client
+		// went away: either way the handler may have done its work
+		settle := context.WithoutCancel(ctx.Request.Context())
+		recorder := &responseRecorder{ResponseWriter: ctx.Writer}
+		ctx.Writer = recorder
+		finished := false
+		// Release the key if the handler panics, or it stays in progress
+		// until it expires
+		defer func() {
+			if !finished {
+				if err := idempotency.Abandon(settle, record); err != nil {
+					// Log error but don't fail the request
+					slog.ErrorContext(ctx.Request.Context(), "Failed to release idempotency key", "error", err)
+				}
+			}
+		}()
+
+		ctx.Next()
+
+		finished = true
+		if recorder.Status() >= http.StatusInternalServerError {
+			if err := idempotency.Abandon(settle, record); err != nil {
+				// Log error but don't fail the request
+				slog.ErrorContext(ctx.Request.Context(), "Failed to release idempotency key", "error", err)
+			}
+			return
+		}
+		if err := idempotency.Finish(settle, record, recorder.Status(), recorder.Header().Get("Content-Type"), recorder.body.Bytes()); err != nil {
+			// Log error but don't fail the request
+			slog.ErrorContext(ctx.Request.Context(), "Failed to store idempotent response", "error", err)
+		}
+	}
+}
+
+// callerOf identifies the sender of a key: the API key, or the issuer,
+// user and subject of a token, hashed to fit the column. It reports false
+// for callers that cannot be told apart from others.
+func callerOf(principal *auth.Principal) (string, bool) {
+	if principal == nil {
+		return "", false
+	}
+	if principal.APIKeyID != 0 {
+		return fmt.Sprintf("key:%d", principal.APIKeyID), true
+	}
+	if principal.Name == "" {
+		return "", false
+	}
+	user := "-"
+	if principal.UserID != nil {
+		user = fmt.Sprint(*principal.UserID)
+	}
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%q %s %q", principal.Issuer, user, principal.Name)))
+	return "token:" + hex.EncodeToString(hash[:]), true
+}
+
+// requestHash identifies a request by method, path, query and body.
+func requestHash(req *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", req.Method, req.URL.RequestURI())
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder keeps a copy of the response body as it is written.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
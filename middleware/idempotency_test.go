@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"urlshortner/auth"
+	"urlshortner/models"
+	"urlshortner/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockIdempotencyService struct {
+	mock.Mock
+}
+
+func (m *MockIdempotencyService) Begin(ctx context.Context, caller, key, requestHash string) (*models.IdempotencyKey, bool, error) {
+	args := m.Called(caller, key, requestHash)
+	if args.Get(0) == nil {
+		return nil, false, args.Error(2)
+	}
+	return args.Get(0).(*models.IdempotencyKey), args.Bool(1), args.Error(2)
+}
+
+func (m *MockIdempotencyService) Finish(ctx context.Context, record *models.IdempotencyKey, statusCode int, contentType string, body []byte) error {
+	args := m.Called(record, statusCode, contentType, body)
+	return args.Error(0)
+}
+
+func (m *MockIdempotencyService) Abandon(ctx context.Context, record *models.IdempotencyKey) error {
+	args := m.Called(record)
+	return args.Error(0)
+}
+
+func setupIdempotentRouter(idempotency service.IdempotencyService, principal *auth.Principal, status int) (*gin.Engine, *int) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	calls := 0
+	router.POST("/api/v1/shorten", func(ctx *gin.Context) {
+		SetPrincipal(ctx, principal)
+		ctx.Next()
+	}, Idempotent(idempotency), func(ctx *gin.Context) {
+		calls++
+		ctx.JSON(status, gin.H{"short_url": "http://localhost:8080/abc123"})
+	})
+	return router, &calls
+}
+
+func idempotentRequest(key, body string) *http.Request {
+	req := httptest.NewRequest("POST", "/api/v1/shorten", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	return req
+}
+
+func TestIdempotent(t *testing.T) {
+	body := `{"url": "https://example.com"}`
+	hash := requestHash(idempotentRequest("", body), []byte(body))
+
+	t.Run("First request is recorded", func(t *testing.T) {
+		idempotency := new(MockIdempotencyService)
+		record := &models.IdempotencyKey{}
+		idempotency.On("Begin", "key:4", "retry-1", hash).Return(record, false, nil)
+		idempotency.On("Finish", record, http.StatusOK, "application/json; charset=utf-8",
+			[]byte(`{"short_url":"http://localhost:8080/abc123"}`)).Return(nil)
+		router, calls := setupIdempotentRouter(idempotency, &auth.Principal{APIKeyID: 4}, http.StatusOK)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, idempotentRequest("retry-1", body))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 1, *calls)
+		idempotency.AssertExpectations(t)
+	})
+
+	t.Run("Retry gets the recorded response", func(t *testing.T) {
+		idempotency := new(MockIdempotencyService)
+		idempotency.On("Begin", "key:4", "retry-1", hash).Return(&models.IdempotencyKey{
+			StatusCode:  http.StatusOK,
+			ContentType: "application/json; charset=utf-8",
+			Body:        []byte(`{"short_url":"http://localhost:8080/first1"}`),
+		}, true, nil)
+		router, calls := setupIdempotentRouter(idempotency, &auth.Principal{APIKeyID: 4}, http.StatusOK)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, idempotentRequest("retry-1", body))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `{"short_url":"http://localhost:8080/first1"}`, w.Body.String())
+		assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, 0, *calls)
+	})
+
+	t.Run("Key reused with another body", func(t *testing.T) {
+		idempotency := new(MockIdempotencyService)
+		idempotency.On("Begin", "key:4", "retry-1", mock.Anything).Return(nil, false, service.ErrIdempotencyKeyReused)
+		router, calls := setupIdempotentRouter(idempotency, &auth.Principal{APIKeyID: 4}, http.StatusOK)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, idempotentRequest("retry-1", `{"url": "https://example.org"}`))
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Equal(t, 0, *calls)
+	})
+
+	t.Run("First request still running", func(t *testing.T) {
+		idempotency := new(MockIdempotencyService)
+		idempotency.On("Begin", "key:4", "retry-1", hash).Return(nil, false, service.ErrIdempotencyKeyInProgress)
+		router, _ := setupIdempotentRouter(idempotency, &auth.Principal{APIKeyID: 4}, http.StatusOK)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, idempotentRequest("retry-1", body))
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("Server errors release the key", func(t *testing.T) {
+		idempotency := new(MockIdempotencyService)
+		record := &models.IdempotencyKey{}
+		idempotency.On("Begin", "key:4", "retry-1", hash).Return(record, false, nil)
+		idempotency.On("Abandon", record).Return(nil)
+		router, _ := setupIdempotentRouter(idempotency, &auth.Principal{APIKeyID: 4}, http.StatusInternalServerError)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, idempotentRequest("retry-1", body))
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		idempotency.AssertExpectations(t)
+		idempotency.AssertNotCalled(t, "Finish", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Oversized body", func(t *testing.T) {
+		idempotency := new(MockIdempotencyService)
+		router, calls := setupIdempotentRouter(idempotency, &auth.Principal{APIKeyID: 4}, http.StatusOK)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, idempotentRequest("retry-1", strings.Repeat(" ", maxIdempotentBodySize+1)))
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		assert.Equal(t, 0, *calls)
+		idempotency.AssertNotCalled(t, "Begin", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("Requests without a key pass through", func(t *testing.T) {
+		idempotency := new(MockIdempotencyService)
+		router, calls := setupIdempotentRouter(idempotency, &auth.Principal{APIKeyID: 4}, http.StatusOK)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, idempotentRequest("", body))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 1, *calls)
+		idempotency.AssertNotCalled(t, "Begin", mock.Anything, mock.Anything, mock.Anything)
+	})
+	t.Run("Callers without a stable identity are refused", func(t *testing.T) {
+		idempotency := new(MockIdempotencyService)
+		router, calls := setupIdempotentRouter(idempotency, &auth.Principal{Scopes: []string{auth.ScopeShorten}}, http.StatusOK)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, idempotentRequest("retry-1", body))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, 0, *calls)
+		idempotency.AssertNotCalled(t, "Begin", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestCallerOf(t *testing.T) {
+	alice, bob := uint(1), uint(2)
+	token := func(issuer string, userID *uint, subject string) string {
+		caller, ok := callerOf(&auth.Principal{Issuer: issuer, UserID: userID, Name: subject})
+		assert.True(t, ok)
+		return caller
+	}
+
+	caller, ok := callerOf(&auth.Principal{APIKeyID: 4, Name: "ci"})
+	assert.True(t, ok)
+	assert.Equal(t, "key:4", caller)
+
+	assert.Equal(t, token("https://idp", &alice, "svc"), token("https://idp", &alice, "svc"))
+	assert.NotEqual(t, token("https://idp", &alice, "svc"), token("https://other", &alice, "svc"), "issuers reusing a subject")
+	assert.NotEqual(t, token("https://idp", &alice, "svc"), token("https://idp", &bob, "svc"), "users sharing a subject")
+	assert.NotEqual(t, token("https://idp", nil, "svc"), token("https://idp", &alice, "svc"))
+	assert.LessOrEqual(t, len(token("https://idp", &alice, "svc")), 255)
+
+	_, ok = callerOf(&auth.Principal{Issuer: "https://idp", UserID: &alice})
+	assert.False(t, ok, "token without a subject")
+	_, ok = callerOf(nil)
+	assert.False(t, ok)
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+	"urlshortner/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccessLog logs every request once it has been served, server errors at
+// error level. It must come after RequestID for records to carry the ID.
+func AccessLog() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		path := ctx.Request.URL.Path
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", ctx.Request.Method),
+			slog.String("path", path),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("client_ip", ctx.ClientIP()),
+			slog.Int("bytes", ctx.Writer.Size()),
+		}
+		if errs := ctx.Errors.ByType(gin.ErrorTypePrivate); len(errs) > 0 {
+			attrs = append(attrs, slog.String("error", errs.String()))
+		}
+		slog.LogAttrs(ctx.Request.Context(), level, "Request", attrs...)
+	}
+}
+
+// Recover turns a panic in a handler into a logged error and an internal
+// problem, instead of gin's plain-text stack trace.
+func Recover() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(ctx *gin.Context, recovered any) {
+		slog.ErrorContext(ctx.Request.Context(), "Handler panicked", "panic", recovered, "path", ctx.Request.URL.Path,
+			"stack", string(debug.Stack()))
+		problem.Abort(ctx, http.StatusInternalServerError, problem.Internal, "Internal server error")
+	})
+}
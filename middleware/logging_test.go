@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"urlshortner/logging"
+	"urlshortner/problem"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureLog has the default logger write JSON to the returned buffer for
+// the rest of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(logging.New(&buf, "json", slog.LevelDebug))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func TestAccessLogCarriesRequestID(t *testing.T) {
+	buf := captureLog(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestID(), AccessLog())
+	router.GET("/abc123", func(ctx *gin.Context) {
+		slog.ErrorContext(ctx.Request.Context(), "Failed to record click")
+		ctx.Status(http.StatusFound)
+	})
+
+	req := httptest.NewRequest("GET", "/abc123", nil)
+	req.Header.Set(problem.RequestIDHeader, "req-1")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+	var failure, access map[string]interface{}
+	require.NoError(t, json.Unmarshal(lines[0], &failure))
+	require.NoError(t, json.Unmarshal(lines[1], &access))
+	assert.Equal(t, "Failed to record click", failure["msg"])
+	assert.Equal(t, "req-1", failure["request_id"])
+	assert.Equal(t, "Request", access["msg"])
+	assert.Equal(t, "req-1", access["request_id"])
+	assert.Equal(t, "/abc123", access["path"])
+	assert.Equal(t, float64(http.StatusFound), access["status"])
+}
+
+func TestRecoverAnswersProblem(t *testing.T) {
+	buf := captureLog(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestID(), Recover())
+	router.GET("/boom", func(ctx *gin.Context) {
+		panic("boom")
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/boom", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, problem.Internal, body["code"])
+
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "Handler panicked", record["msg"])
+	assert.Equal(t, "boom", record["panic"])
+	assert.Equal(t, w.Header().Get(problem.RequestIDHeader), record["request_id"])
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"urlshortner/logging"
+	"urlshortner/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	requestIDKey = "request_id"
+	// maxRequestIDLength bounds the IDs accepted from clients and proxies.
+	maxRequestIDLength = 128
+)
+
+// RequestID gives every request an ID, sent back in the X-Request-ID
+// header. An ID set by the client or a proxy in the same header is kept if
+// it is reasonable, so that a request can be followed across services. The
+// ID also goes into the request's context, for the service and repository
+// layers to log with.
+func RequestID() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.GetHeader(problem.RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		ctx.Set(requestIDKey, id)
+		ctx.Request = ctx.Request.WithContext(logging.WithRequestID(ctx.Request.Context(), id))
+		ctx.Header(problem.RequestIDHeader, id)
+		ctx.Next()
+	}
+}
+
+// CurrentRequestID returns the ID given to the request by RequestID.
+func CurrentRequestID(ctx *gin.Context) string {
+	return ctx.GetString(requestIDKey)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
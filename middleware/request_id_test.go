@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"urlshortner/problem"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{name: "Generated when absent"},
+		{name: "Kept from the client", incoming: "edge-7f3a.42", keep: true},
+		{name: "Replaced when malformed", incoming: "bad id\r\n"},
+		{name: "Replaced when too long", incoming: strings.Repeat("a", maxRequestIDLength+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			var seen string
+			router.GET("/", RequestID(), func(ctx *gin.Context) {
+				seen = CurrentRequestID(ctx)
+				ctx.Status(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(problem.RequestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			id := w.Header().Get(problem.RequestIDHeader)
+			assert.Equal(t, seen, id)
+			if tt.keep {
+				assert.Equal(t, tt.incoming, id)
+			} else {
+				assert.Len(t, id, 32)
+			}
+		})
+	}
+}
+
+func TestProblemCarriesRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/missing", RequestID(), func(ctx *gin.Context) {
+		problem.Write(ctx, http.StatusNotFound, problem.NotFound, "URL not found")
+	})
+
+	req := httptest.NewRequest("GET", "/missing", nil)
+	req.Header.Set(problem.RequestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"type": "about:blank",
+		"title": "Not Found",
+		"status": 404,
+		"detail": "URL not found",
+		"code": "not_found",
+		"instance": "/missing",
+		"request_id": "req-1"
+	}`, w.Body.String())
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Timeout gives the handlers after it d to serve a request: its context is
+// cancelled after d, which aborts the database queries run with it, as does
+// the client going away. Handlers still write their response. Zero leaves
+// requests unbounded.
+func Timeout(d time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if d <= 0 {
+			ctx.Next()
+			return
+		}
+		deadline, cancel := context.WithTimeout(ctx.Request.Context(), d)
+		defer cancel()
+		ctx.Request = ctx.Request.WithContext(deadline)
+		ctx.Next()
+	}
+}
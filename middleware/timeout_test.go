@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"urlshortner/problem"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("Sets a deadline", func(t *testing.T) {
+		router := gin.New()
+		var deadline time.Time
+		var ok bool
+		router.GET("/", Timeout(time.Minute), func(ctx *gin.Context) {
+			deadline, ok = ctx.Request.Context().Deadline()
+			ctx.Status(http.StatusNoContent)
+		})
+
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+		require.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 5*time.Second)
+	})
+
+	t.Run("Zero leaves requests unbounded", func(t *testing.T) {
+		router := gin.New()
+		var ok bool
+		router.GET("/", Timeout(0), func(ctx *gin.Context) {
+			_, ok = ctx.Request.Context().Deadline()
+			ctx.Status(http.StatusNoContent)
+		})
+
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+		assert.False(t, ok)
+	})
+
+	t.Run("Server errors past the deadline are timeouts", func(t *testing.T) {
+		router := gin.New()
+		router.GET("/urls", Timeout(time.Millisecond), func(ctx *gin.Context) {
+			<-ctx.Request.Context().Done()
+			problem.Write(ctx, http.StatusInternalServerError, problem.Internal, "Failed to list URLs")
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/urls", nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, problem.Timeout, body["code"])
+		assert.Equal(t, "Failed to list URLs", body["detail"])
+	})
+}
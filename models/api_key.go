@@ -0,0 +1,27 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+type APIKey struct {
+	ID          uint   `gorm:"primarykey"`
+	Name        string `gorm:"type:varchar(100);not null"`
+	Prefix      string `gorm:"type:varchar(16);not null"`
+	KeyHash     string `gorm:"type:char(64);uniqueIndex;not null"`
+	Scopes      string `gorm:"type:varchar(255);not null"`
+	UserID      *uint  `gorm:"index"`
+	WorkspaceID *uint  `gorm:"index"`
+	LastUsedAt  *time.Time
+	RevokedAt   *time.Time
+	CreatedAt   time.Time
+}
+
+// ScopeList returns the comma-separated Scopes column as a slice.
+func (k *APIKey) ScopeList() []string {
+	if k.Scopes == "" {
+		return nil
+	}
+	return strings.Split(k.Scopes, ",")
+}
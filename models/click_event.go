@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+const (
+	// ClickSourceQR marks visits from scanning a link's QR code.
+	ClickSourceQR = "qr"
+	// ClickSourceDirect stands for visits with no recorded source in
+	// stats.
+	ClickSourceDirect = "direct"
+)
+
+// ClickEvent records a single visit to a link.
+type ClickEvent struct {
+	ID        uint `gorm:"primarykey"`
+	URLID     uint `gorm:"index;not null"`
+	CreatedAt time.Time
+	// Country is the ISO 3166-1 alpha-2 code of the visitor's country, if
+	// it could be told from their IP address.
+	Country string `gorm:"type:char(2)"`
+	// Platform is the visitor's operating system, if recognised.
+	Platform string `gorm:"type:varchar(20)"`
+	// Variant is the name of the variant served, for links rotating
+	// between several destinations.
+	Variant string `gorm:"type:varchar(50)"`
+	// Source is how the visitor reached the link, such as ClickSourceQR,
+	// or empty for a plain visit.
+	Source string `gorm:"type:varchar(20)"`
+}
+
+// SourceMetric is the number of clicks a link got from one source.
+type SourceMetric struct {
+	Source string `json:"source"`
+	Clicks int64  `json:"clicks"`
+}
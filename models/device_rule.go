@@ -0,0 +1,24 @@
+package models
+
+import "database/sql/driver"
+
+// DeviceRule sends visitors on a platform to a destination of its own.
+type DeviceRule struct {
+	// Platform is an operating system (ios, android, windows, macos, linux,
+	// chromeos) or a device class (mobile, desktop).
+	Platform string `json:"platform"`
+	URL      string `json:"url"`
+}
+
+// DeviceRules are tried in order; the first one matching the visitor wins.
+// They are stored in a JSON column.
+type DeviceRules []DeviceRule
+
+func (r DeviceRules) Value() (driver.Value, error) {
+	return jsonValue(r, len(r) == 0)
+}
+
+func (r *DeviceRules) Scan(value interface{}) error {
+	*r = nil
+	return scanJSON(value, r)
+}
@@ -0,0 +1,23 @@
+package models
+
+import "database/sql/driver"
+
+// GeoRule sends visitors from some countries to a destination of their own.
+type GeoRule struct {
+	// Countries are ISO 3166-1 alpha-2 codes, such as "DE" or "US".
+	Countries []string `json:"countries"`
+	URL       string   `json:"url"`
+}
+
+// GeoRules are tried in order; the first one listing the visitor's country
+// wins. They are stored in a JSON column.
+type GeoRules []GeoRule
+
+func (r GeoRules) Value() (driver.Value, error) {
+	return jsonValue(r, len(r) == 0)
+}
+
+func (r *GeoRules) Scan(value interface{}) error {
+	*r = nil
+	return scanJSON(value, r)
+}
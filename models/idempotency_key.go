@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// IdempotencyKey records a request made with an Idempotency-Key header and
+// the response it got, to replay it when the request is retried.
+type IdempotencyKey struct {
+	ID uint `gorm:"primarykey"`
+	// Caller identifies who sent the key; the keys of different callers
+	// never clash.
+	Caller string `gorm:"type:varchar(255);not null;uniqueIndex:idx_idempotency_key"`
+	Key    string `gorm:"column:idempotency_key;type:varchar(255);not null;uniqueIndex:idx_idempotency_key"`
+	// RequestHash tells a retry from another request reusing the key.
+	RequestHash string `gorm:"type:char(64);not null"`
+	// StatusCode is 0 while the first request is being handled.
+	StatusCode  int
+	ContentType string `gorm:"type:varchar(100)"`
+	Body        []byte `gorm:"type:mediumblob"`
+	CreatedAt   time.Time
+	ExpiresAt   time.Time `gorm:"index"`
+}
+
+// Completed reports whether the response to the request is recorded.
+func (k *IdempotencyKey) Completed() bool {
+	return k.StatusCode != 0
+}
@@ -2,15 +2,82 @@ package models
 
 import (
     "time"
+
+    "gorm.io/gorm"
+)
+
+const (
+    URLStatusActive   = "active"
+    URLStatusPaused   = "paused"
+    URLStatusDisabled = "disabled"
+)
+
+// Which side wins when a passed-through query parameter is also in the
+// destination URL.
+const (
+    QueryConflictDestination = "destination"
+    QueryConflictIncoming    = "incoming"
 )
 
 type URL struct {
     ID          uint      `gorm:"primarykey"`
     OriginalURL string    `gorm:"type:text;not null"`
-    ShortCode   string    `gorm:"type:varchar(10);uniqueIndex;not null"`
+    ShortCode   string    `gorm:"type:varchar(32);uniqueIndex;not null"`
     Domain      string    `gorm:"type:varchar(255);index;not null"`
+    OwnerID     *uint     `gorm:"index"`
+    WorkspaceID *uint     `gorm:"index"`
     CreatedAt   time.Time
     AccessCount int       `gorm:"default:0"`
+    Status      string    `gorm:"type:varchar(20);default:active;not null"`
+    ExpiresAt   *time.Time
+    // ActiveFrom and ActiveUntil bound the window in which the link
+    // redirects, for links going live at a launch time or running for a
+    // campaign.
+    ActiveFrom  *time.Time
+    ActiveUntil *time.Time
+    // RedirectCode is the HTTP status of the redirect; 0 means the
+    // service-wide default.
+    RedirectCode int
+    // PasswordHash is the bcrypt hash of the password guarding the link, if
+    // any.
+    PasswordHash string `gorm:"type:varchar(100)"`
+    // MaxClicks stops the link from redirecting once AccessCount reaches
+    // it; nil means unlimited.
+    MaxClicks *int
+    // Preview shows visitors the destination before sending them there.
+    Preview bool
+    // DeviceRules send visitors on some platforms elsewhere than
+    // OriginalURL.
+    DeviceRules DeviceRules `gorm:"type:json"`
+    // GeoRules send visitors from some countries elsewhere than
+    // OriginalURL. Device rules take precedence.
+    GeoRules GeoRules `gorm:"type:json"`
+    // Variants, if any, replace OriginalURL for visitors matching no rule,
+    // each getting one picked by weight.
+    Variants Variants `gorm:"type:json"`
+    // StickyVariants keeps serving a returning visitor the same variant.
+    StickyVariants bool
+    // PassthroughPath appends any path after the short code to the
+    // destination's path.
+    PassthroughPath bool
+    // PassthroughQuery merges the query string of the visit into the
+    // destination's, resolving clashes as QueryConflict says (empty means
+    // the destination wins).
+    PassthroughQuery bool
+    QueryConflict    string `gorm:"type:varchar(20)"`
+    // DeletedAt makes deletes soft, so the code stays reserved and its
+    // redirect can tell "gone" apart from "never existed".
+    DeletedAt   gorm.DeletedAt `gorm:"index"`
+}
+
+// Plain reports whether the link is active and has the default settings,
+// so that it can be handed out to anyone shortening its destination without
+// asking for settings of their own.
+func (u *URL) Plain() bool {
+    return u.Status == URLStatusActive && u.RedirectCode == 0 && u.PasswordHash == "" &&
+        u.MaxClicks == nil && u.ExpiresAt == nil && u.ActiveFrom == nil && u.ActiveUntil == nil &&
+        len(u.DeviceRules) == 0 && len(u.GeoRules) == 0 && len(u.Variants) == 0 &&
+        !u.Preview && !u.PassthroughPath && !u.PassthroughQuery
 }
 
 type DomainMetric struct {
@@ -0,0 +1,12 @@
+package models
+
+import (
+	"time"
+)
+
+type User struct {
+	ID        uint   `gorm:"primarykey"`
+	Email     string `gorm:"type:varchar(255);uniqueIndex;not null"`
+	Name      string `gorm:"type:varchar(100)"`
+	CreatedAt time.Time
+}
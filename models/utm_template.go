@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// UTMTemplate is a named set of UTM parameters a workspace tags its links
+// with.
+type UTMTemplate struct {
+	ID          uint   `gorm:"primarykey"`
+	WorkspaceID uint   `gorm:"not null;uniqueIndex:idx_utm_template_name"`
+	Name        string `gorm:"type:varchar(100);not null;uniqueIndex:idx_utm_template_name"`
+	Source      string `gorm:"type:varchar(255);not null"`
+	Medium      string `gorm:"type:varchar(255)"`
+	Campaign    string `gorm:"type:varchar(255)"`
+	Term        string `gorm:"type:varchar(255)"`
+	Content     string `gorm:"type:varchar(255)"`
+	CreatedAt   time.Time
+}
+
+// Params returns the template's non-empty parameters, keyed by their utm_
+// query parameter name, in the conventional order.
+func (t *UTMTemplate) Params() [][2]string {
+	var params [][2]string
+	for _, p := range [][2]string{
+		{"utm_source", t.Source},
+		{"utm_medium", t.Medium},
+		{"utm_campaign", t.Campaign},
+		{"utm_term", t.Term},
+		{"utm_content", t.Content},
+	} {
+		if p[1] != "" {
+			params = append(params, p)
+		}
+	}
+	return params
+}
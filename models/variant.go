@@ -0,0 +1,31 @@
+package models
+
+import "database/sql/driver"
+
+// Variant is one of several destinations a link rotates between.
+type Variant struct {
+	// Name identifies the variant in click stats and sticky cookies.
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Weight is the variant's share of visitors relative to the others; 0
+	// takes it out of rotation.
+	Weight int `json:"weight"`
+}
+
+// Variants are stored in a JSON column.
+type Variants []Variant
+
+func (v Variants) Value() (driver.Value, error) {
+	return jsonValue(v, len(v) == 0)
+}
+
+func (v *Variants) Scan(value interface{}) error {
+	*v = nil
+	return scanJSON(value, v)
+}
+
+// VariantMetric is the number of clicks a variant has been served for.
+type VariantMetric struct {
+	Variant string `json:"variant"`
+	Clicks  int64  `json:"clicks"`
+}
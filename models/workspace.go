@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+)
+
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+func (r Role) Valid() bool {
+	return r == RoleViewer || r == RoleEditor || r == RoleAdmin
+}
+
+type Workspace struct {
+	ID   uint   `gorm:"primarykey"`
+	Name string `gorm:"type:varchar(100);not null"`
+	// FallbackURL or FallbackPage, when set, is shown to visitors of the
+	// workspace's dead links instead of the deployment's fallback.
+	FallbackURL  string `gorm:"type:varchar(2048)"`
+	FallbackPage string `gorm:"type:text"`
+	CreatedAt    time.Time
+}
+
+type WorkspaceMember struct {
+	ID          uint `gorm:"primarykey"`
+	WorkspaceID uint `gorm:"uniqueIndex:idx_workspace_member;not null"`
+	UserID      uint `gorm:"uniqueIndex:idx_workspace_member;index;not null"`
+	Role        Role `gorm:"type:varchar(20);not null"`
+	CreatedAt   time.Time
+}
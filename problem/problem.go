@@ -0,0 +1,76 @@
+// Package problem writes API errors as RFC 7807 problem details, each with
+// a stable code clients can branch on and the ID of the request.
+package problem
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ContentType = "application/problem+json"
+	// RequestIDHeader carries the ID of the request, which problems repeat
+	// in their body.
+	RequestIDHeader = "X-Request-ID"
+)
+
+// Codes of problems. They are part of the API: add new ones, but never
+// change or reuse them.
+const (
+	InvalidRequest       = "invalid_request"
+	InvalidURL           = "invalid_url"
+	InvalidAlias         = "invalid_alias"
+	AliasTaken           = "alias_taken"
+	Unauthorized         = "unauthorized"
+	Forbidden            = "forbidden"
+	NotFound             = "not_found"
+	Conflict             = "conflict"
+	Deleted              = "deleted"
+	Disabled             = "disabled"
+	Expired              = "expired"
+	Paused               = "paused"
+	NotActive            = "not_active"
+	ClickLimitReached    = "click_limit_reached"
+	PasswordRequired     = "password_required"
+	WrongPassword        = "wrong_password"
+	RateLimited          = "rate_limited"
+	TooLarge             = "too_large"
+	IdempotencyKeyReused = "idempotency_key_reused"
+	Timeout              = "timeout"
+	Internal             = "internal"
+)
+
+// Write responds with a problem. detail is the human-readable explanation;
+// fields, if given, are added as extension members. A server error written
+// once the request's deadline has passed is reported as a timeout, which is
+// what most likely caused it.
+func Write(ctx *gin.Context, status int, code, detail string, fields ...gin.H) {
+	if status == http.StatusInternalServerError && errors.Is(ctx.Request.Context().Err(), context.DeadlineExceeded) {
+		status, code = http.StatusServiceUnavailable, Timeout
+	}
+	body := gin.H{
+		"type":       "about:blank",
+		"title":      http.StatusText(status),
+		"status":     status,
+		"detail":     detail,
+		"code":       code,
+		"instance":   ctx.Request.URL.Path,
+		"request_id": ctx.Writer.Header().Get(RequestIDHeader),
+	}
+	for _, extra := range fields {
+		for name, value := range extra {
+			body[name] = value
+		}
+	}
+	ctx.Header("Content-Type", ContentType)
+	ctx.JSON(status, body)
+}
+
+// Abort writes a problem and stops the handler chain.
+func Abort(ctx *gin.Context, status int, code, detail string, fields ...gin.H) {
+	Write(ctx, status, code, detail, fields...)
+	ctx.Abort()
+}
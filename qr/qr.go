@@ -0,0 +1,163 @@
+// Package qr renders QR codes as PNG or SVG images.
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	MinSize   = 64
+	MaxSize   = 2048
+	MaxMargin = 16
+)
+
+var ErrInvalidOptions = errors.New("invalid QR code options")
+
+// Options control how a QR code is drawn.
+type Options struct {
+	// Size is the width and height of the image in pixels.
+	Size int
+	// Level is the error-correction level: "L", "M", "Q" or "H".
+	Level string
+	// Margin is the width of the quiet zone around the code, in modules.
+	Margin     int
+	Foreground color.RGBA
+	Background color.RGBA
+}
+
+// DefaultOptions draw a 256 pixel black on white code with medium error
+// correction and the standard four-module quiet zone.
+func DefaultOptions() Options {
+	return Options{
+		Size:       256,
+		Level:      "M",
+		Margin:     4,
+		Foreground: color.RGBA{A: 0xff},
+		Background: color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+	}
+}
+
+var levels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// ParseColor parses a hex colour such as "1a73e8" or "#fff".
+func ParseColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) != 6 {
+		return color.RGBA{}, fmt.Errorf("%w: colour %q", ErrInvalidOptions, s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("%w: colour %q", ErrInvalidOptions, s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+// modules encodes content and returns its modules, without quiet zone.
+func modules(content string, opts Options) ([][]bool, error) {
+	level, ok := levels[opts.Level]
+	if !ok {
+		return nil, fmt.Errorf("%w: level %q", ErrInvalidOptions, opts.Level)
+	}
+	if opts.Size < MinSize || opts.Size > MaxSize {
+		return nil, fmt.Errorf("%w: size must be between %d and %d", ErrInvalidOptions, MinSize, MaxSize)
+	}
+	if opts.Margin < 0 || opts.Margin > MaxMargin {
+		return nil, fmt.Errorf("%w: margin must be between 0 and %d", ErrInvalidOptions, MaxMargin)
+	}
+
+	code, err := qrcode.New(content, level)
+	if err != nil {
+		return nil, err
+	}
+	code.DisableBorder = true
+	return code.Bitmap(), nil
+}
+
+// PNG draws the QR code of content as a PNG image. Modules are whole pixels,
+// so the code is centred and any leftover pixels widen the margin.
+func PNG(content string, opts Options) ([]byte, error) {
+	bitmap, err := modules(content, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	total := len(bitmap) + 2*opts.Margin
+	scale := opts.Size / total
+	if scale == 0 {
+		return nil, fmt.Errorf("%w: size too small for this code", ErrInvalidOptions)
+	}
+	offset := (opts.Size-scale*total)/2 + scale*opts.Margin
+
+	palette := color.Palette{opts.Background, opts.Foreground}
+	img := image.NewPaletted(image.Rect(0, 0, opts.Size, opts.Size), palette)
+	for y, row := range bitmap {
+		for x, set := range row {
+			if !set {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex(offset+x*scale+dx, offset+y*scale+dy, 1)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SVG draws the QR code of content as an SVG image, one path for all
+// modules with a rectangle per horizontal run.
+func SVG(content string, opts Options) ([]byte, error) {
+	bitmap, err := modules(content, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	total := len(bitmap) + 2*opts.Margin
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		opts.Size, opts.Size, total, total)
+	fmt.Fprintf(&buf, `<rect width="100%%" height="100%%" fill="%s"/>`, hex(opts.Background))
+	fmt.Fprintf(&buf, `<path fill="%s" d="`, hex(opts.Foreground))
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			run := 1
+			for x+run < len(row) && row[x+run] {
+				run++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", x+opts.Margin, y+opts.Margin, run, run)
+			x += run - 1
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes(), nil
+}
+
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"urlshortner/models"
+
+	"gorm.io/gorm"
+)
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *models.APIKey) error
+	FindByHash(ctx context.Context, hash string) (*models.APIKey, error)
+	FindByID(ctx context.Context, id uint) (*models.APIKey, error)
+	List(ctx context.Context) ([]models.APIKey, error)
+	ListByWorkspace(ctx context.Context, workspaceID uint) ([]models.APIKey, error)
+	Revoke(ctx context.Context, id uint) error
+	TouchLastUsed(ctx context.Context, key *models.APIKey) error
+}
+
+type APIKeyRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) APIKeyRepository {
+	return &APIKeyRepositoryImpl{db: db}
+}
+
+func (r *APIKeyRepositoryImpl) Create(ctx context.Context, key *models.APIKey) error {
+	return r.db.WithContext(ctx).Create(key).Error
+}
+
+func (r *APIKeyRepositoryImpl) FindByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	var key models.APIKey
+	err := r.db.WithContext(ctx).Where("key_hash = ? AND revoked_at IS NULL", hash).First(&key).Error
+	return &key, err
+}
+
+func (r *APIKeyRepositoryImpl) FindByID(ctx context.Context, id uint) (*models.APIKey, error) {
+	var key models.APIKey
+	err := r.db.WithContext(ctx).First(&key, id).Error
+	return &key, err
+}
+
+func (r *APIKeyRepositoryImpl) ListByWorkspace(ctx context.Context, workspaceID uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := r.db.WithContext(ctx).Where("workspace_id = ?", workspaceID).Order("id").Find(&keys).Error
+	return keys, err
+}
+
+func (r *APIKeyRepositoryImpl) List(ctx context.Context) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := r.db.WithContext(ctx).Order("id").Find(&keys).Error
+	return keys, err
+}
+
+func (r *APIKeyRepositoryImpl) Revoke(ctx context.Context, id uint) error {
+	result := r.db.WithContext(ctx).Model(&models.APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *APIKeyRepositoryImpl) TouchLastUsed(ctx context.Context, key *models.APIKey) error {
+	now := time.Now()
+	key.LastUsedAt = &now
+	return r.db.WithContext(ctx).Model(key).UpdateColumn("last_used_at", now).Error
+}
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+	"urlshortner/models"
+
+	"gorm.io/gorm"
+)
+
+type ClickRepository interface {
+	Create(ctx context.Context, click *models.ClickEvent) error
+	// CountByVariant returns the number of clicks on a link per variant
+	// served.
+	CountByVariant(ctx context.Context, urlID uint) ([]models.VariantMetric, error)
+	// CountBySource returns the number of clicks on a link per source,
+	// most first.
+	CountBySource(ctx context.Context, urlID uint) ([]models.SourceMetric, error)
+}
+
+type ClickRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewClickRepository(db *gorm.DB) ClickRepository {
+	return &ClickRepositoryImpl{db: db}
+}
+
+func (r *ClickRepositoryImpl) Create(ctx context.Context, click *models.ClickEvent) error {
+	return r.db.WithContext(ctx).Create(click).Error
+}
+
+func (r *ClickRepositoryImpl) CountByVariant(ctx context.Context, urlID uint) ([]models.VariantMetric, error) {
+	var metrics []models.VariantMetric
+	err := r.db.WithContext(ctx).Model(&models.ClickEvent{}).
+		Select("variant, COUNT(*) as clicks").
+		Where("url_id = ?", urlID).
+		Group("variant").
+		Scan(&metrics).Error
+	return metrics, err
+}
+
+func (r *ClickRepositoryImpl) CountBySource(ctx context.Context, urlID uint) ([]models.SourceMetric, error) {
+	var metrics []models.SourceMetric
+	err := r.db.WithContext(ctx).Model(&models.ClickEvent{}).
+		Select("source, COUNT(*) as clicks").
+		Where("url_id = ?", urlID).
+		Group("source").
+		Order("clicks DESC").
+		Scan(&metrics).Error
+	return metrics, err
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"urlshortner/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type IdempotencyRepository interface {
+	// Claim records key unless the caller has a live record of the same
+	// key, which it returns instead along with false.
+	Claim(ctx context.Context, key *models.IdempotencyKey) (*models.IdempotencyKey, bool, error)
+	// Complete stores the response to the request of key.
+	Complete(ctx context.Context, key *models.IdempotencyKey) error
+	Delete(ctx context.Context, key *models.IdempotencyKey) error
+}
+
+type IdempotencyRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewIdempotencyRepository(db *gorm.DB) IdempotencyRepository {
+	return &IdempotencyRepositoryImpl{db: db}
+}
+
+// Claim first drops the caller's expired keys, so that their values can be
+// used again and the table does not grow without bound. The insert is
+// ignored if the key exists, which leaves one winner among concurrent
+// claims.
+func (r *IdempotencyRepositoryImpl) Claim(ctx context.Context, key *models.IdempotencyKey) (*models.IdempotencyKey, bool, error) {
+	db := r.db.WithContext(ctx)
+	err := db.Where("caller = ? AND expires_at <= ?", key.Caller, time.Now()).
+		Delete(&models.IdempotencyKey{}).Error
+	if err != nil {
+		return nil, false, err
+	}
+
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(key)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return key, true, nil
+	}
+
+	var existing models.IdempotencyKey
+	err = db.Where("caller = ? AND idempotency_key = ?", key.Caller, key.Key).First(&existing).Error
+	return &existing, false, err
+}
+
+func (r *IdempotencyRepositoryImpl) Complete(ctx context.Context, key *models.IdempotencyKey) error {
+	return r.db.WithContext(ctx).Model(key).
+		Select("status_code", "content_type", "body").
+		Updates(key).Error
+}
+
+func (r *IdempotencyRepositoryImpl) Delete(ctx context.Context, key *models.IdempotencyKey) error {
+	return r.db.WithContext(ctx).Delete(key).Error
+}
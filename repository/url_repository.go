@@ -1,16 +1,92 @@
 package repository
 
 import (
+    "context"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
     "gorm.io/gorm"
 	"urlshortner/models"
 )
 
+// ErrClickLimitReached is returned by IncrementAccessCount when the link has
+// already been visited MaxClicks times.
+var ErrClickLimitReached = errors.New("click limit reached")
+
+// URLScope selects the links of one workspace or, without a workspace, of
+// one owner's personal space. The zero value is the unscoped space of links
+// that have neither.
+type URLScope struct {
+    WorkspaceID *uint
+    OwnerID     *uint
+}
+
+// Sort orders for List.
+const (
+    SortCreatedAt = "created_at"
+    SortClicks    = "clicks"
+)
+
+// URLFilter selects and orders links within a scope for List.
+type URLFilter struct {
+    Scope         URLScope
+    Domain        string
+    Status        string
+    CreatedAfter  *time.Time
+    CreatedBefore *time.Time
+    // MinClicks, if set, leaves out links visited fewer times.
+    MinClicks     *int
+    // Search matches links whose destination contains it.
+    Search        string
+    // Sort is SortCreatedAt, the default, or SortClicks; ties are broken
+    // by ID in the same direction.
+    Sort          string
+    Ascending     bool
+    // After, if set, starts the page after that link.
+    After         *URLCursor
+    Limit         int
+}
+
+// URLCursor is the position of a link in a List ordering: its ID and the
+// value it is sorted by.
+type URLCursor struct {
+    ID          uint
+    CreatedAt   time.Time
+    AccessCount int
+}
+
+// CursorOf returns the position of url in a listing.
+func CursorOf(url *models.URL) *URLCursor {
+    return &URLCursor{ID: url.ID, CreatedAt: url.CreatedAt, AccessCount: url.AccessCount}
+}
+
+// createBatchSize is the number of rows per INSERT in CreateBatch.
+const createBatchSize = 500
+
+// eachBatchSize is the number of rows loaded at a time by Each.
+const eachBatchSize = 500
+
 type URLRepository interface {
-    Create(url *models.URL) error
-    FindByShortCode(shortCode string) (*models.URL, error)
-    FindByOriginalURL(originalURL string) (*models.URL, error)
-    IncrementAccessCount(url *models.URL) error
-    GetTopDomains(limit int) ([]models.DomainMetric, error)
+    Create(ctx context.Context, url *models.URL) error
+    // CreateBatch inserts all the links in one transaction, or none.
+    CreateBatch(ctx context.Context, urls []*models.URL) error
+    FindByShortCode(ctx context.Context, shortCode string) (*models.URL, error)
+    // TakenShortCodes returns those of the codes already in use, including
+    // by deleted links.
+    TakenShortCodes(ctx context.Context, shortCodes []string) ([]string, error)
+    // FindByOriginalURL and FindByOriginalURLs only find plain links, which
+    // can be reused for requests without settings of their own.
+    FindByOriginalURL(ctx context.Context, originalURL string, scope URLScope) (*models.URL, error)
+    FindByOriginalURLs(ctx context.Context, originalURLs []string, scope URLScope) ([]models.URL, error)
+    IncrementAccessCount(ctx context.Context, url *models.URL) error
+    GetTopDomains(ctx context.Context, limit int, scope URLScope) ([]models.DomainMetric, error)
+    List(ctx context.Context, filter URLFilter) ([]models.URL, int64, error)
+    // Each calls fn with the links of scope, oldest first, a batch at a
+    // time, stopping at the first error.
+    Each(ctx context.Context, scope URLScope, fn func(urls []models.URL) error) error
+    Update(ctx context.Context, url *models.URL) error
+    Delete(ctx context.Context, url *models.URL) error
 }
 
 type URLRepositoryImpl struct {
@@ -21,33 +97,161 @@ func NewURLRepository(db *gorm.DB) URLRepository {
     return &URLRepositoryImpl{db: db}
 }
 
-func (r *URLRepositoryImpl) Create(url *models.URL) error {
-    return r.db.Create(url).Error
+func (r *URLRepositoryImpl) Create(ctx context.Context, url *models.URL) error {
+    return r.db.WithContext(ctx).Create(url).Error
 }
 
-func (r *URLRepositoryImpl) FindByShortCode(shortCode string) (*models.URL, error) {
+func (r *URLRepositoryImpl) CreateBatch(ctx context.Context, urls []*models.URL) error {
+    return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+        return tx.CreateInBatches(urls, createBatchSize).Error
+    })
+}
+
+// FindByShortCode also returns soft-deleted links, whose codes stay taken.
+func (r *URLRepositoryImpl) FindByShortCode(ctx context.Context, shortCode string) (*models.URL, error) {
     var url models.URL
-    err := r.db.Where("short_code = ?", shortCode).First(&url).Error
+    err := r.db.WithContext(ctx).Unscoped().Where("short_code = ?", shortCode).First(&url).Error
     return &url, err
 }
 
-func (r *URLRepositoryImpl) FindByOriginalURL(originalURL string) (*models.URL, error) {
+func (r *URLRepositoryImpl) TakenShortCodes(ctx context.Context, shortCodes []string) ([]string, error) {
+    var taken []string
+    err := r.db.WithContext(ctx).Unscoped().Model(&models.URL{}).
+        Where("short_code IN ?", shortCodes).
+        Pluck("short_code", &taken).Error
+    return taken, err
+}
+
+func (r *URLRepositoryImpl) FindByOriginalURL(ctx context.Context, originalURL string, scope URLScope) (*models.URL, error) {
     var url models.URL
-    err := r.db.Where("original_url = ?", originalURL).First(&url).Error
+    err := plainLinks(applyScope(r.db.WithContext(ctx), scope)).Where("original_url = ?", originalURL).First(&url).Error
     return &url, err
 }
 
-func (r *URLRepositoryImpl) IncrementAccessCount(url *models.URL) error {
-    return r.db.Model(url).Update("access_count", gorm.Expr("access_count + ?", 1)).Error
+func (r *URLRepositoryImpl) FindByOriginalURLs(ctx context.Context, originalURLs []string, scope URLScope) ([]models.URL, error) {
+    var urls []models.URL
+    err := plainLinks(applyScope(r.db.WithContext(ctx), scope)).Where("original_url IN ?", originalURLs).Order("id").Find(&urls).Error
+    return urls, err
 }
 
-func (r *URLRepositoryImpl) GetTopDomains(limit int) ([]models.DomainMetric, error) {
+// plainLinks narrows query to active links with default settings, the only
+// ones that may be handed out again for their destination; see
+// models.URL.Plain.
+func plainLinks(query *gorm.DB) *gorm.DB {
+    return query.Where("status = ? AND redirect_code = 0 AND (password_hash IS NULL OR password_hash = '')", models.URLStatusActive).
+        Where("max_clicks IS NULL AND expires_at IS NULL AND active_from IS NULL AND active_until IS NULL").
+        Where("device_rules IS NULL AND geo_rules IS NULL AND variants IS NULL").
+        Where("preview = ? AND passthrough_path = ? AND passthrough_query = ?", false, false, false)
+}
+
+// IncrementAccessCount checks the click limit and counts the visit in a single
+// conditional UPDATE, so concurrent visits cannot both take the last click.
+func (r *URLRepositoryImpl) IncrementAccessCount(ctx context.Context, url *models.URL) error {
+    result := r.db.WithContext(ctx).Model(url).
+        Where("max_clicks IS NULL OR access_count < max_clicks").
+        Update("access_count", gorm.Expr("access_count + ?", 1))
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return ErrClickLimitReached
+    }
+    return nil
+}
+
+func (r *URLRepositoryImpl) GetTopDomains(ctx context.Context, limit int, scope URLScope) ([]models.DomainMetric, error) {
     var metrics []models.DomainMetric
-    err := r.db.Model(&models.URL{}).
+    err := applyScope(r.db.WithContext(ctx).Model(&models.URL{}), scope).
         Select("domain, COUNT(*) as count").
         Group("domain").
         Order("count DESC").
         Limit(limit).
         Scan(&metrics).Error
     return metrics, err
+}
+
+// List returns a page of the links matching filter, and how many match in
+// all regardless of the page.
+func (r *URLRepositoryImpl) List(ctx context.Context, filter URLFilter) ([]models.URL, int64, error) {
+    query := applyScope(r.db.WithContext(ctx).Model(&models.URL{}), filter.Scope)
+    if filter.Domain != "" {
+        query = query.Where("domain = ?", filter.Domain)
+    }
+    if filter.Status != "" {
+        query = query.Where("status = ?", filter.Status)
+    }
+    if filter.CreatedAfter != nil {
+        query = query.Where("created_at >= ?", *filter.CreatedAfter)
+    }
+    if filter.CreatedBefore != nil {
+        query = query.Where("created_at < ?", *filter.CreatedBefore)
+    }
+    if filter.MinClicks != nil {
+        query = query.Where("access_count >= ?", *filter.MinClicks)
+    }
+    if filter.Search != "" {
+        query = query.Where("original_url LIKE ?", "%"+likeEscaper.Replace(filter.Search)+"%")
+    }
+
+    var total int64
+    if err := query.Count(&total).Error; err != nil {
+        return nil, 0, err
+    }
+
+    column, direction, compare := "created_at", "DESC", "<"
+    if filter.Sort == SortClicks {
+        column = "access_count"
+    }
+    if filter.Ascending {
+        direction, compare = "ASC", ">"
+    }
+    if filter.After != nil {
+        var value interface{} = filter.After.CreatedAt
+        if filter.Sort == SortClicks {
+            value = filter.After.AccessCount
+        }
+        query = query.Where(fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, compare),
+            value, value, filter.After.ID)
+    }
+
+    var urls []models.URL
+    err := query.Order(fmt.Sprintf("%[1]s %[2]s, id %[2]s", column, direction)).
+        Limit(filter.Limit).
+        Find(&urls).Error
+    return urls, total, err
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (r *URLRepositoryImpl) Each(ctx context.Context, scope URLScope, fn func(urls []models.URL) error) error {
+    var urls []models.URL
+    return applyScope(r.db.WithContext(ctx), scope).
+        FindInBatches(&urls, eachBatchSize, func(tx *gorm.DB, batch int) error {
+            return fn(urls)
+        }).Error
+}
+
+// Update writes the mutable attributes of a link.
+func (r *URLRepositoryImpl) Update(ctx context.Context, url *models.URL) error {
+    return r.db.WithContext(ctx).Model(url).
+        Select("original_url", "domain", "status", "expires_at", "active_from", "active_until",
+            "redirect_code", "preview",
+            "device_rules", "geo_rules", "variants", "sticky_variants",
+            "passthrough_path", "passthrough_query", "query_conflict").
+        Updates(url).Error
+}
+
+func (r *URLRepositoryImpl) Delete(ctx context.Context, url *models.URL) error {
+    return r.db.WithContext(ctx).Delete(url).Error
+}
+
+func applyScope(query *gorm.DB, scope URLScope) *gorm.DB {
+    if scope.WorkspaceID != nil {
+        return query.Where("workspace_id = ?", *scope.WorkspaceID)
+    }
+    if scope.OwnerID != nil {
+        return query.Where("workspace_id IS NULL AND owner_id = ?", *scope.OwnerID)
+    }
+    return query.Where("workspace_id IS NULL AND owner_id IS NULL")
 }
\ No newline at end of file
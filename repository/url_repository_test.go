@@ -1,6 +1,7 @@
 package repository
 
 import (
+    "context"
     "testing"
     "urlshortner/models"
     
@@ -33,11 +34,30 @@ func TestURLRepository(t *testing.T) {
             Domain:      "example.com",
         }
         
-        err := repo.Create(url)
+        err := repo.Create(context.Background(), url)
         assert.NoError(t, err)
         
-        found, err := repo.FindByShortCode("abc123")
+        found, err := repo.FindByShortCode(context.Background(), "abc123")
         assert.NoError(t, err)
         assert.Equal(t, url.OriginalURL, found.OriginalURL)
+    })    
+    t.Run("Only plain links are found by destination", func(t *testing.T) {
+        maxClicks := 1
+        locked := &models.URL{OriginalURL: "https://example.com/doc", ShortCode: "locked", Domain: "example.com", PasswordHash: "$2a$10$hash"}
+        once := &models.URL{OriginalURL: "https://example.com/doc", ShortCode: "once12", Domain: "example.com", MaxClicks: &maxClicks}
+        plain := &models.URL{OriginalURL: "https://example.com/doc", ShortCode: "plain1", Domain: "example.com"}
+        for _, url := range []*models.URL{locked, once, plain} {
+            assert.NoError(t, repo.Create(context.Background(), url))
+        }
+        
+        found, err := repo.FindByOriginalURL(context.Background(), "https://example.com/doc", URLScope{})
+        assert.NoError(t, err)
+        assert.Equal(t, "plain1", found.ShortCode)
+        
+        all, err := repo.FindByOriginalURLs(context.Background(), []string{"https://example.com/doc"}, URLScope{})
+        assert.NoError(t, err)
+        if assert.Len(t, all, 1) {
+            assert.Equal(t, "plain1", all[0].ShortCode)
+        }
     })
-}
\ No newline at end of file
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"urlshortner/models"
+
+	"gorm.io/gorm"
+)
+
+type UTMTemplateRepository interface {
+	Create(ctx context.Context, template *models.UTMTemplate) error
+	FindByName(ctx context.Context, workspaceID uint, name string) (*models.UTMTemplate, error)
+	List(ctx context.Context, workspaceID uint) ([]models.UTMTemplate, error)
+	// Delete removes a template of the workspace, returning
+	// gorm.ErrRecordNotFound if it has none with that ID.
+	Delete(ctx context.Context, workspaceID, id uint) error
+}
+
+type UTMTemplateRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewUTMTemplateRepository(db *gorm.DB) UTMTemplateRepository {
+	return &UTMTemplateRepositoryImpl{db: db}
+}
+
+func (r *UTMTemplateRepositoryImpl) Create(ctx context.Context, template *models.UTMTemplate) error {
+	return r.db.WithContext(ctx).Create(template).Error
+}
+
+func (r *UTMTemplateRepositoryImpl) FindByName(ctx context.Context, workspaceID uint, name string) (*models.UTMTemplate, error) {
+	var template models.UTMTemplate
+	err := r.db.WithContext(ctx).Where("workspace_id = ? AND name = ?", workspaceID, name).First(&template).Error
+	return &template, err
+}
+
+func (r *UTMTemplateRepositoryImpl) List(ctx context.Context, workspaceID uint) ([]models.UTMTemplate, error) {
+	var templates []models.UTMTemplate
+	err := r.db.WithContext(ctx).Where("workspace_id = ?", workspaceID).Order("name").Find(&templates).Error
+	return templates, err
+}
+
+func (r *UTMTemplateRepositoryImpl) Delete(ctx context.Context, workspaceID, id uint) error {
+	result := r.db.WithContext(ctx).Where("workspace_id = ?", workspaceID).Delete(&models.UTMTemplate{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
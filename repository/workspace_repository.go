@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"urlshortner/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type WorkspaceRepository interface {
+	Create(ctx context.Context, workspace *models.Workspace) error
+	FindByID(ctx context.Context, id uint) (*models.Workspace, error)
+	List(ctx context.Context) ([]models.Workspace, error)
+	// Update writes the fallback settings of the workspace.
+	Update(ctx context.Context, workspace *models.Workspace) error
+	// SaveMember adds the user to the workspace or changes their role.
+	SaveMember(ctx context.Context, member *models.WorkspaceMember) error
+	ListMembers(ctx context.Context, workspaceID uint) ([]models.WorkspaceMember, error)
+	MemberRole(ctx context.Context, workspaceID, userID uint) (models.Role, error)
+}
+
+type WorkspaceRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewWorkspaceRepository(db *gorm.DB) WorkspaceRepository {
+	return &WorkspaceRepositoryImpl{db: db}
+}
+
+func (r *WorkspaceRepositoryImpl) Create(ctx context.Context, workspace *models.Workspace) error {
+	return r.db.WithContext(ctx).Create(workspace).Error
+}
+
+func (r *WorkspaceRepositoryImpl) FindByID(ctx context.Context, id uint) (*models.Workspace, error) {
+	var workspace models.Workspace
+	err := r.db.WithContext(ctx).First(&workspace, id).Error
+	return &workspace, err
+}
+
+func (r *WorkspaceRepositoryImpl) List(ctx context.Context) ([]models.Workspace, error) {
+	var workspaces []models.Workspace
+	err := r.db.WithContext(ctx).Order("id").Find(&workspaces).Error
+	return workspaces, err
+}
+
+func (r *WorkspaceRepositoryImpl) Update(ctx context.Context, workspace *models.Workspace) error {
+	return r.db.WithContext(ctx).Model(workspace).Select("fallback_url", "fallback_page").Updates(workspace).Error
+}
+
+func (r *WorkspaceRepositoryImpl) SaveMember(ctx context.Context, member *models.WorkspaceMember) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "workspace_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"role"}),
+	}).Create(member).Error
+}
+
+func (r *WorkspaceRepositoryImpl) ListMembers(ctx context.Context, workspaceID uint) ([]models.WorkspaceMember, error) {
+	var members []models.WorkspaceMember
+	err := r.db.WithContext(ctx).Where("workspace_id = ?", workspaceID).Order("id").Find(&members).Error
+	return members, err
+}
+
+// MemberRole returns an empty role, not an error, for non-members.
+func (r *WorkspaceRepositoryImpl) MemberRole(ctx context.Context, workspaceID, userID uint) (models.Role, error) {
+	var member models.WorkspaceMember
+	err := r.db.WithContext(ctx).Where("workspace_id = ? AND user_id = ?", workspaceID, userID).First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return member.Role, nil
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"urlshortner/models"
+	"urlshortner/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+type MockAPIKeyRepository struct {
+	mock.Mock
+}
+
+func (m *MockAPIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+func (m *MockAPIKeyRepository) FindByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	args := m.Called(hash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyRepository) FindByID(ctx context.Context, id uint) (*models.APIKey, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyRepository) List(ctx context.Context) ([]models.APIKey, error) {
+	args := m.Called()
+	return args.Get(0).([]models.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyRepository) ListByWorkspace(ctx context.Context, workspaceID uint) ([]models.APIKey, error) {
+	args := m.Called(workspaceID)
+	return args.Get(0).([]models.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyRepository) Revoke(ctx context.Context, id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockAPIKeyRepository) TouchLastUsed(ctx context.Context, key *models.APIKey) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+func TestCreateKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		scopes      []string
+		setupMock   func(*MockAPIKeyRepository)
+		expectError error
+	}{
+		{
+			name:   "Successfully create key",
+			scopes: []string{"shorten", "read-stats"},
+			setupMock: func(m *MockAPIKeyRepository) {
+				m.On("Create", mock.Anything).Return(nil)
+			},
+		},
+		{
+			name:        "Unknown scope",
+			scopes:      []string{"shorten", "delete-everything"},
+			setupMock:   func(m *MockAPIKeyRepository) {},
+			expectError: ErrInvalidScope,
+		},
+		{
+			name:        "No scopes",
+			scopes:      nil,
+			setupMock:   func(m *MockAPIKeyRepository) {},
+			expectError: ErrInvalidScope,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockAPIKeyRepository)
+			tt.setupMock(mockRepo)
+			service := NewAPIKeyService(mockRepo)
+
+			key, rawKey, err := service.CreateKey(context.Background(), "ci", tt.scopes, nil, nil)
+
+			if tt.expectError != nil {
+				assert.ErrorIs(t, err, tt.expectError)
+			} else {
+				assert.NoError(t, err)
+				assert.True(t, strings.HasPrefix(rawKey, key.Prefix))
+				assert.Equal(t, utils.HashAPIKey(rawKey), key.KeyHash)
+				assert.NotContains(t, key.KeyHash, rawKey)
+				assert.Equal(t, "shorten,read-stats", key.Scopes)
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	stored := &models.APIKey{ID: 1, Name: "ci", Scopes: "shorten"}
+
+	tests := []struct {
+		name        string
+		rawKey      string
+		setupMock   func(*MockAPIKeyRepository)
+		expectError error
+	}{
+		{
+			name:   "Valid key",
+			rawKey: "us_valid",
+			setupMock: func(m *MockAPIKeyRepository) {
+				m.On("FindByHash", utils.HashAPIKey("us_valid")).Return(stored, nil)
+				m.On("TouchLastUsed", stored).Return(nil)
+			},
+		},
+		{
+			name:   "Last-used update failure does not reject the key",
+			rawKey: "us_valid",
+			setupMock: func(m *MockAPIKeyRepository) {
+				m.On("FindByHash", utils.HashAPIKey("us_valid")).Return(stored, nil)
+				m.On("TouchLastUsed", stored).Return(errors.New("database error"))
+			},
+		},
+		{
+			name:   "Unknown or revoked key",
+			rawKey: "us_unknown",
+			setupMock: func(m *MockAPIKeyRepository) {
+				m.On("FindByHash", utils.HashAPIKey("us_unknown")).Return(nil, gorm.ErrRecordNotFound)
+			},
+			expectError: ErrInvalidAPIKey,
+		},
+		{
+			name:   "Lookup failure is not an invalid key",
+			rawKey: "us_valid",
+			setupMock: func(m *MockAPIKeyRepository) {
+				m.On("FindByHash", utils.HashAPIKey("us_valid")).Return(nil, errors.New("database error"))
+			},
+			expectError: ErrStorage,
+		},
+		{
+			name:        "Empty key",
+			rawKey:      "",
+			setupMock:   func(m *MockAPIKeyRepository) {},
+			expectError: ErrInvalidAPIKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockAPIKeyRepository)
+			tt.setupMock(mockRepo)
+			service := NewAPIKeyService(mockRepo)
+
+			key, err := service.Authenticate(context.Background(), tt.rawKey)
+
+			if tt.expectError != nil {
+				assert.ErrorIs(t, err, tt.expectError)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, stored, key)
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
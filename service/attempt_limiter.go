@@ -0,0 +1,93 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// attemptLimiter counts failed attempts per key within a sliding window and
+// refuses further attempts once the limit is reached. Attempts in progress
+// count as failed until released.
+type attemptLimiter struct {
+	max    int
+	window time.Duration
+
+	mu       sync.Mutex
+	failures map[string][]time.Time
+	// swept is when keys no longer looked up were last evicted.
+	swept time.Time
+}
+
+func newAttemptLimiter(max int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		max:      max,
+		window:   window,
+		failures: make(map[string][]time.Time),
+	}
+}
+
+// Reserve counts an attempt against key before it is made, so that
+// concurrent attempts cannot all get past the limit, and reports whether it
+// is permitted and, if not, how long until the oldest attempt leaves the
+// window. Attempts that turn out not to have failed are given back with
+// Release.
+func (l *attemptLimiter) Reserve(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	recent := l.prune(key, now)
+	if len(recent) >= l.max {
+		return false, recent[0].Add(l.window).Sub(now)
+	}
+	l.failures[key] = append(recent, now)
+	return true, 0
+}
+
+// Release gives back the attempt reserved for key at the given time.
+func (l *attemptLimiter) Release(key string, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	failures := l.failures[key]
+	for i, t := range failures {
+		if t.Equal(at) {
+			failures = append(failures[:i:i], failures[i+1:]...)
+			break
+		}
+	}
+	if len(failures) == 0 {
+		delete(l.failures, key)
+		return
+	}
+	l.failures[key] = failures
+}
+
+// sweep prunes every key once per window, so that keys not tried again do
+// not stay in memory. Callers hold l.mu.
+func (l *attemptLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < l.window {
+		return
+	}
+	for key := range l.failures {
+		l.prune(key, now)
+	}
+	l.swept = now
+}
+
+// prune drops failures that fell out of the window. Callers hold l.mu.
+func (l *attemptLimiter) prune(key string, now time.Time) []time.Time {
+	failures := l.failures[key]
+	cutoff := now.Add(-l.window)
+	i := 0
+	for i < len(failures) && !failures[i].After(cutoff) {
+		i++
+	}
+	failures = failures[i:]
+	if len(failures) == 0 {
+		delete(l.failures, key)
+		return nil
+	}
+	l.failures[key] = failures
+	return failures
+}
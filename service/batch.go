@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"urlshortner/models"
+	"urlshortner/utils"
+
+	"gorm.io/gorm"
+)
+
+var ErrBatchTooLarge = errors.New("too many urls in batch")
+
+// insertAttempts bounds how often ShortenURL and insertBatch retry after
+// losing a race for a short code.
+const insertAttempts = 3
+
+// BatchItem is one link to create in ShortenBatch.
+type BatchItem struct {
+	URL       string
+	Alias     string
+	ExpiresAt *time.Time
+}
+
+// BatchResult is the outcome of one BatchItem: the link, new or existing,
+// or the reason it was not created.
+type BatchResult struct {
+	URL *models.URL
+	Err error
+}
+
+// ShortenBatch creates many links at once, with opts applied to all of them.
+// An invalid item fails on its own, as does one whose alias is taken while
+// the batch is being created; the others are inserted together. Only errors
+// that concern the whole batch are returned as such.
+func (s *URLServiceImpl) ShortenBatch(ctx context.Context, items []BatchItem, opts ShortenOptions) ([]BatchResult, error) {
+	if len(items) > s.config.Batch.MaxItems {
+		return nil, ErrBatchTooLarge
+	}
+	template, err := s.utmTemplate(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	// One hash serves every link: bcrypt is too slow to run per item
+	passwordHash, err := hashPassword(opts.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchResult, len(items))
+	links := make([]*models.URL, len(items))
+	dedupe := make([]bool, len(items))
+	for i, item := range items {
+		itemOpts := opts
+		itemOpts.Alias = item.Alias
+		itemOpts.ExpiresAt = item.ExpiresAt
+		if links[i], err = newLink(item.URL, itemOpts, template); err != nil {
+			results[i].Err = err
+			continue
+		}
+		links[i].PasswordHash = passwordHash
+		dedupe[i] = !itemOpts.hasLinkSettings()
+	}
+
+	if err := s.reuseExisting(ctx, links, dedupe, results, opts); err != nil {
+		return nil, err
+	}
+	aliased := make([]bool, len(links))
+	for i, link := range links {
+		aliased[i] = link != nil && results[i].URL == nil && link.ShortCode != ""
+	}
+	used := make(map[string]bool)
+	if err := s.assignCodes(ctx, links, results, used); err != nil {
+		return nil, err
+	}
+	if err := s.insertBatch(ctx, links, results, aliased, used); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// insertBatch creates the new links of the batch together. A link created
+// meanwhile under one of their codes fails the insert as a whole; the items
+// whose alias it took are then reported as taken, those whose generated
+// code it took get another, and the rest are inserted again.
+func (s *URLServiceImpl) insertBatch(ctx context.Context, links []*models.URL, results []BatchResult, aliased []bool, used map[string]bool) error {
+	for attempt := 1; ; attempt++ {
+		var pending []*models.URL
+		var codes []string
+		for i, link := range links {
+			if link != nil && results[i].Err == nil && results[i].URL == nil {
+				pending = append(pending, link)
+				codes = append(codes, link.ShortCode)
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		err := s.repo.CreateBatch(ctx, pending)
+		if err == nil {
+			for i, link := range links {
+				if link != nil && results[i].Err == nil && results[i].URL == nil {
+					results[i].URL = link
+				}
+			}
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrDuplicatedKey) || attempt == insertAttempts {
+			return storageError(err)
+		}
+
+		taken, err := s.repo.TakenShortCodes(ctx, codes)
+		if err != nil {
+			return storageError(err)
+		}
+		isTaken := lowerSet(taken)
+		var regenerate []*models.URL
+		for i, link := range links {
+			if link == nil || results[i].Err != nil || results[i].URL != nil || !isTaken[strings.ToLower(link.ShortCode)] {
+				continue
+			}
+			if aliased[i] {
+				results[i].Err = ErrAliasTaken
+				continue
+			}
+			link.ShortCode = ""
+			regenerate = append(regenerate, link)
+		}
+		if err := s.assignCodes(ctx, regenerate, make([]BatchResult, len(regenerate)), used); err != nil {
+			return err
+		}
+	}
+}
+
+// reuseExisting hands out existing links for the items marked in dedupe, as
+// ShortenURL does, and the same new link for repeats within the batch.
+func (s *URLServiceImpl) reuseExisting(ctx context.Context, links []*models.URL, dedupe []bool, results []BatchResult, opts ShortenOptions) error {
+	var destinations []string
+	for i, link := range links {
+		if dedupe[i] {
+			destinations = append(destinations, link.OriginalURL)
+		}
+	}
+	if len(destinations) == 0 {
+		return nil
+	}
+
+	existing, err := s.repo.FindByOriginalURLs(ctx, destinations, opts.scope())
+	if err != nil {
+		return storageError(err)
+	}
+	byDestination := make(map[string]*models.URL, len(existing))
+	for i := range existing {
+		if _, seen := byDestination[existing[i].OriginalURL]; !seen && existing[i].Plain() {
+			byDestination[existing[i].OriginalURL] = &existing[i]
+		}
+	}
+	for i, link := range links {
+		if !dedupe[i] {
+			continue
+		}
+		if found, ok := byDestination[link.OriginalURL]; ok {
+			results[i].URL = found
+			links[i] = found
+		} else {
+			byDestination[link.OriginalURL] = link
+		}
+	}
+	return nil
+}
+
+// assignCodes checks the aliases of the batch and generates codes for the
+// other new links, none of which may be taken or repeat within the batch.
+// used holds the codes already handed out in the batch, lowercased; codes
+// are compared ignoring case, as the database does.
+func (s *URLServiceImpl) assignCodes(ctx context.Context, links []*models.URL, results []BatchResult, used map[string]bool) error {
+	var aliases []string
+	for i, link := range links {
+		if link == nil || results[i].Err != nil || results[i].URL != nil || link.ShortCode == "" {
+			continue
+		}
+		if used[strings.ToLower(link.ShortCode)] {
+			results[i].Err = ErrAliasTaken
+			continue
+		}
+		used[strings.ToLower(link.ShortCode)] = true
+		aliases = append(aliases, link.ShortCode)
+	}
+	if len(aliases) > 0 {
+		taken, err := s.repo.TakenShortCodes(ctx, aliases)
+		if err != nil {
+			return storageError(err)
+		}
+		isTaken := lowerSet(taken)
+		for i, link := range links {
+			if link != nil && results[i].Err == nil && results[i].URL == nil && isTaken[strings.ToLower(link.ShortCode)] {
+				results[i].Err = ErrAliasTaken
+			}
+		}
+	}
+
+	var unassigned []*models.URL
+	for i, link := range links {
+		if link != nil && results[i].Err == nil && results[i].URL == nil && link.ShortCode == "" {
+			unassigned = append(unassigned, link)
+		}
+	}
+	// Generate codes for all, then regenerate those taken, until none are
+	for len(unassigned) > 0 {
+		codes := make([]string, 0, len(unassigned))
+		for _, link := range unassigned {
+			link.ShortCode = utils.GenerateShortCode(s.config.ShortURL.Length)
+			codes = append(codes, link.ShortCode)
+		}
+		taken, err := s.repo.TakenShortCodes(ctx, codes)
+		if err != nil {
+			return storageError(err)
+		}
+		isTaken := lowerSet(taken)
+
+		var retry []*models.URL
+		for _, link := range unassigned {
+			code := strings.ToLower(link.ShortCode)
+			if isTaken[code] || used[code] {
+				retry = append(retry, link)
+				continue
+			}
+			used[code] = true
+		}
+		unassigned = retry
+	}
+	return nil
+}
+
+func lowerSet(codes []string) map[string]bool {
+	set := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		set[strings.ToLower(code)] = true
+	}
+	return set
+}
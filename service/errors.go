@@ -0,0 +1,91 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrURLNotFound   = errors.New("url not found")
+	ErrURLDeleted    = errors.New("url has been deleted")
+	ErrURLExpired    = errors.New("url has expired")
+	ErrURLDisabled   = errors.New("url is disabled")
+	ErrURLPaused     = errors.New("url is paused")
+	ErrURLNotActive  = errors.New("url is not active yet")
+	ErrURLEnded      = errors.New("url is no longer active")
+	ErrURLExhausted  = errors.New("url has reached its click limit")
+	ErrInvalidURL    = errors.New("invalid url")
+	ErrInvalidStatus = errors.New("invalid status")
+
+	ErrInvalidRedirectCode = errors.New("invalid redirect code")
+	ErrInvalidAlias        = errors.New("invalid alias")
+	ErrAliasTaken          = errors.New("alias is already taken")
+	ErrInvalidActiveWindow = errors.New("active_until must be after active_from")
+	ErrInvalidMaxClicks    = errors.New("max clicks must be positive")
+	ErrInvalidDeviceRule   = errors.New("invalid device rule")
+	ErrInvalidGeoRule      = errors.New("invalid geo rule")
+	ErrInvalidVariants     = errors.New("invalid variants")
+	ErrInvalidConflictRule = errors.New("invalid query conflict rule")
+
+	ErrPasswordRequired = errors.New("url is password protected")
+	ErrWrongPassword    = errors.New("wrong password")
+	ErrTooManyAttempts  = errors.New("too many failed attempts")
+)
+
+// TooManyAttemptsError is returned, matching ErrTooManyAttempts, once a
+// password-protected link has seen too many wrong passwords.
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (e *TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("%v, retry in %v", ErrTooManyAttempts, e.RetryAfter.Round(time.Second))
+}
+
+func (e *TooManyAttemptsError) Is(target error) bool {
+	return target == ErrTooManyAttempts
+}
+
+// NotActiveError is returned, matching ErrURLNotActive, for a link whose
+// activation window has not started yet.
+type NotActiveError struct {
+	ActiveFrom time.Time
+}
+
+func (e *NotActiveError) Error() string {
+	return fmt.Sprintf("%v, active from %v", ErrURLNotActive, e.ActiveFrom.Format(time.RFC3339))
+}
+
+func (e *NotActiveError) Is(target error) bool {
+	return target == ErrURLNotActive
+}
+
+// ErrStorage is matched by failures to read or write the database, which
+// are the server's fault rather than the request's.
+var ErrStorage = errors.New("storage failure")
+
+// StorageError wraps a database error, matching ErrStorage.
+type StorageError struct {
+	Err error
+}
+
+func (e *StorageError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrStorage, e.Err)
+}
+
+func (e *StorageError) Unwrap() error {
+	return e.Err
+}
+
+func (e *StorageError) Is(target error) bool {
+	return target == ErrStorage
+}
+
+// storageError wraps err, if not nil, in a StorageError.
+func storageError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &StorageError{Err: err}
+}
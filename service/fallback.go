@@ -0,0 +1,34 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Fallback is what browsers visiting a dead or unknown code get instead of
+// an error: a redirect to URL, or Page. Both are empty if nothing is
+// configured.
+type Fallback struct {
+	URL  string
+	Page string
+	// FromWorkspace marks a fallback set by a workspace rather than by the
+	// deployment, whose page must not run scripts on the short domain.
+	FromWorkspace bool
+}
+
+// Fallback returns the fallback of the workspace the code belongs to, if it
+// has one, and the deployment's otherwise. It looks the code up again: dead
+// links are rare enough not to thread the link through every error.
+func (s *URLServiceImpl) Fallback(ctx context.Context, shortCode string) Fallback {
+	link, err := s.repo.FindByShortCode(ctx, shortCode)
+	if err == nil && link.WorkspaceID != nil {
+		workspace, err := s.workspaces.FindByID(ctx, *link.WorkspaceID)
+		if err != nil {
+			// Log error but don't fail the request
+			slog.ErrorContext(ctx, "Failed to load workspace fallback", "workspace_id", *link.WorkspaceID, "error", err)
+		} else if workspace.FallbackURL != "" || workspace.FallbackPage != "" {
+			return Fallback{URL: workspace.FallbackURL, Page: workspace.FallbackPage, FromWorkspace: true}
+		}
+	}
+	return Fallback{URL: s.config.Fallback.URL, Page: s.config.Fallback.Page}
+}
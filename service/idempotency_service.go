@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+	"urlshortner/models"
+	"urlshortner/repository"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrIdempotencyKeyReused     = errors.New("idempotency key was used for another request")
+	ErrIdempotencyKeyInProgress = errors.New("request with this idempotency key is in progress")
+)
+
+// IdempotencyService remembers the responses to requests sent with an
+// idempotency key, so that retrying a request does not repeat its effects.
+type IdempotencyService interface {
+	// Begin claims key for the request identified by requestHash. If the
+	// request was already made, it returns the record of its response
+	// instead, with replay set.
+	Begin(ctx context.Context, caller, key, requestHash string) (record *models.IdempotencyKey, replay bool, err error)
+	// Finish stores the response to the request claimed by record.
+	Finish(ctx context.Context, record *models.IdempotencyKey, statusCode int, contentType string, body []byte) error
+	// Abandon releases the key of a request that failed, so that it can be
+	// retried.
+	Abandon(ctx context.Context, record *models.IdempotencyKey) error
+}
+
+type IdempotencyServiceImpl struct {
+	repo repository.IdempotencyRepository
+	ttl  time.Duration
+}
+
+// NewIdempotencyService creates the service. Keys can be reused for other
+// requests ttl after they were first seen.
+func NewIdempotencyService(repo repository.IdempotencyRepository, ttl time.Duration) IdempotencyService {
+	return &IdempotencyServiceImpl{repo: repo, ttl: ttl}
+}
+
+func (s *IdempotencyServiceImpl) Begin(ctx context.Context, caller, key, requestHash string) (*models.IdempotencyKey, bool, error) {
+	record, claimed, err := s.repo.Claim(ctx, &models.IdempotencyKey{
+		Caller:      caller,
+		Key:         key,
+		RequestHash: requestHash,
+		ExpiresAt:   time.Now().Add(s.ttl),
+	})
+	// The key was released between the claim and the lookup
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, ErrIdempotencyKeyInProgress
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if claimed {
+		return record, false, nil
+	}
+
+	if record.RequestHash != requestHash {
+		return nil, false, ErrIdempotencyKeyReused
+	}
+	if !record.Completed() {
+		return nil, false, ErrIdempotencyKeyInProgress
+	}
+	return record, true, nil
+}
+
+func (s *IdempotencyServiceImpl) Finish(ctx context.Context, record *models.IdempotencyKey, statusCode int, contentType string, body []byte) error {
+	record.StatusCode = statusCode
+	record.ContentType = contentType
+	record.Body = body
+	return s.repo.Complete(ctx, record)
+}
+
+func (s *IdempotencyServiceImpl) Abandon(ctx context.Context, record *models.IdempotencyKey) error {
+	return s.repo.Delete(ctx, record)
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+	"urlshortner/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockIdempotencyRepository struct {
+	mock.Mock
+}
+
+func (m *MockIdempotencyRepository) Claim(ctx context.Context, key *models.IdempotencyKey) (*models.IdempotencyKey, bool, error) {
+	args := m.Called(key)
+	if args.Get(0) == nil {
+		return nil, false, args.Error(2)
+	}
+	return args.Get(0).(*models.IdempotencyKey), args.Bool(1), args.Error(2)
+}
+
+func (m *MockIdempotencyRepository) Complete(ctx context.Context, key *models.IdempotencyKey) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+func (m *MockIdempotencyRepository) Delete(ctx context.Context, key *models.IdempotencyKey) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+func TestIdempotencyBegin(t *testing.T) {
+	done := &models.IdempotencyKey{Caller: "key:1", Key: "retry-1", RequestHash: "abc", StatusCode: 200, Body: []byte(`{}`)}
+	running := &models.IdempotencyKey{Caller: "key:1", Key: "retry-1", RequestHash: "abc"}
+
+	tests := []struct {
+		name           string
+		requestHash    string
+		existing       *models.IdempotencyKey
+		expectedReplay bool
+		expectedErr    error
+	}{
+		{name: "New key is claimed", requestHash: "abc"},
+		{name: "Completed request is replayed", requestHash: "abc", existing: done, expectedReplay: true},
+		{name: "Key reused for another request", requestHash: "def", existing: done, expectedErr: ErrIdempotencyKeyReused},
+		{name: "Request still running", requestHash: "abc", existing: running, expectedErr: ErrIdempotencyKeyInProgress},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := new(MockIdempotencyRepository)
+			claim := repo.On("Claim", mock.Anything)
+			if tt.existing == nil {
+				claim.Return(&models.IdempotencyKey{RequestHash: tt.requestHash}, true, nil)
+			} else {
+				claim.Return(tt.existing, false, nil)
+			}
+			svc := NewIdempotencyService(repo, time.Hour)
+
+			record, replay, err := svc.Begin(context.Background(), "key:1", "retry-1", tt.requestHash)
+
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedReplay, replay)
+			assert.NotNil(t, record)
+		})
+	}
+}
+
+func TestIdempotencyKeyExpiry(t *testing.T) {
+	repo := new(MockIdempotencyRepository)
+	var claimed *models.IdempotencyKey
+	repo.On("Claim", mock.Anything).Run(func(args mock.Arguments) {
+		claimed = args.Get(0).(*models.IdempotencyKey)
+	}).Return(&models.IdempotencyKey{}, true, nil)
+	svc := NewIdempotencyService(repo, 2*time.Hour)
+
+	_, _, err := svc.Begin(context.Background(), "key:1", "retry-1", "abc")
+
+	require.NoError(t, err)
+	assert.Equal(t, "key:1", claimed.Caller)
+	assert.Equal(t, "retry-1", claimed.Key)
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), claimed.ExpiresAt, time.Minute)
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"urlshortner/models"
+)
+
+func validQueryConflict(rule string) bool {
+	return rule == "" || rule == models.QueryConflictDestination || rule == models.QueryConflictIncoming
+}
+
+// passThrough carries the extra path and query of a visit over to the
+// destination, as far as the link allows.
+func passThrough(link *models.URL, location string, visit Visit) string {
+	// Cleaned as a rooted path, dot segments cannot climb above the
+	// destination's own path
+	extraPath := strings.TrimPrefix(path.Clean("/"+visit.Path), "/")
+	passPath := link.PassthroughPath && extraPath != ""
+	passQuery := link.PassthroughQuery && len(visit.Query) > 0
+	if !passPath && !passQuery {
+		return location
+	}
+
+	target, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+	if passPath {
+		target.Path = strings.TrimSuffix(target.Path, "/") + "/" + extraPath
+		target.RawPath = ""
+	}
+	if passQuery {
+		target.RawQuery = mergeQuery(target.RawQuery, visit.Query, link.QueryConflict == models.QueryConflictIncoming)
+	}
+	return target.String()
+}
+
+// mergeQuery adds the incoming parameters to a raw query string. The
+// destination's parameters keep their order and encoding; a parameter in
+// both is taken from incoming if incomingWins, and left alone otherwise.
+func mergeQuery(rawQuery string, incoming url.Values, incomingWins bool) string {
+	var pairs []string
+	present := make(map[string]bool)
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		key, _, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if incomingWins && incoming.Has(key) {
+			continue
+		}
+		present[key] = true
+		pairs = append(pairs, pair)
+	}
+
+	keys := make([]string, 0, len(incoming))
+	for key := range incoming {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if present[key] {
+			continue
+		}
+		for _, value := range incoming[key] {
+			pairs = append(pairs, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"urlshortner/models"
+	"urlshortner/utils"
+)
+
+// Visit carries what is known about the request following a link, so the
+// link can pick a destination for it.
+type Visit struct {
+	Header http.Header
+	// ClientIP is the visitor's address, as told by a trusted proxy if
+	// there is one.
+	ClientIP string
+	// Variant is the variant the visitor was served before, for links
+	// with sticky variants.
+	Variant string
+	// Previewed is the variant the preview page showed the visitor, which
+	// they are then served whether the link is sticky or not.
+	Previewed string
+	// Path is whatever followed the short code in the requested path.
+	Path string
+	// Query is the query string of the request.
+	Query url.Values
+	// Source is how the visitor reached the link, recorded with the click.
+	Source string
+}
+
+// visitor is what a Visit tells about the person behind it.
+type visitor struct {
+	device    utils.Device
+	country   string
+	variant   string
+	previewed string
+}
+
+const maxVariantNameLength = 50
+
+// Device platforms a rule may target besides the operating systems known to
+// utils.ParseUserAgent.
+const (
+	PlatformMobile  = "mobile"
+	PlatformDesktop = "desktop"
+)
+
+func validPlatform(platform string) bool {
+	switch platform {
+	case utils.OSiOS, utils.OSAndroid, utils.OSWindows, utils.OSMacOS, utils.OSLinux, utils.OSChromeOS,
+		PlatformMobile, PlatformDesktop:
+		return true
+	}
+	return false
+}
+
+func validateDeviceRules(rules models.DeviceRules) error {
+	for _, rule := range rules {
+		if !validPlatform(rule.Platform) || !validDestination(rule.URL) {
+			return ErrInvalidDeviceRule
+		}
+	}
+	return nil
+}
+
+// normalizeGeoRules validates rules and upper-cases their country codes.
+func normalizeGeoRules(rules models.GeoRules) (models.GeoRules, error) {
+	normalized := make(models.GeoRules, 0, len(rules))
+	for _, rule := range rules {
+		if len(rule.Countries) == 0 || !validDestination(rule.URL) {
+			return nil, ErrInvalidGeoRule
+		}
+		countries := make([]string, 0, len(rule.Countries))
+		for _, country := range rule.Countries {
+			country = strings.ToUpper(country)
+			if !validCountryCode(country) {
+				return nil, ErrInvalidGeoRule
+			}
+			countries = append(countries, country)
+		}
+		normalized = append(normalized, models.GeoRule{Countries: countries, URL: rule.URL})
+	}
+	return normalized, nil
+}
+
+func validCountryCode(code string) bool {
+	return len(code) == 2 && code[0] >= 'A' && code[0] <= 'Z' && code[1] >= 'A' && code[1] <= 'Z'
+}
+
+func validateVariants(variants models.Variants) error {
+	names := make(map[string]bool, len(variants))
+	total := 0
+	for _, variant := range variants {
+		if variant.Name == "" || len(variant.Name) > maxVariantNameLength || names[variant.Name] ||
+			variant.Weight < 0 || !validDestination(variant.URL) {
+			return ErrInvalidVariants
+		}
+		names[variant.Name] = true
+		total += variant.Weight
+	}
+	if len(variants) > 0 && total == 0 {
+		return ErrInvalidVariants
+	}
+	return nil
+}
+
+// validDestination reports whether target is an absolute http(s) URL.
+func validDestination(target string) bool {
+	parsedURL, err := url.Parse(target)
+	return err == nil && (parsedURL.Scheme == "http" || parsedURL.Scheme == "https") && parsedURL.Host != ""
+}
+
+// destination picks where link sends the visitor: the first device rule
+// matching them, else the first geo rule listing their country, else one of
+// the link's variants, else the original URL. The name of the variant
+// served, if any, is returned alongside.
+func destination(link *models.URL, who visitor) (string, string) {
+	for _, rule := range link.DeviceRules {
+		if matchesPlatform(rule.Platform, who.device) {
+			return rule.URL, ""
+		}
+	}
+	if who.country != "" {
+		for _, rule := range link.GeoRules {
+			if slices.Contains(rule.Countries, who.country) {
+				return rule.URL, ""
+			}
+		}
+	}
+	if variant := pickVariant(link, who); variant != nil {
+		return variant.URL, variant.Name
+	}
+	return link.OriginalURL, ""
+}
+
+// pickVariant returns the variant previewed, or the one served before if
+// the link is sticky, as long as it is still in rotation; else one drawn at
+// random by weight.
+func pickVariant(link *models.URL, who visitor) *models.Variant {
+	if variant := inRotation(link, who.previewed); variant != nil {
+		return variant
+	}
+	if variant := inRotation(link, who.variant); variant != nil && link.StickyVariants {
+		return variant
+	}
+
+	total := 0
+	for _, variant := range link.Variants {
+		total += variant.Weight
+	}
+	if total == 0 {
+		return nil
+	}
+
+	n := rand.IntN(total)
+	for i, variant := range link.Variants {
+		if n < variant.Weight {
+			return &link.Variants[i]
+		}
+		n -= variant.Weight
+	}
+	return nil
+}
+
+// inRotation returns the variant of link called name if it is still served.
+func inRotation(link *models.URL, name string) *models.Variant {
+	for i, variant := range link.Variants {
+		if name != "" && variant.Name == name && variant.Weight > 0 {
+			return &link.Variants[i]
+		}
+	}
+	return nil
+}
+
+func matchesPlatform(platform string, device utils.Device) bool {
+	switch platform {
+	case PlatformMobile:
+		return device.Mobile
+	case PlatformDesktop:
+		// Unknown clients, mostly bots, get the original URL
+		return !device.Mobile && device.OS != ""
+	}
+	return device.OS == platform
+}
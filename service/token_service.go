@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"urlshortner/auth"
+	"urlshortner/config"
+	"urlshortner/repository"
+
+	"gorm.io/gorm"
+)
+
+// TokenService authenticates callers that present a JWT from the identity
+// provider instead of an API key.
+type TokenService interface {
+	AuthenticateToken(ctx context.Context, token string) (*auth.Principal, error)
+}
+
+type TokenServiceImpl struct {
+	verifier *auth.JWTVerifier
+	users    repository.UserRepository
+	config   *config.Config
+}
+
+func NewTokenService(verifier *auth.JWTVerifier, users repository.UserRepository, cfg *config.Config) TokenService {
+	return &TokenServiceImpl{
+		verifier: verifier,
+		users:    users,
+		config:   cfg,
+	}
+}
+
+// AuthenticateToken maps a verified token onto a principal. The user claim
+// must name an existing user; tokens without it, such as those of internal
+// services, act outside any user's or workspace's links.
+func (s *TokenServiceImpl) AuthenticateToken(ctx context.Context, token string) (*auth.Principal, error) {
+	claims, err := s.verifier.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	principal := &auth.Principal{Name: claims.String("sub"), Issuer: claims.String("iss")}
+	for _, scope := range claims.Strings(s.config.JWT.ScopeClaim) {
+		if auth.ValidScope(scope) {
+			principal.Scopes = append(principal.Scopes, scope)
+		}
+	}
+
+	if email := claims.String(s.config.JWT.UserClaim); email != "" {
+		user, err := s.users.FindByEmail(ctx, email)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: unknown user %q", auth.ErrInvalidToken, email)
+		}
+		if err != nil {
+			return nil, err
+		}
+		principal.UserID = &user.ID
+	}
+
+	if workspaceID, ok := uintClaim(claims, s.config.JWT.WorkspaceClaim); ok {
+		principal.WorkspaceID = &workspaceID
+	}
+
+	return principal, nil
+}
+
+// uintClaim accepts IDs encoded as JSON numbers or as strings.
+func uintClaim(claims auth.Claims, name string) (uint, bool) {
+	switch value := claims[name].(type) {
+	case float64:
+		if value > 0 && value == float64(uint(value)) {
+			return uint(value), true
+		}
+	case string:
+		if id, err := strconv.ParseUint(value, 10, 64); err == nil && id > 0 {
+			return uint(id), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+	"urlshortner/auth"
+	"urlshortner/config"
+	"urlshortner/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keySet := auth.NewKeySet(map[string]crypto.PublicKey{"test": &key.PublicKey})
+	verifier := auth.NewJWTVerifier(keySet, "https://id.example.com", "urlshortner")
+
+	cfg := &config.Config{}
+	cfg.JWT.UserClaim = "email"
+	cfg.JWT.WorkspaceClaim = "workspace_id"
+	cfg.JWT.ScopeClaim = "scope"
+
+	token := func(extra map[string]interface{}) string {
+		claims := map[string]interface{}{
+			"iss":   "https://id.example.com",
+			"aud":   "urlshortner",
+			"sub":   "abc",
+			"scope": "shorten read-stats openid",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		}
+		for k, v := range extra {
+			claims[k] = v
+		}
+		return signRS256(t, key, claims)
+	}
+
+	userID, workspaceID := uint(7), uint(3)
+
+	tests := []struct {
+		name            string
+		token           string
+		setupMock       func(*MockUserRepository)
+		expectPrincipal *auth.Principal
+		expectError     error
+	}{
+		{
+			name:  "User with workspace",
+			token: token(map[string]interface{}{"email": "jane@example.com", "workspace_id": 3}),
+			setupMock: func(m *MockUserRepository) {
+				m.On("FindByEmail", "jane@example.com").Return(&models.User{ID: userID}, nil)
+			},
+			expectPrincipal: &auth.Principal{
+				Name:        "abc",
+				Issuer:      "https://id.example.com",
+				Scopes:      []string{"shorten", "read-stats"},
+				UserID:      &userID,
+				WorkspaceID: &workspaceID,
+			},
+		},
+		{
+			name:      "Service token without user",
+			token:     token(map[string]interface{}{"workspace_id": "3"}),
+			setupMock: func(m *MockUserRepository) {},
+			expectPrincipal: &auth.Principal{
+				Name:        "abc",
+				Issuer:      "https://id.example.com",
+				Scopes:      []string{"shorten", "read-stats"},
+				WorkspaceID: &workspaceID,
+			},
+		},
+		{
+			name:  "Unknown user",
+			token: token(map[string]interface{}{"email": "ghost@example.com"}),
+			setupMock: func(m *MockUserRepository) {
+				m.On("FindByEmail", "ghost@example.com").Return(nil, gorm.ErrRecordNotFound)
+			},
+			expectError: auth.ErrInvalidToken,
+		},
+		{
+			name:        "Invalid token",
+			token:       token(map[string]interface{}{"aud": "other"}),
+			setupMock:   func(m *MockUserRepository) {},
+			expectError: auth.ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userRepo := new(MockUserRepository)
+			tt.setupMock(userRepo)
+			service := NewTokenService(verifier, userRepo, cfg)
+
+			principal, err := service.AuthenticateToken(context.Background(), tt.token)
+
+			if tt.expectError != nil {
+				assert.ErrorIs(t, err, tt.expectError)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectPrincipal, principal)
+			}
+			userRepo.AssertExpectations(t)
+		})
+	}
+
+	t.Run("Database failure is not an invalid token", func(t *testing.T) {
+		userRepo := new(MockUserRepository)
+		userRepo.On("FindByEmail", "jane@example.com").Return(nil, errors.New("database error"))
+		service := NewTokenService(verifier, userRepo, cfg)
+
+		_, err := service.AuthenticateToken(context.Background(), token(map[string]interface{}{"email": "jane@example.com"}))
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, auth.ErrInvalidToken)
+	})
+}
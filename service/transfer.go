@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"urlshortner/linkio"
+	"urlshortner/models"
+	"urlshortner/repository"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// importChunkSize is the number of records ImportURLs creates at a time.
+const importChunkSize = 500
+
+var (
+	ErrUnreadableImport    = errors.New("unreadable import")
+	ErrInvalidAccessCount  = errors.New("access count must not be negative")
+	ErrInvalidPasswordHash = errors.New("password hash is not a bcrypt hash")
+)
+
+// ImportOptions say where imported links go and what becomes of records
+// whose short code is taken.
+type ImportOptions struct {
+	OwnerID     *uint
+	WorkspaceID *uint
+	// RenameConflicts creates links whose code is taken under a generated
+	// code instead of skipping them.
+	RenameConflicts bool
+}
+
+// ImportReport sums up an import; lines are those of the input.
+type ImportReport struct {
+	Imported  int
+	Conflicts []ImportConflict
+	Errors    []ImportError
+}
+
+// ImportConflict is a record whose short code was taken. NewShortCode is
+// the code it was created under, empty if it was skipped.
+type ImportConflict struct {
+	Line         int
+	ShortCode    string
+	NewShortCode string
+}
+
+// ImportError is a record that could not be imported.
+type ImportError struct {
+	Line int
+	Err  error
+}
+
+// ExportURLs writes the links of scope to w, oldest first, and flushes it.
+func (s *URLServiceImpl) ExportURLs(ctx context.Context, w linkio.Writer, scope repository.URLScope) error {
+	err := s.repo.Each(ctx, scope, func(urls []models.URL) error {
+		for i := range urls {
+			if err := w.Write(linkio.RecordOf(&urls[i])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// lineRecord is a record read for import and the line it came from.
+type lineRecord struct {
+	line   int
+	record linkio.Record
+}
+
+// ImportURLs creates links from the records of r, keeping their short codes
+// and access counts. Invalid records and those whose code is taken are
+// reported rather than failing the import. Records are created in chunks,
+// so on error the report covers those created before it.
+func (s *URLServiceImpl) ImportURLs(ctx context.Context, r linkio.Reader, opts ImportOptions) (*ImportReport, error) {
+	report := &ImportReport{}
+	chunk := make([]lineRecord, 0, importChunkSize)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		var recordErr *linkio.RecordError
+		if errors.As(err, &recordErr) {
+			report.Errors = append(report.Errors, ImportError{Line: recordErr.Line, Err: recordErr.Err})
+			continue
+		}
+		if err != nil {
+			return report, fmt.Errorf("%w: %v", ErrUnreadableImport, err)
+		}
+
+		chunk = append(chunk, lineRecord{line: r.Line(), record: record})
+		if len(chunk) == importChunkSize {
+			if err := s.importChunk(ctx, chunk, opts, report); err != nil {
+				return report, err
+			}
+			chunk = chunk[:0]
+		}
+	}
+	if len(chunk) > 0 {
+		if err := s.importChunk(ctx, chunk, opts, report); err != nil {
+			return report, err
+		}
+	}
+
+	sort.SliceStable(report.Errors, func(i, j int) bool {
+		return report.Errors[i].Line < report.Errors[j].Line
+	})
+	return report, nil
+}
+
+func (s *URLServiceImpl) importChunk(ctx context.Context, chunk []lineRecord, opts ImportOptions, report *ImportReport) error {
+	results := make([]BatchResult, len(chunk))
+	links := make([]*models.URL, len(chunk))
+	for i, item := range chunk {
+		links[i], results[i].Err = importLink(item.record, opts)
+	}
+
+	used := make(map[string]bool)
+	if err := s.assignCodes(ctx, links, results, used); err != nil {
+		return err
+	}
+	if opts.RenameConflicts {
+		var conflicting []*models.URL
+		for i, link := range links {
+			if errors.Is(results[i].Err, ErrAliasTaken) {
+				link.ShortCode = ""
+				results[i].Err = nil
+				conflicting = append(conflicting, link)
+			}
+		}
+		if err := s.assignCodes(ctx, conflicting, make([]BatchResult, len(conflicting)), used); err != nil {
+			return err
+		}
+	}
+
+	// Codes taken while the chunk is inserted are conflicts too, renamed
+	// like the others if they are to be
+	aliased := make([]bool, len(links))
+	for i, item := range chunk {
+		aliased[i] = !opts.RenameConflicts && item.record.ShortCode != ""
+	}
+	if err := s.insertBatch(ctx, links, results, aliased, used); err != nil {
+		return err
+	}
+
+	for i, item := range chunk {
+		switch {
+		case errors.Is(results[i].Err, ErrAliasTaken):
+			report.Conflicts = append(report.Conflicts, ImportConflict{
+				Line: item.line, ShortCode: item.record.ShortCode,
+			})
+			continue
+		case results[i].Err != nil:
+			report.Errors = append(report.Errors, ImportError{Line: item.line, Err: results[i].Err})
+			continue
+		case item.record.ShortCode != "" && links[i].ShortCode != item.record.ShortCode:
+			report.Conflicts = append(report.Conflicts, ImportConflict{
+				Line: item.line, ShortCode: item.record.ShortCode, NewShortCode: links[i].ShortCode,
+			})
+		}
+		report.Imported++
+	}
+	return nil
+}
+
+// importLink builds the link described by record, which gets a generated
+// code if it has none. A password comes as the hash it was exported with.
+func importLink(record linkio.Record, opts ImportOptions) (*models.URL, error) {
+	if record.Status != "" && !validStatus(record.Status) {
+		return nil, ErrInvalidStatus
+	}
+	if record.AccessCount < 0 {
+		return nil, ErrInvalidAccessCount
+	}
+	if record.PasswordHash != "" {
+		if _, err := bcrypt.Cost([]byte(record.PasswordHash)); err != nil {
+			return nil, ErrInvalidPasswordHash
+		}
+	}
+	link, err := newLink(record.OriginalURL, ShortenOptions{
+		OwnerID:      opts.OwnerID,
+		WorkspaceID:  opts.WorkspaceID,
+		Alias:        record.ShortCode,
+		RedirectCode: record.RedirectCode,
+		MaxClicks:    record.MaxClicks,
+		ActiveFrom:   record.ActiveFrom,
+		ActiveUntil:  record.ActiveUntil,
+		ExpiresAt:    record.ExpiresAt,
+
+		Preview:          record.Preview,
+		DeviceRules:      record.DeviceRules,
+		GeoRules:         record.GeoRules,
+		Variants:         record.Variants,
+		StickyVariants:   record.StickyVariants,
+		PassthroughPath:  record.PassthroughPath,
+		PassthroughQuery: record.PassthroughQuery,
+		QueryConflict:    record.QueryConflict,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if record.Status != "" {
+		link.Status = record.Status
+	}
+	if record.CreatedAt != nil {
+		link.CreatedAt = *record.CreatedAt
+	}
+	link.PasswordHash = record.PasswordHash
+	link.AccessCount = record.AccessCount
+	return link, nil
+}
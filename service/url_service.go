@@ -1,84 +1,701 @@
 package service
 
 import (
+    "context"
+    "errors"
+    "log/slog"
+    "net"
+    "net/http"
     "net/url"
     "strings"
+    "time"
     "urlshortner/config"
+    "urlshortner/geo"
+    "urlshortner/linkio"
     "urlshortner/models"
     "urlshortner/repository"
     "urlshortner/utils"
+
+    "golang.org/x/crypto/bcrypt"
+    "gorm.io/gorm"
 )
 
+// ShortenOptions carries the optional attributes of a new short link.
+type ShortenOptions struct {
+    // OwnerID attributes the link to a user.
+    OwnerID *uint
+    // Alias is the short code to use instead of a generated one.
+    Alias string
+    // WorkspaceID places the link in a workspace. Identical destinations are
+    // deduplicated within the workspace, or within the owner's personal
+    // links when there is none.
+    WorkspaceID *uint
+    // RedirectCode is one of 301, 302, 307 or 308; 0 uses the default.
+    RedirectCode int
+    // Password, if set, must be entered before the link redirects.
+    Password string
+    // MaxClicks, if set, is the number of visits after which the link
+    // stops redirecting; 1 makes a one-time link.
+    MaxClicks *int
+    // Preview makes the link show its destination before redirecting.
+    Preview bool
+    // DeviceRules send visitors on some platforms to other destinations.
+    DeviceRules models.DeviceRules
+    // GeoRules send visitors from some countries to other destinations.
+    GeoRules models.GeoRules
+    // Variants rotate visitors between several destinations by weight.
+    Variants models.Variants
+    // StickyVariants keeps serving a returning visitor the same variant.
+    StickyVariants bool
+    // PassthroughPath and PassthroughQuery carry the extra path and query
+    // of a visit over to the destination; QueryConflict says whose query
+    // parameters win.
+    PassthroughPath  bool
+    PassthroughQuery bool
+    QueryConflict    string
+    // ActiveFrom and ActiveUntil, if set, bound the window in which the
+    // link redirects.
+    ActiveFrom  *time.Time
+    ActiveUntil *time.Time
+    // ExpiresAt, if set, is when the link stops redirecting for good.
+    ExpiresAt *time.Time
+    // UTMTemplate names a template of the workspace whose UTM parameters
+    // are added to the destination before it is stored.
+    UTMTemplate string
+}
+
+// hasLinkSettings reports whether the caller asked for a link with specific
+// behaviour, in which case an existing link for the same destination must
+// not be handed out instead.
+func (o ShortenOptions) hasLinkSettings() bool {
+    return o.RedirectCode != 0 || o.Password != "" || o.MaxClicks != nil || o.Preview ||
+        len(o.DeviceRules) > 0 || len(o.GeoRules) > 0 || len(o.Variants) > 0 ||
+        o.PassthroughPath || o.PassthroughQuery || o.ActiveFrom != nil || o.ActiveUntil != nil ||
+        o.Alias != "" || o.ExpiresAt != nil
+}
+
+// scope is where identical destinations are deduplicated.
+func (o ShortenOptions) scope() repository.URLScope {
+    if o.WorkspaceID != nil {
+        return repository.URLScope{WorkspaceID: o.WorkspaceID}
+    }
+    return repository.URLScope{OwnerID: o.OwnerID}
+}
+
+// Redirect tells the controller where and how to send a visitor.
+type Redirect struct {
+    Location   string
+    StatusCode int
+    // Link is the link being followed.
+    Link *models.URL
+    // Variant is the name of the variant served, if the link has any.
+    Variant string
+    // Targeted is set when the destination depends on the visitor.
+    Targeted bool
+    // Interstitial asks for the preview page to be shown instead of
+    // redirecting straight away.
+    Interstitial bool
+}
+
+// URLUpdate lists the attributes to change on a link; nil fields are left
+// untouched.
+type URLUpdate struct {
+    OriginalURL      *string
+    Status           *string
+    ExpiresAt        *time.Time
+    // ClearExpiry removes the expiry date, making the link permanent.
+    ClearExpiry      bool
+    ActiveFrom       *time.Time
+    ClearActiveFrom  bool
+    ActiveUntil      *time.Time
+    ClearActiveUntil bool
+    RedirectCode     *int
+    Preview          *bool
+    // DeviceRules replaces the device rules; an empty list removes them.
+    DeviceRules      *models.DeviceRules
+    // GeoRules replaces the geo rules; an empty list removes them.
+    GeoRules         *models.GeoRules
+    // Variants replaces the variants; an empty list removes them.
+    Variants         *models.Variants
+    StickyVariants   *bool
+    PassthroughPath  *bool
+    PassthroughQuery *bool
+    QueryConflict    *string
+}
+
 type URLService interface {
-    ShortenURL(longURL string) (*models.URL, error)
-    GetOriginalURL(shortCode string) (string, error)
-    GetTopDomains(limit int) ([]models.DomainMetric, error)
+    ShortenURL(ctx context.Context, longURL string, opts ShortenOptions) (*models.URL, error)
+    // ShortenBatch creates a link for each item, reporting errors per item.
+    ShortenBatch(ctx context.Context, items []BatchItem, opts ShortenOptions) ([]BatchResult, error)
+    GetOriginalURL(ctx context.Context, shortCode string, visit Visit) (*Redirect, error)
+    // UnlockURL redirects to a password-protected link once the password
+    // has been checked.
+    UnlockURL(ctx context.Context, shortCode, password string, visit Visit) (*Redirect, error)
+    // PreviewURL returns where a link leads without counting a visit.
+    PreviewURL(ctx context.Context, shortCode string, visit Visit) (*Redirect, error)
+    GetTopDomains(ctx context.Context, limit int, scope repository.URLScope) ([]models.DomainMetric, error)
+    ListURLs(ctx context.Context, filter repository.URLFilter) ([]models.URL, int64, error)
+    // ExportURLs writes every link of scope to w.
+    ExportURLs(ctx context.Context, w linkio.Writer, scope repository.URLScope) error
+    // ImportURLs creates links from the records of r, keeping their short
+    // codes where they are free.
+    ImportURLs(ctx context.Context, r linkio.Reader, opts ImportOptions) (*ImportReport, error)
+    // GetURL returns a live (not deleted) link without counting a visit.
+    GetURL(ctx context.Context, shortCode string) (*models.URL, error)
+    UpdateURL(ctx context.Context, link *models.URL, update URLUpdate) (*models.URL, error)
+    DeleteURL(ctx context.Context, link *models.URL) error
+    // GetVariantStats returns the clicks on each of a link's variants,
+    // including variants since removed.
+    GetVariantStats(ctx context.Context, link *models.URL) ([]models.VariantMetric, error)
+    // GetSourceStats returns the clicks on a link from each source, such
+    // as QR code scans.
+    GetSourceStats(ctx context.Context, link *models.URL) ([]models.SourceMetric, error)
+    // Fallback returns where to send browsers visiting a code that does
+    // not redirect.
+    Fallback(ctx context.Context, shortCode string) Fallback
 }
 
 type URLServiceImpl struct {
-    repo   repository.URLRepository
-    config *config.Config
+    repo           repository.URLRepository
+    clicks         repository.ClickRepository
+    templates      repository.UTMTemplateRepository
+    workspaces     repository.WorkspaceRepository
+    locator        geo.Locator
+    config         *config.Config
+    unlockAttempts *attemptLimiter
 }
 
-func NewURLService(repo repository.URLRepository, cfg *config.Config) URLService {
+// NewURLService creates the URL service. locator may be nil, in which case
+// visitors' countries are unknown and geo rules never match.
+func NewURLService(repo repository.URLRepository, clicks repository.ClickRepository, templates repository.UTMTemplateRepository, workspaces repository.WorkspaceRepository, locator geo.Locator, cfg *config.Config) URLService {
     return &URLServiceImpl{
-        repo:   repo,
-        config: cfg,
+        repo:           repo,
+        clicks:         clicks,
+        templates:      templates,
+        workspaces:     workspaces,
+        locator:        locator,
+        config:         cfg,
+        unlockAttempts: newAttemptLimiter(cfg.Password.MaxAttempts, cfg.Password.AttemptWindow),
     }
 }
 
-func (s *URLServiceImpl) ShortenURL(longURL string) (*models.URL, error) {
-    parsedURL, err := url.Parse(longURL)
+func (s *URLServiceImpl) ShortenURL(ctx context.Context, longURL string, opts ShortenOptions) (*models.URL, error) {
+    template, err := s.utmTemplate(ctx, opts)
     if err != nil {
         return nil, err
     }
-
-    domain := parsedURL.Host
-    if strings.HasPrefix(domain, "www.") {
-        domain = domain[4:]
+    url, err := newLink(longURL, opts, template)
+    if err != nil {
+        return nil, err
     }
 
     // Check if URL already exists
-    if existingURL, err := s.repo.FindByOriginalURL(longURL); err == nil {
-        return existingURL, nil
+    if !opts.hasLinkSettings() {
+        if existingURL, err := s.repo.FindByOriginalURL(ctx, url.OriginalURL, opts.scope()); err == nil && existingURL.Plain() {
+            return existingURL, nil
+        }
+    }
+
+    if opts.Alias != "" {
+        _, err := s.repo.FindByShortCode(ctx, opts.Alias)
+        if err == nil {
+            return nil, ErrAliasTaken
+        }
+        if !errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, storageError(err)
+        }
+    } else if url.ShortCode, err = s.freeShortCode(ctx); err != nil {
+        return nil, err
+    }
+
+    if url.PasswordHash, err = hashPassword(opts.Password); err != nil {
+        return nil, err
+    }
+
+    // The code may be taken between the check and the insert: a lost alias
+    // is reported as taken, a lost generated code replaced
+    for attempt := 1; ; attempt++ {
+        err := s.repo.Create(ctx, url)
+        if err == nil {
+            return url, nil
+        }
+        if !errors.Is(err, gorm.ErrDuplicatedKey) || attempt == insertAttempts {
+            return nil, storageError(err)
+        }
+        if opts.Alias != "" {
+            return nil, ErrAliasTaken
+        }
+        if url.ShortCode, err = s.freeShortCode(ctx); err != nil {
+            return nil, err
+        }
     }
+}
 
-    // Generate new short code
-    shortCode := utils.GenerateShortCode(s.config.ShortURL.Length)
+// freeShortCode generates short codes until it finds one not in use.
+func (s *URLServiceImpl) freeShortCode(ctx context.Context) (string, error) {
     for {
-        if _, err := s.repo.FindByShortCode(shortCode); err != nil {
-            break
+        code := utils.GenerateShortCode(s.config.ShortURL.Length)
+        _, err := s.repo.FindByShortCode(ctx, code)
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return code, nil
+        }
+        if err != nil {
+            return "", storageError(err)
         }
-        shortCode = utils.GenerateShortCode(s.config.ShortURL.Length)
+    }
+}
+
+// utmTemplate loads the UTM template named in opts, if any.
+func (s *URLServiceImpl) utmTemplate(ctx context.Context, opts ShortenOptions) (*models.UTMTemplate, error) {
+    if opts.UTMTemplate == "" {
+        return nil, nil
+    }
+    if opts.WorkspaceID == nil {
+        return nil, ErrUTMTemplateNotFound
+    }
+    template, err := s.templates.FindByName(ctx, *opts.WorkspaceID, opts.UTMTemplate)
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, ErrUTMTemplateNotFound
+    }
+    if err != nil {
+        return nil, storageError(err)
+    }
+    return template, nil
+}
+
+// newLink validates a destination and the options of a new link and builds
+// it, with the alias as its code if there is one. The password is left for
+// the caller to hash.
+func newLink(longURL string, opts ShortenOptions, template *models.UTMTemplate) (*models.URL, error) {
+    parsedURL, err := url.Parse(longURL)
+    if err != nil || !validDestination(longURL) {
+        return nil, ErrInvalidURL
+    }
+
+    domain := domainOf(parsedURL)
+
+    // A tagged URL is a destination of its own, for deduplication too
+    if template != nil {
+        tagURL(parsedURL, template)
+        longURL = parsedURL.String()
+    }
+
+    if opts.Alias != "" && !validAlias(opts.Alias) {
+        return nil, ErrInvalidAlias
+    }
+    if opts.RedirectCode != 0 && !validRedirectCode(opts.RedirectCode) {
+        return nil, ErrInvalidRedirectCode
+    }
+    if opts.MaxClicks != nil && *opts.MaxClicks < 1 {
+        return nil, ErrInvalidMaxClicks
+    }
+    if err := validateDeviceRules(opts.DeviceRules); err != nil {
+        return nil, err
+    }
+    geoRules, err := normalizeGeoRules(opts.GeoRules)
+    if err != nil {
+        return nil, err
+    }
+    if err := validateVariants(opts.Variants); err != nil {
+        return nil, err
+    }
+    if !validQueryConflict(opts.QueryConflict) {
+        return nil, ErrInvalidConflictRule
+    }
+    if !validActiveWindow(opts.ActiveFrom, opts.ActiveUntil) {
+        return nil, ErrInvalidActiveWindow
+    }
+
+    return &models.URL{
+        OriginalURL:      longURL,
+        ShortCode:        opts.Alias,
+        Domain:           domain,
+        OwnerID:          opts.OwnerID,
+        WorkspaceID:      opts.WorkspaceID,
+        ExpiresAt:        opts.ExpiresAt,
+        RedirectCode:     opts.RedirectCode,
+        MaxClicks:        opts.MaxClicks,
+        Preview:          opts.Preview,
+        DeviceRules:      opts.DeviceRules,
+        GeoRules:         geoRules,
+        Variants:         opts.Variants,
+        StickyVariants:   opts.StickyVariants,
+        PassthroughPath:  opts.PassthroughPath,
+        PassthroughQuery: opts.PassthroughQuery,
+        QueryConflict:    opts.QueryConflict,
+        ActiveFrom:       opts.ActiveFrom,
+        ActiveUntil:      opts.ActiveUntil,
+    }, nil
+}
+
+func hashPassword(password string) (string, error) {
+    if password == "" {
+        return "", nil
+    }
+    hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+    return string(hash), err
+}
+
+func (s *URLServiceImpl) GetOriginalURL(ctx context.Context, shortCode string, visit Visit) (*Redirect, error) {
+    url, err := s.resolve(ctx, shortCode)
+    if err != nil {
+        return nil, err
+    }
+
+    if url.PasswordHash != "" {
+        return nil, ErrPasswordRequired
+    }
+
+    return s.visit(ctx, url, visit)
+}
+
+// UnlockURL redirects to a password-protected link. Wrong passwords are
+// limited per code, however many addresses they come from; while a code is
+// being guessed at, visitors who know the password are locked out too.
+func (s *URLServiceImpl) UnlockURL(ctx context.Context, shortCode, password string, visit Visit) (*Redirect, error) {
+    now := time.Now()
+    attempts := shortCode
+    if ok, retryAfter := s.unlockAttempts.Reserve(attempts, now); !ok {
+        return nil, &TooManyAttemptsError{RetryAfter: retryAfter}
+    }
+
+    url, err := s.resolve(ctx, shortCode)
+    if err != nil {
+        s.unlockAttempts.Release(attempts, now)
+        return nil, err
     }
 
-    url := &models.URL{
-        OriginalURL: longURL,
-        ShortCode:   shortCode,
-        Domain:      domain,
+    if url.PasswordHash != "" {
+        if err := bcrypt.CompareHashAndPassword([]byte(url.PasswordHash), []byte(password)); err != nil {
+            // The reserved attempt stays counted as a failure
+            return nil, ErrWrongPassword
+        }
     }
+    s.unlockAttempts.Release(attempts, now)
+
+    return s.visit(ctx, url, visit)
+}
 
-    if err := s.repo.Create(url); err != nil {
+func (s *URLServiceImpl) PreviewURL(ctx context.Context, shortCode string, visit Visit) (*Redirect, error) {
+    url, err := s.resolve(ctx, shortCode)
+    if err != nil {
         return nil, err
     }
 
+    // The destination is what the password protects
+    if url.PasswordHash != "" {
+        return nil, ErrPasswordRequired
+    }
+
+    return s.redirectTo(url, visit, s.identify(visit)), nil
+}
+
+// resolve looks up a link and checks that it may currently redirect.
+func (s *URLServiceImpl) resolve(ctx context.Context, shortCode string) (*models.URL, error) {
+    url, err := s.repo.FindByShortCode(ctx, shortCode)
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, ErrURLNotFound
+    }
+    if err != nil {
+        return nil, storageError(err)
+    }
+
+    if err := checkRedirectable(url, time.Now()); err != nil {
+        return nil, err
+    }
     return url, nil
 }
 
-func (s *URLServiceImpl) GetOriginalURL(shortCode string) (string, error) {
-    url, err := s.repo.FindByShortCode(shortCode)
+// visit counts a visit to the link and returns where to send the visitor.
+func (s *URLServiceImpl) visit(ctx context.Context, url *models.URL, visit Visit) (*Redirect, error) {
+    err := s.repo.IncrementAccessCount(ctx, url)
+    if errors.Is(err, repository.ErrClickLimitReached) {
+        return nil, ErrURLExhausted
+    }
     if err != nil {
-        return "", err
+        // A limited link must not redirect unless the click was counted
+        if url.MaxClicks != nil {
+            return nil, storageError(err)
+        }
+        // Log error but don't fail the request
+        slog.ErrorContext(ctx, "Failed to increment access count", "short_code", url.ShortCode, "error", err)
     }
 
-    if err := s.repo.IncrementAccessCount(url); err != nil {
+    who := s.identify(visit)
+    redirect := s.redirectTo(url, visit, who)
+    click := &models.ClickEvent{
+        URLID:    url.ID,
+        Country:  who.country,
+        Platform: who.device.OS,
+        Variant:  redirect.Variant,
+        Source:   visit.Source,
+    }
+    if err := s.clicks.Create(ctx, click); err != nil {
         // Log error but don't fail the request
-        // logger.Error("Failed to increment access count", err)
+        slog.ErrorContext(ctx, "Failed to record click", "short_code", url.ShortCode, "error", err)
+    }
+
+    return redirect, nil
+}
+
+// identify works out the visitor's device and, if a GeoIP database is
+// loaded, their country.
+func (s *URLServiceImpl) identify(visit Visit) visitor {
+    who := visitor{
+        device:    utils.ParseUserAgent(visit.Header.Get("User-Agent")),
+        variant:   visit.Variant,
+        previewed: visit.Previewed,
+    }
+    if ip := net.ParseIP(visit.ClientIP); ip != nil && s.locator != nil {
+        // An address missing from the database just leaves the country
+        // unknown
+        who.country, _ = s.locator.Country(ip)
+    }
+    return who
+}
+
+func (s *URLServiceImpl) redirectTo(url *models.URL, visit Visit, who visitor) *Redirect {
+    location, variant := destination(url, who)
+    return &Redirect{
+        Location:     passThrough(url, location, visit),
+        StatusCode:   s.redirectCode(url),
+        Link:         url,
+        Variant:      variant,
+        Targeted:     len(url.DeviceRules) > 0 || len(url.GeoRules) > 0 || len(url.Variants) > 0,
+        Interstitial: url.Preview || s.config.Redirect.Interstitial,
+    }
+}
+
+func (s *URLServiceImpl) redirectCode(url *models.URL) int {
+    if url.RedirectCode != 0 {
+        return url.RedirectCode
+    }
+    return s.config.Redirect.StatusCode
+}
+
+func (s *URLServiceImpl) GetTopDomains(ctx context.Context, limit int, scope repository.URLScope) ([]models.DomainMetric, error) {
+    metrics, err := s.repo.GetTopDomains(ctx, limit, scope)
+    return metrics, storageError(err)
+}
+
+func (s *URLServiceImpl) ListURLs(ctx context.Context, filter repository.URLFilter) ([]models.URL, int64, error) {
+    urls, total, err := s.repo.List(ctx, filter)
+    return urls, total, storageError(err)
+}
+
+func (s *URLServiceImpl) GetURL(ctx context.Context, shortCode string) (*models.URL, error) {
+    url, err := s.repo.FindByShortCode(ctx, shortCode)
+    if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && url.DeletedAt.Valid) {
+        return nil, ErrURLNotFound
+    }
+    if err != nil {
+        return nil, storageError(err)
+    }
+    return url, nil
+}
+
+func (s *URLServiceImpl) UpdateURL(ctx context.Context, link *models.URL, update URLUpdate) (*models.URL, error) {
+    updated := *link
+
+    if update.OriginalURL != nil {
+        parsedURL, err := url.Parse(*update.OriginalURL)
+        if err != nil || !validDestination(*update.OriginalURL) {
+            return nil, ErrInvalidURL
+        }
+        updated.OriginalURL = *update.OriginalURL
+        updated.Domain = domainOf(parsedURL)
+    }
+
+    if update.Status != nil {
+        if !validStatus(*update.Status) {
+            return nil, ErrInvalidStatus
+        }
+        updated.Status = *update.Status
+    }
+
+    if update.RedirectCode != nil {
+        if *update.RedirectCode != 0 && !validRedirectCode(*update.RedirectCode) {
+            return nil, ErrInvalidRedirectCode
+        }
+        updated.RedirectCode = *update.RedirectCode
+    }
+
+    if update.DeviceRules != nil {
+        if err := validateDeviceRules(*update.DeviceRules); err != nil {
+            return nil, err
+        }
+        updated.DeviceRules = *update.DeviceRules
+    }
+
+    if update.GeoRules != nil {
+        geoRules, err := normalizeGeoRules(*update.GeoRules)
+        if err != nil {
+            return nil, err
+        }
+        updated.GeoRules = geoRules
+    }
+
+    if update.Variants != nil {
+        if err := validateVariants(*update.Variants); err != nil {
+            return nil, err
+        }
+        updated.Variants = *update.Variants
+    }
+
+    if update.StickyVariants != nil {
+        updated.StickyVariants = *update.StickyVariants
+    }
+
+    if update.PassthroughPath != nil {
+        updated.PassthroughPath = *update.PassthroughPath
+    }
+    if update.PassthroughQuery != nil {
+        updated.PassthroughQuery = *update.PassthroughQuery
+    }
+    if update.QueryConflict != nil {
+        if !validQueryConflict(*update.QueryConflict) {
+            return nil, ErrInvalidConflictRule
+        }
+        updated.QueryConflict = *update.QueryConflict
+    }
+
+    if update.Preview != nil {
+        updated.Preview = *update.Preview
+    }
+
+    if update.ClearExpiry {
+        updated.ExpiresAt = nil
+    } else if update.ExpiresAt != nil {
+        updated.ExpiresAt = update.ExpiresAt
+    }
+
+    if update.ClearActiveFrom {
+        updated.ActiveFrom = nil
+    } else if update.ActiveFrom != nil {
+        updated.ActiveFrom = update.ActiveFrom
+    }
+    if update.ClearActiveUntil {
+        updated.ActiveUntil = nil
+    } else if update.ActiveUntil != nil {
+        updated.ActiveUntil = update.ActiveUntil
+    }
+    if !validActiveWindow(updated.ActiveFrom, updated.ActiveUntil) {
+        return nil, ErrInvalidActiveWindow
+    }
+
+    if err := s.repo.Update(ctx, &updated); err != nil {
+        return nil, storageError(err)
+    }
+    return &updated, nil
+}
+
+func (s *URLServiceImpl) DeleteURL(ctx context.Context, link *models.URL) error {
+    return storageError(s.repo.Delete(ctx, link))
+}
+
+func (s *URLServiceImpl) GetVariantStats(ctx context.Context, link *models.URL) ([]models.VariantMetric, error) {
+    counts, err := s.clicks.CountByVariant(ctx, link.ID)
+    if err != nil {
+        return nil, storageError(err)
+    }
+
+    clicks := make(map[string]int64, len(counts))
+    for _, count := range counts {
+        clicks[count.Variant] = count.Clicks
+    }
+
+    // Current variants first, in their configured order, then any that
+    // were served before being removed
+    stats := make([]models.VariantMetric, 0, len(link.Variants))
+    for _, variant := range link.Variants {
+        stats = append(stats, models.VariantMetric{Variant: variant.Name, Clicks: clicks[variant.Name]})
+        delete(clicks, variant.Name)
+    }
+    for _, count := range counts {
+        if _, removed := clicks[count.Variant]; removed && count.Variant != "" {
+            stats = append(stats, count)
+        }
+    }
+    return stats, nil
+}
+
+func (s *URLServiceImpl) GetSourceStats(ctx context.Context, link *models.URL) ([]models.SourceMetric, error) {
+    stats, err := s.clicks.CountBySource(ctx, link.ID)
+    if err != nil {
+        return nil, storageError(err)
+    }
+    if stats == nil {
+        stats = []models.SourceMetric{}
+    }
+    for i := range stats {
+        if stats[i].Source == "" {
+            stats[i].Source = models.ClickSourceDirect
+        }
+    }
+    return stats, nil
+}
+
+// checkRedirectable reports why a link must not redirect at the given time.
+func checkRedirectable(url *models.URL, now time.Time) error {
+    switch {
+    case url.DeletedAt.Valid:
+        return ErrURLDeleted
+    case url.Status == models.URLStatusDisabled:
+        return ErrURLDisabled
+    case url.Status == models.URLStatusPaused:
+        return ErrURLPaused
+    case url.ExpiresAt != nil && !now.Before(*url.ExpiresAt):
+        return ErrURLExpired
+    case url.ActiveFrom != nil && now.Before(*url.ActiveFrom):
+        return &NotActiveError{ActiveFrom: *url.ActiveFrom}
+    case url.ActiveUntil != nil && !now.Before(*url.ActiveUntil):
+        return ErrURLEnded
+    case url.MaxClicks != nil && url.AccessCount >= *url.MaxClicks:
+        return ErrURLExhausted
+    }
+    return nil
+}
+
+const (
+    minAliasLength = 3
+    maxAliasLength = 32
+)
+
+// reservedAliases would clash with the routes of the service itself.
+var reservedAliases = map[string]bool{"api": true}
+
+func validAlias(alias string) bool {
+    if len(alias) < minAliasLength || len(alias) > maxAliasLength || reservedAliases[strings.ToLower(alias)] {
+        return false
+    }
+    for _, r := range alias {
+        if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+            return false
+        }
+    }
+    return true
+}
+
+func validStatus(status string) bool {
+    switch status {
+    case models.URLStatusActive, models.URLStatusPaused, models.URLStatusDisabled:
+        return true
     }
+    return false
+}
 
-    return url.OriginalURL, nil
+func validActiveWindow(from, until *time.Time) bool {
+    return from == nil || until == nil || until.After(*from)
+}
+
+func validRedirectCode(code int) bool {
+    switch code {
+    case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+        return true
+    }
+    return false
 }
 
-func (s *URLServiceImpl) GetTopDomains(limit int) ([]models.DomainMetric, error) {
-    return s.repo.GetTopDomains(limit)
+func domainOf(parsedURL *url.URL) string {
+    return strings.TrimPrefix(parsedURL.Host, "www.")
 }
\ No newline at end of file
@@ -1,25 +1,53 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 	"urlshortner/config"
+	"urlshortner/linkio"
 	"urlshortner/models"
+	"urlshortner/repository"
 )
 
 type MockURLRepository struct {
 	mock.Mock
 }
 
-func (m *MockURLRepository) Create(url *models.URL) error {
+func (m *MockURLRepository) Create(ctx context.Context, url *models.URL) error {
 	args := m.Called(url)
 	return args.Error(0)
 }
 
-func (m *MockURLRepository) FindByShortCode(shortCode string) (*models.URL, error) {
+func (m *MockURLRepository) CreateBatch(ctx context.Context, urls []*models.URL) error {
+	args := m.Called(urls)
+	return args.Error(0)
+}
+
+func (m *MockURLRepository) TakenShortCodes(ctx context.Context, shortCodes []string) ([]string, error) {
+	args := m.Called(shortCodes)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockURLRepository) FindByOriginalURLs(ctx context.Context, originalURLs []string, scope repository.URLScope) ([]models.URL, error) {
+	args := m.Called(originalURLs, scope)
+	return args.Get(0).([]models.URL), args.Error(1)
+}
+
+func (m *MockURLRepository) FindByShortCode(ctx context.Context, shortCode string) (*models.URL, error) {
 	args := m.Called(shortCode)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -27,37 +55,103 @@ func (m *MockURLRepository) FindByShortCode(shortCode string) (*models.URL, erro
 	return args.Get(0).(*models.URL), args.Error(1)
 }
 
-func (m *MockURLRepository) FindByOriginalURL(originalURL string) (*models.URL, error) {
-	args := m.Called(originalURL)
+func (m *MockURLRepository) FindByOriginalURL(ctx context.Context, originalURL string, scope repository.URLScope) (*models.URL, error) {
+	args := m.Called(originalURL, scope)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*models.URL), args.Error(1)
 }
 
-func (m *MockURLRepository) IncrementAccessCount(url *models.URL) error {
+func (m *MockURLRepository) IncrementAccessCount(ctx context.Context, url *models.URL) error {
 	args := m.Called(url)
 	return args.Error(0)
 }
 
-func (m *MockURLRepository) GetTopDomains(limit int) ([]models.DomainMetric, error) {
-	args := m.Called(limit)
+func (m *MockURLRepository) GetTopDomains(ctx context.Context, limit int, scope repository.URLScope) ([]models.DomainMetric, error) {
+	args := m.Called(limit, scope)
 	return args.Get(0).([]models.DomainMetric), args.Error(1)
 }
 
+func (m *MockURLRepository) List(ctx context.Context, filter repository.URLFilter) ([]models.URL, int64, error) {
+	args := m.Called(filter)
+	return args.Get(0).([]models.URL), args.Get(1).(int64), args.Error(2)
+}
+
+func (m *MockURLRepository) Each(ctx context.Context, scope repository.URLScope, fn func(urls []models.URL) error) error {
+	args := m.Called(scope, fn)
+	for _, batch := range args.Get(0).([][]models.URL) {
+		if err := fn(batch); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
+func (m *MockURLRepository) Update(ctx context.Context, url *models.URL) error {
+	args := m.Called(url)
+	return args.Error(0)
+}
+
+func (m *MockURLRepository) Delete(ctx context.Context, url *models.URL) error {
+	args := m.Called(url)
+	return args.Error(0)
+}
+
+type MockClickRepository struct {
+	mock.Mock
+}
+
+func (m *MockClickRepository) Create(ctx context.Context, click *models.ClickEvent) error {
+	args := m.Called(click)
+	return args.Error(0)
+}
+
+func (m *MockClickRepository) CountByVariant(ctx context.Context, urlID uint) ([]models.VariantMetric, error) {
+	args := m.Called(urlID)
+	return args.Get(0).([]models.VariantMetric), args.Error(1)
+}
+
+func (m *MockClickRepository) CountBySource(ctx context.Context, urlID uint) ([]models.SourceMetric, error) {
+	args := m.Called(urlID)
+	return args.Get(0).([]models.SourceMetric), args.Error(1)
+}
+
+// stubLocator maps IP addresses to countries.
+type stubLocator map[string]string
+
+func (l stubLocator) Country(ip net.IP) (string, error) {
+	return l[ip.String()], nil
+}
+
 func setupTestService() (*URLServiceImpl, *MockURLRepository) {
+	service, mockRepo, _ := setupTestServiceWithWorkspaces()
+	return service, mockRepo
+}
+
+func setupTestServiceWithWorkspaces() (*URLServiceImpl, *MockURLRepository, *MockWorkspaceRepository) {
 	mockRepo := new(MockURLRepository)
 	cfg := &config.Config{}
 	cfg.ShortURL.Length = 6
 	cfg.ShortURL.BaseURL = "http://localhost:8080"
-	service := NewURLService(mockRepo, cfg).(*URLServiceImpl)
-	return service, mockRepo
+	cfg.Redirect.StatusCode = 302
+	cfg.Password.MaxAttempts = 3
+	cfg.Password.AttemptWindow = time.Minute
+	clicks := new(MockClickRepository)
+	clicks.On("Create", mock.Anything).Return(nil).Maybe()
+	workspaces := new(MockWorkspaceRepository)
+	service := NewURLService(mockRepo, clicks, new(MockUTMTemplateRepository), workspaces, nil, cfg).(*URLServiceImpl)
+	return service, mockRepo, workspaces
 }
 
 func TestShortenURL(t *testing.T) {
+	ownerID := uint(7)
+	workspaceID := uint(3)
+
 	tests := []struct {
 		name        string
 		url         string
+		opts        ShortenOptions
 		setupMock   func(*MockURLRepository)
 		expectError bool
 		expectURL   *models.URL
@@ -66,7 +160,7 @@ func TestShortenURL(t *testing.T) {
 			name: "Successfully shorten new URL",
 			url:  "https://example.com/page",
 			setupMock: func(m *MockURLRepository) {
-				m.On("FindByOriginalURL", "https://example.com/page").Return(nil, gorm.ErrRecordNotFound)
+				m.On("FindByOriginalURL", "https://example.com/page", repository.URLScope{}).Return(nil, gorm.ErrRecordNotFound)
 				m.On("FindByShortCode", mock.Anything).Return(nil, gorm.ErrRecordNotFound)
 				m.On("Create", mock.Anything).Return(nil)
 			},
@@ -80,21 +174,159 @@ func TestShortenURL(t *testing.T) {
 					OriginalURL: "https://example.com/page",
 					ShortCode:   "abc123",
 					Domain:      "example.com",
+					Status:      models.URLStatusActive,
 				}
-				m.On("FindByOriginalURL", "https://example.com/page").Return(existingURL, nil)
+				m.On("FindByOriginalURL", "https://example.com/page", repository.URLScope{}).Return(existingURL, nil)
 			},
 			expectError: false,
 			expectURL: &models.URL{
 				OriginalURL: "https://example.com/page",
 				ShortCode:   "abc123",
 				Domain:      "example.com",
+				Status:      models.URLStatusActive,
+			},
+		},
+		{
+			name: "Password-protected link is not handed out",
+			url:  "https://example.com/page",
+			setupMock: func(m *MockURLRepository) {
+				m.On("FindByOriginalURL", "https://example.com/page", repository.URLScope{}).Return(&models.URL{
+					OriginalURL: "https://example.com/page", ShortCode: "locked", Status: models.URLStatusActive, PasswordHash: "$2a$10$hash",
+				}, nil)
+				m.On("FindByShortCode", mock.Anything).Return(nil, gorm.ErrRecordNotFound)
+				m.On("Create", mock.MatchedBy(func(url *models.URL) bool { return url.ShortCode != "locked" })).Return(nil)
+			},
+		},
+		{
+			name: "Used-up link is not handed out",
+			url:  "https://example.com/page",
+			setupMock: func(m *MockURLRepository) {
+				maxClicks := 1
+				m.On("FindByOriginalURL", "https://example.com/page", repository.URLScope{}).Return(&models.URL{
+					OriginalURL: "https://example.com/page", ShortCode: "once12", Status: models.URLStatusActive, MaxClicks: &maxClicks, AccessCount: 1,
+				}, nil)
+				m.On("FindByShortCode", mock.Anything).Return(nil, gorm.ErrRecordNotFound)
+				m.On("Create", mock.MatchedBy(func(url *models.URL) bool { return url.ShortCode != "once12" && url.MaxClicks == nil })).Return(nil)
+			},
+		},
+		{
+			name: "Owned link is not deduplicated against other owners",
+			url:  "https://example.com/page",
+			opts: ShortenOptions{OwnerID: &ownerID},
+			setupMock: func(m *MockURLRepository) {
+				m.On("FindByOriginalURL", "https://example.com/page", repository.URLScope{OwnerID: &ownerID}).Return(nil, gorm.ErrRecordNotFound)
+				m.On("FindByShortCode", mock.Anything).Return(nil, gorm.ErrRecordNotFound)
+				m.On("Create", mock.MatchedBy(func(url *models.URL) bool {
+					return url.OwnerID != nil && *url.OwnerID == ownerID
+				})).Return(nil)
+			},
+			expectError: false,
+		},
+		{
+			name: "Workspace link is deduplicated within the workspace",
+			url:  "https://example.com/page",
+			opts: ShortenOptions{OwnerID: &ownerID, WorkspaceID: &workspaceID},
+			setupMock: func(m *MockURLRepository) {
+				m.On("FindByOriginalURL", "https://example.com/page", repository.URLScope{WorkspaceID: &workspaceID}).Return(nil, gorm.ErrRecordNotFound)
+				m.On("FindByShortCode", mock.Anything).Return(nil, gorm.ErrRecordNotFound)
+				m.On("Create", mock.MatchedBy(func(url *models.URL) bool {
+					return url.WorkspaceID != nil && *url.WorkspaceID == workspaceID
+				})).Return(nil)
+			},
+			expectError: false,
+		},
+		{
+			name: "Link with its own redirect code is never deduplicated",
+			url:  "https://example.com/page",
+			opts: ShortenOptions{RedirectCode: 301},
+			setupMock: func(m *MockURLRepository) {
+				m.On("FindByShortCode", mock.Anything).Return(nil, gorm.ErrRecordNotFound)
+				m.On("Create", mock.MatchedBy(func(url *models.URL) bool {
+					return url.RedirectCode == 301
+				})).Return(nil)
+			},
+			expectError: false,
+		},
+		{
+			name: "Password is stored hashed",
+			url:  "https://example.com/page",
+			opts: ShortenOptions{Password: "s3cret"},
+			setupMock: func(m *MockURLRepository) {
+				m.On("FindByShortCode", mock.Anything).Return(nil, gorm.ErrRecordNotFound)
+				m.On("Create", mock.MatchedBy(func(url *models.URL) bool {
+					return url.PasswordHash != "s3cret" &&
+						bcrypt.CompareHashAndPassword([]byte(url.PasswordHash), []byte("s3cret")) == nil
+				})).Return(nil)
+			},
+			expectError: false,
+		},
+		{
+			name: "Alias becomes the code",
+			url:  "https://example.com/page",
+			opts: ShortenOptions{Alias: "spring-sale"},
+			setupMock: func(m *MockURLRepository) {
+				m.On("FindByShortCode", "spring-sale").Return(nil, gorm.ErrRecordNotFound)
+				m.On("Create", mock.MatchedBy(func(url *models.URL) bool {
+					return url.ShortCode == "spring-sale"
+				})).Return(nil)
 			},
+			expectURL: &models.URL{OriginalURL: "https://example.com/page", ShortCode: "spring-sale"},
+		},
+		{
+			name: "Alias already taken",
+			url:  "https://example.com/page",
+			opts: ShortenOptions{Alias: "spring-sale"},
+			setupMock: func(m *MockURLRepository) {
+				m.On("FindByShortCode", "spring-sale").Return(&models.URL{ShortCode: "spring-sale"}, nil)
+			},
+			expectError: true,
+		},
+		{
+			name:        "Alias with a reserved name",
+			url:         "https://example.com/page",
+			opts:        ShortenOptions{Alias: "API"},
+			setupMock:   func(m *MockURLRepository) {},
+			expectError: true,
+		},
+		{
+			name:        "Not a web URL",
+			url:         "ftp://example.com/file",
+			setupMock:   func(m *MockURLRepository) {},
+			expectError: true,
+		},
+		{
+			name:        "Non-positive click limit",
+			url:         "https://example.com/page",
+			opts:        ShortenOptions{MaxClicks: new(int)},
+			setupMock:   func(m *MockURLRepository) {},
+			expectError: true,
+		},
+		{
+			name:        "Device rule for an unknown platform",
+			url:         "https://example.com/page",
+			opts:        ShortenOptions{DeviceRules: models.DeviceRules{{Platform: "amiga", URL: "https://example.com/amiga"}}},
+			setupMock:   func(m *MockURLRepository) {},
+			expectError: true,
+		},
+		{
+			name:        "Device rule without a web destination",
+			url:         "https://example.com/page",
+			opts:        ShortenOptions{DeviceRules: models.DeviceRules{{Platform: "ios", URL: "javascript:alert(1)"}}},
+			setupMock:   func(m *MockURLRepository) {},
+			expectError: true,
+		},
+		{
+			name:        "Unsupported redirect code",
+			url:         "https://example.com/page",
+			opts:        ShortenOptions{RedirectCode: 303},
+			setupMock:   func(m *MockURLRepository) {},
+			expectError: true,
 		},
 		{
 			name: "Database error on create",
 			url:  "https://example.com/page",
 			setupMock: func(m *MockURLRepository) {
-				m.On("FindByOriginalURL", "https://example.com/page").Return(nil, gorm.ErrRecordNotFound)
+				m.On("FindByOriginalURL", "https://example.com/page", repository.URLScope{}).Return(nil, gorm.ErrRecordNotFound)
 				m.On("FindByShortCode", mock.Anything).Return(nil, gorm.ErrRecordNotFound)
 				m.On("Create", mock.Anything).Return(errors.New("database error"))
 			},
@@ -107,7 +339,7 @@ func TestShortenURL(t *testing.T) {
 			service, mockRepo := setupTestService()
 			tt.setupMock(mockRepo)
 
-			url, err := service.ShortenURL(tt.url)
+			url, err := service.ShortenURL(context.Background(), tt.url, tt.opts)
 
 			if tt.expectError {
 				assert.Error(t, err)
@@ -124,13 +356,57 @@ func TestShortenURL(t *testing.T) {
 	}
 }
 
+func TestShortenURLLosesRace(t *testing.T) {
+	t.Run("Alias taken meanwhile is reported as taken", func(t *testing.T) {
+		service, mockRepo := setupTestService()
+		mockRepo.On("FindByShortCode", "spring-sale").Return(nil, gorm.ErrRecordNotFound)
+		mockRepo.On("Create", mock.Anything).Return(gorm.ErrDuplicatedKey).Once()
+
+		_, err := service.ShortenURL(context.Background(), "https://example.com/page", ShortenOptions{Alias: "spring-sale"})
+
+		assert.ErrorIs(t, err, ErrAliasTaken)
+		mockRepo.AssertNumberOfCalls(t, "Create", 1)
+	})
+
+	t.Run("Generated code taken meanwhile is replaced", func(t *testing.T) {
+		service, mockRepo := setupTestService()
+		mockRepo.On("FindByOriginalURL", mock.Anything, mock.Anything).Return(nil, gorm.ErrRecordNotFound)
+		mockRepo.On("FindByShortCode", mock.Anything).Return(nil, gorm.ErrRecordNotFound)
+		var lost string
+		mockRepo.On("Create", mock.Anything).Run(func(args mock.Arguments) {
+			lost = args.Get(0).(*models.URL).ShortCode
+		}).Return(gorm.ErrDuplicatedKey).Once()
+		mockRepo.On("Create", mock.Anything).Return(nil).Once()
+
+		url, err := service.ShortenURL(context.Background(), "https://example.com/page", ShortenOptions{})
+
+		require.NoError(t, err)
+		assert.NotEqual(t, lost, url.ShortCode)
+		mockRepo.AssertNumberOfCalls(t, "Create", 2)
+	})
+
+	t.Run("Gives up after repeated losses", func(t *testing.T) {
+		service, mockRepo := setupTestService()
+		mockRepo.On("FindByOriginalURL", mock.Anything, mock.Anything).Return(nil, gorm.ErrRecordNotFound)
+		mockRepo.On("FindByShortCode", mock.Anything).Return(nil, gorm.ErrRecordNotFound)
+		mockRepo.On("Create", mock.Anything).Return(gorm.ErrDuplicatedKey)
+
+		_, err := service.ShortenURL(context.Background(), "https://example.com/page", ShortenOptions{})
+
+		assert.ErrorIs(t, err, ErrStorage)
+		mockRepo.AssertNumberOfCalls(t, "Create", insertAttempts)
+	})
+}
+
 func TestGetOriginalURL(t *testing.T) {
 	tests := []struct {
 		name        string
 		shortCode   string
 		setupMock   func(*MockURLRepository)
 		expectURL   string
+		expectCode  int
 		expectError bool
+		expectErrIs error
 	}{
 		{
 			name:      "Successfully get original URL",
@@ -144,6 +420,23 @@ func TestGetOriginalURL(t *testing.T) {
 				m.On("IncrementAccessCount", url).Return(nil)
 			},
 			expectURL:   "https://example.com/page",
+			expectCode:  302,
+			expectError: false,
+		},
+		{
+			name:      "Per-link redirect code",
+			shortCode: "abc123",
+			setupMock: func(m *MockURLRepository) {
+				url := &models.URL{
+					OriginalURL:  "https://example.com/page",
+					ShortCode:    "abc123",
+					RedirectCode: 308,
+				}
+				m.On("FindByShortCode", "abc123").Return(url, nil)
+				m.On("IncrementAccessCount", url).Return(nil)
+			},
+			expectURL:   "https://example.com/page",
+			expectCode:  308,
 			expectError: false,
 		},
 		{
@@ -153,6 +446,7 @@ func TestGetOriginalURL(t *testing.T) {
 				m.On("FindByShortCode", "notfound").Return(nil, gorm.ErrRecordNotFound)
 			},
 			expectError: true,
+			expectErrIs: ErrURLNotFound,
 		},
 		{
 			name:      "Database error",
@@ -161,6 +455,123 @@ func TestGetOriginalURL(t *testing.T) {
 				m.On("FindByShortCode", "abc123").Return(nil, errors.New("database error"))
 			},
 			expectError: true,
+			expectErrIs: ErrStorage,
+		},
+		{
+			name:      "Deleted link",
+			shortCode: "abc123",
+			setupMock: func(m *MockURLRepository) {
+				m.On("FindByShortCode", "abc123").Return(&models.URL{
+					ShortCode: "abc123",
+					DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true},
+				}, nil)
+			},
+			expectError: true,
+			expectErrIs: ErrURLDeleted,
+		},
+		{
+			name:      "Expired link",
+			shortCode: "abc123",
+			setupMock: func(m *MockURLRepository) {
+				expired := time.Now().Add(-time.Hour)
+				m.On("FindByShortCode", "abc123").Return(&models.URL{ShortCode: "abc123", ExpiresAt: &expired}, nil)
+			},
+			expectError: true,
+			expectErrIs: ErrURLExpired,
+		},
+		{
+			name:      "Last click already taken by a concurrent visit",
+			shortCode: "abc123",
+			setupMock: func(m *MockURLRepository) {
+				maxClicks := 1
+				url := &models.URL{OriginalURL: "https://example.com/invite", ShortCode: "abc123", MaxClicks: &maxClicks}
+				m.On("FindByShortCode", "abc123").Return(url, nil)
+				m.On("IncrementAccessCount", url).Return(repository.ErrClickLimitReached)
+			},
+			expectError: true,
+			expectErrIs: ErrURLExhausted,
+		},
+		{
+			name:      "Click limit already reached",
+			shortCode: "abc123",
+			setupMock: func(m *MockURLRepository) {
+				maxClicks := 1
+				m.On("FindByShortCode", "abc123").Return(&models.URL{ShortCode: "abc123", MaxClicks: &maxClicks, AccessCount: 1}, nil)
+			},
+			expectError: true,
+			expectErrIs: ErrURLExhausted,
+		},
+		{
+			name:      "Limited link fails closed when the click cannot be counted",
+			shortCode: "abc123",
+			setupMock: func(m *MockURLRepository) {
+				maxClicks := 5
+				url := &models.URL{OriginalURL: "https://example.com/invite", ShortCode: "abc123", MaxClicks: &maxClicks}
+				m.On("FindByShortCode", "abc123").Return(url, nil)
+				m.On("IncrementAccessCount", url).Return(errors.New("database error"))
+			},
+			expectError: true,
+		},
+		{
+			name:      "Unlimited link redirects even when the click cannot be counted",
+			shortCode: "abc123",
+			setupMock: func(m *MockURLRepository) {
+				url := &models.URL{OriginalURL: "https://example.com/page", ShortCode: "abc123"}
+				m.On("FindByShortCode", "abc123").Return(url, nil)
+				m.On("IncrementAccessCount", url).Return(errors.New("database error"))
+			},
+			expectURL:  "https://example.com/page",
+			expectCode: 302,
+		},
+		{
+			name:      "Disabled link",
+			shortCode: "abc123",
+			setupMock: func(m *MockURLRepository) {
+				m.On("FindByShortCode", "abc123").Return(&models.URL{ShortCode: "abc123", Status: models.URLStatusDisabled}, nil)
+			},
+			expectError: true,
+			expectErrIs: ErrURLDisabled,
+		},
+		{
+			name:      "Paused link",
+			shortCode: "abc123",
+			setupMock: func(m *MockURLRepository) {
+				m.On("FindByShortCode", "abc123").Return(&models.URL{ShortCode: "abc123", Status: models.URLStatusPaused}, nil)
+			},
+			expectError: true,
+			expectErrIs: ErrURLPaused,
+		},
+		{
+			name:      "Link not active yet",
+			shortCode: "abc123",
+			setupMock: func(m *MockURLRepository) {
+				launch := time.Now().Add(time.Hour)
+				m.On("FindByShortCode", "abc123").Return(&models.URL{ShortCode: "abc123", ActiveFrom: &launch}, nil)
+			},
+			expectError: true,
+			expectErrIs: ErrURLNotActive,
+		},
+		{
+			name:      "Link past its active window",
+			shortCode: "abc123",
+			setupMock: func(m *MockURLRepository) {
+				end := time.Now().Add(-time.Hour)
+				m.On("FindByShortCode", "abc123").Return(&models.URL{ShortCode: "abc123", ActiveUntil: &end}, nil)
+			},
+			expectError: true,
+			expectErrIs: ErrURLEnded,
+		},
+		{
+			name:      "Link within its active window",
+			shortCode: "abc123",
+			setupMock: func(m *MockURLRepository) {
+				start, end := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+				url := &models.URL{OriginalURL: "https://example.com/launch", ShortCode: "abc123", ActiveFrom: &start, ActiveUntil: &end}
+				m.On("FindByShortCode", "abc123").Return(url, nil)
+				m.On("IncrementAccessCount", url).Return(nil)
+			},
+			expectURL:  "https://example.com/launch",
+			expectCode: 302,
 		},
 	}
 
@@ -169,13 +580,17 @@ func TestGetOriginalURL(t *testing.T) {
 			service, mockRepo := setupTestService()
 			tt.setupMock(mockRepo)
 
-			url, err := service.GetOriginalURL(tt.shortCode)
+			redirect, err := service.GetOriginalURL(context.Background(), tt.shortCode, Visit{})
 
 			if tt.expectError {
 				assert.Error(t, err)
+				if tt.expectErrIs != nil {
+					assert.ErrorIs(t, err, tt.expectErrIs)
+				}
 			} else {
 				assert.NoError(t, err)
-				assert.Equal(t, tt.expectURL, url)
+				assert.Equal(t, tt.expectURL, redirect.Location)
+				assert.Equal(t, tt.expectCode, redirect.StatusCode)
 			}
 			mockRepo.AssertExpectations(t)
 		})
@@ -199,7 +614,7 @@ func TestGetTopDomains(t *testing.T) {
 					{Domain: "test.com", Count: 3},
 					{Domain: "demo.com", Count: 1},
 				}
-				m.On("GetTopDomains", 3).Return(metrics, nil)
+				m.On("GetTopDomains", 3, repository.URLScope{}).Return(metrics, nil)
 			},
 			expectMetrics: []models.DomainMetric{
 				{Domain: "example.com", Count: 5},
@@ -212,7 +627,7 @@ func TestGetTopDomains(t *testing.T) {
 			name:  "Database error",
 			limit: 3,
 			setupMock: func(m *MockURLRepository) {
-				m.On("GetTopDomains", 3).Return([]models.DomainMetric{}, errors.New("database error"))
+				m.On("GetTopDomains", 3, repository.URLScope{}).Return([]models.DomainMetric{}, errors.New("database error"))
 			},
 			expectError: true,
 		},
@@ -223,7 +638,7 @@ func TestGetTopDomains(t *testing.T) {
 			service, mockRepo := setupTestService()
 			tt.setupMock(mockRepo)
 
-			metrics, err := service.GetTopDomains(tt.limit)
+			metrics, err := service.GetTopDomains(context.Background(), tt.limit, repository.URLScope{})
 
 			if tt.expectError {
 				assert.Error(t, err)
@@ -235,3 +650,975 @@ func TestGetTopDomains(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateURL(t *testing.T) {
+	expiry := time.Now().Add(time.Hour)
+	newURL := "https://www.example.org/moved"
+	badURL := "javascript:alert(1)"
+	disabled := models.URLStatusDisabled
+	paused := models.URLStatusPaused
+	sleeping := "sleeping"
+	launch := expiry.Add(-30 * time.Minute)
+	earlier := expiry.Add(-2 * time.Hour)
+
+	tests := []struct {
+		name        string
+		update      URLUpdate
+		setupMock   func(*MockURLRepository)
+		expectError error
+		check       func(*testing.T, *models.URL)
+	}{
+		{
+			name:   "Retarget recomputes domain",
+			update: URLUpdate{OriginalURL: &newURL},
+			setupMock: func(m *MockURLRepository) {
+				m.On("Update", mock.Anything).Return(nil)
+			},
+			check: func(t *testing.T, url *models.URL) {
+				assert.Equal(t, newURL, url.OriginalURL)
+				assert.Equal(t, "example.org", url.Domain)
+				assert.Equal(t, &expiry, url.ExpiresAt)
+			},
+		},
+		{
+			name:   "Disable and clear expiry",
+			update: URLUpdate{Status: &disabled, ClearExpiry: true},
+			setupMock: func(m *MockURLRepository) {
+				m.On("Update", mock.Anything).Return(nil)
+			},
+			check: func(t *testing.T, url *models.URL) {
+				assert.Equal(t, models.URLStatusDisabled, url.Status)
+				assert.Nil(t, url.ExpiresAt)
+			},
+		},
+		{
+			name:        "Invalid destination",
+			update:      URLUpdate{OriginalURL: &badURL},
+			setupMock:   func(m *MockURLRepository) {},
+			expectError: ErrInvalidURL,
+		},
+		{
+			name:   "Pause and schedule",
+			update: URLUpdate{Status: &paused, ActiveFrom: &launch, ActiveUntil: &expiry},
+			setupMock: func(m *MockURLRepository) {
+				m.On("Update", mock.Anything).Return(nil)
+			},
+			check: func(t *testing.T, url *models.URL) {
+				assert.Equal(t, models.URLStatusPaused, url.Status)
+				assert.Equal(t, &launch, url.ActiveFrom)
+				assert.Equal(t, &expiry, url.ActiveUntil)
+			},
+		},
+		{
+			name:        "Active window ending before it starts",
+			update:      URLUpdate{ActiveFrom: &expiry, ActiveUntil: &earlier},
+			setupMock:   func(m *MockURLRepository) {},
+			expectError: ErrInvalidActiveWindow,
+		},
+		{
+			name:        "Invalid status",
+			update:      URLUpdate{Status: &sleeping},
+			setupMock:   func(m *MockURLRepository) {},
+			expectError: ErrInvalidStatus,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mockRepo := setupTestService()
+			tt.setupMock(mockRepo)
+			link := &models.URL{
+				ShortCode:   "abc123",
+				OriginalURL: "https://example.com/page",
+				Domain:      "example.com",
+				Status:      models.URLStatusActive,
+				ExpiresAt:   &expiry,
+			}
+
+			updated, err := service.UpdateURL(context.Background(), link, tt.update)
+
+			if tt.expectError != nil {
+				assert.ErrorIs(t, err, tt.expectError)
+			} else {
+				assert.NoError(t, err)
+				tt.check(t, updated)
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUnlockURL(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	protected := &models.URL{
+		OriginalURL:  "https://example.com/doc",
+		ShortCode:    "abc123",
+		PasswordHash: string(hash),
+	}
+
+	t.Run("Redirect requires the password", func(t *testing.T) {
+		service, mockRepo := setupTestService()
+		mockRepo.On("FindByShortCode", "abc123").Return(protected, nil)
+
+		_, err := service.GetOriginalURL(context.Background(), "abc123", Visit{})
+
+		assert.ErrorIs(t, err, ErrPasswordRequired)
+		mockRepo.AssertNotCalled(t, "IncrementAccessCount", mock.Anything)
+	})
+
+	t.Run("Correct password redirects and counts the visit", func(t *testing.T) {
+		service, mockRepo := setupTestService()
+		mockRepo.On("FindByShortCode", "abc123").Return(protected, nil)
+		mockRepo.On("IncrementAccessCount", protected).Return(nil)
+
+		redirect, err := service.UnlockURL(context.Background(), "abc123", "s3cret", Visit{})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/doc", redirect.Location)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("Failed attempts are limited per code", func(t *testing.T) {
+		service, mockRepo := setupTestService()
+		mockRepo.On("FindByShortCode", "abc123").Return(protected, nil)
+
+		for i := 0; i < 3; i++ {
+			_, err := service.UnlockURL(context.Background(), "abc123", "guess", Visit{ClientIP: fmt.Sprintf("203.0.113.%d", i)})
+			assert.ErrorIs(t, err, ErrWrongPassword)
+		}
+
+		_, err := service.UnlockURL(context.Background(), "abc123", "s3cret", Visit{ClientIP: "198.51.100.4"})
+		assert.ErrorIs(t, err, ErrTooManyAttempts, "guesses from many addresses share the budget, locking out everyone")
+		var tooMany *TooManyAttemptsError
+		if assert.ErrorAs(t, err, &tooMany) {
+			assert.True(t, tooMany.RetryAfter > 0 && tooMany.RetryAfter <= time.Minute)
+		}
+		mockRepo.AssertNumberOfCalls(t, "FindByShortCode", 3)
+	})
+
+	t.Run("Concurrent guesses cannot exceed the limit", func(t *testing.T) {
+		service, mockRepo := setupTestService()
+		mockRepo.On("FindByShortCode", "abc123").Return(protected, nil)
+
+		var wrong, limited atomic.Int32
+		var wg sync.WaitGroup
+		for i := 0; i < 12; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := service.UnlockURL(context.Background(), "abc123", "guess", Visit{})
+				switch {
+				case errors.Is(err, ErrWrongPassword):
+					wrong.Add(1)
+				case errors.Is(err, ErrTooManyAttempts):
+					limited.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(3), wrong.Load())
+		assert.Equal(t, int32(9), limited.Load())
+	})
+
+	t.Run("Successful attempts do not count", func(t *testing.T) {
+		service, mockRepo := setupTestService()
+		mockRepo.On("FindByShortCode", "abc123").Return(protected, nil)
+		mockRepo.On("IncrementAccessCount", protected).Return(nil)
+
+		for i := 0; i < 5; i++ {
+			_, err := service.UnlockURL(context.Background(), "abc123", "s3cret", Visit{})
+			require.NoError(t, err)
+		}
+	})
+}
+
+func TestPreviewURL(t *testing.T) {
+	t.Run("Does not count a visit", func(t *testing.T) {
+		service, mockRepo := setupTestService()
+		url := &models.URL{OriginalURL: "https://example.com/page", ShortCode: "abc123"}
+		mockRepo.On("FindByShortCode", "abc123").Return(url, nil)
+
+		redirect, err := service.PreviewURL(context.Background(), "abc123", Visit{})
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/page", redirect.Location)
+		assert.Same(t, url, redirect.Link)
+		assert.False(t, redirect.Interstitial)
+		mockRepo.AssertNotCalled(t, "IncrementAccessCount", mock.Anything)
+	})
+
+	t.Run("Keeps the destination of protected links secret", func(t *testing.T) {
+		service, mockRepo := setupTestService()
+		mockRepo.On("FindByShortCode", "abc123").Return(&models.URL{ShortCode: "abc123", PasswordHash: "hash"}, nil)
+
+		_, err := service.PreviewURL(context.Background(), "abc123", Visit{})
+		assert.ErrorIs(t, err, ErrPasswordRequired)
+	})
+
+	t.Run("Per-link interstitial", func(t *testing.T) {
+		service, mockRepo := setupTestService()
+		url := &models.URL{OriginalURL: "https://example.com/page", ShortCode: "abc123", Preview: true}
+		mockRepo.On("FindByShortCode", "abc123").Return(url, nil)
+		mockRepo.On("IncrementAccessCount", url).Return(nil)
+
+		redirect, err := service.GetOriginalURL(context.Background(), "abc123", Visit{})
+		assert.NoError(t, err)
+		assert.True(t, redirect.Interstitial)
+	})
+
+	t.Run("Global interstitial", func(t *testing.T) {
+		service, mockRepo := setupTestService()
+		service.config.Redirect.Interstitial = true
+		url := &models.URL{OriginalURL: "https://example.com/page", ShortCode: "abc123"}
+		mockRepo.On("FindByShortCode", "abc123").Return(url, nil)
+		mockRepo.On("IncrementAccessCount", url).Return(nil)
+
+		redirect, err := service.GetOriginalURL(context.Background(), "abc123", Visit{})
+		assert.NoError(t, err)
+		assert.True(t, redirect.Interstitial)
+	})
+}
+
+func TestDeviceTargeting(t *testing.T) {
+	const (
+		iPhone   = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1"
+		android  = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36"
+		mac      = "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_4) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15"
+		windows  = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"
+		winPhone = "Mozilla/5.0 (Windows Phone 10.0; Android 6.0.1; Microsoft; Lumia 950) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/52.0.2743.116 Mobile Safari/537.36 Edge/15.14977"
+		bot      = "curl/8.5.0"
+	)
+
+	link := &models.URL{
+		OriginalURL: "https://example.com/app",
+		DeviceRules: models.DeviceRules{
+			{Platform: "ios", URL: "https://apps.apple.com/app/id123"},
+			{Platform: "android", URL: "https://play.google.com/store/apps/details?id=com.example"},
+			{Platform: "mobile", URL: "https://m.example.com/app"},
+			{Platform: "desktop", URL: "https://example.com/app/desktop"},
+		},
+	}
+
+	tests := []struct {
+		userAgent string
+		expectURL string
+	}{
+		{iPhone, "https://apps.apple.com/app/id123"},
+		{android, "https://play.google.com/store/apps/details?id=com.example"},
+		{winPhone, "https://m.example.com/app"},
+		{mac, "https://example.com/app/desktop"},
+		{windows, "https://example.com/app/desktop"},
+		{bot, "https://example.com/app"},
+		{"", "https://example.com/app"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.userAgent, func(t *testing.T) {
+			service, mockRepo := setupTestService()
+			mockRepo.On("FindByShortCode", "abc123").Return(link, nil)
+			mockRepo.On("IncrementAccessCount", link).Return(nil)
+
+			visit := Visit{Header: http.Header{"User-Agent": {tt.userAgent}}}
+			redirect, err := service.GetOriginalURL(context.Background(), "abc123", visit)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectURL, redirect.Location)
+			assert.True(t, redirect.Targeted)
+		})
+	}
+}
+
+func TestGeoTargeting(t *testing.T) {
+	link := &models.URL{
+		ID:          9,
+		OriginalURL: "https://shop.example.com",
+		GeoRules: models.GeoRules{
+			{Countries: []string{"DE", "AT", "CH"}, URL: "https://shop.example.de"},
+			{Countries: []string{"FR"}, URL: "https://shop.example.fr"},
+		},
+	}
+	locator := stubLocator{"203.0.113.7": "AT", "198.51.100.1": "FR", "192.0.2.55": "US"}
+
+	tests := []struct {
+		clientIP  string
+		expectURL string
+	}{
+		{"203.0.113.7", "https://shop.example.de"},
+		{"198.51.100.1", "https://shop.example.fr"},
+		{"192.0.2.55", "https://shop.example.com"},
+		{"10.0.0.1", "https://shop.example.com"},
+		{"", "https://shop.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.clientIP, func(t *testing.T) {
+			service, mockRepo := setupTestService()
+			service.locator = locator
+			mockRepo.On("FindByShortCode", "shop").Return(link, nil)
+			mockRepo.On("IncrementAccessCount", link).Return(nil)
+
+			redirect, err := service.GetOriginalURL(context.Background(), "shop", Visit{ClientIP: tt.clientIP})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectURL, redirect.Location)
+			assert.True(t, redirect.Targeted)
+		})
+	}
+
+	t.Run("Device rules take precedence", func(t *testing.T) {
+		service, mockRepo := setupTestService()
+		service.locator = locator
+		link := &models.URL{
+			OriginalURL: "https://shop.example.com",
+			DeviceRules: models.DeviceRules{{Platform: "ios", URL: "https://apps.apple.com/app/id123"}},
+			GeoRules:    models.GeoRules{{Countries: []string{"AT"}, URL: "https://shop.example.de"}},
+		}
+		mockRepo.On("FindByShortCode", "shop").Return(link, nil)
+		mockRepo.On("IncrementAccessCount", link).Return(nil)
+
+		visit := Visit{Header: http.Header{"User-Agent": {"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X)"}}, ClientIP: "203.0.113.7"}
+		redirect, err := service.GetOriginalURL(context.Background(), "shop", visit)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://apps.apple.com/app/id123", redirect.Location)
+	})
+
+	t.Run("Country codes are normalised", func(t *testing.T) {
+		service, mockRepo := setupTestService()
+		mockRepo.On("FindByShortCode", mock.Anything).Return(nil, gorm.ErrRecordNotFound)
+		mockRepo.On("Create", mock.Anything).Return(nil)
+
+		url, err := service.ShortenURL(context.Background(), "https://shop.example.com", ShortenOptions{
+			GeoRules: models.GeoRules{{Countries: []string{"de"}, URL: "https://shop.example.de"}},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"DE"}, url.GeoRules[0].Countries)
+	})
+
+	t.Run("Invalid country code", func(t *testing.T) {
+		service, _ := setupTestService()
+		_, err := service.ShortenURL(context.Background(), "https://shop.example.com", ShortenOptions{
+			GeoRules: models.GeoRules{{Countries: []string{"Germany"}, URL: "https://shop.example.de"}},
+		})
+		assert.ErrorIs(t, err, ErrInvalidGeoRule)
+	})
+}
+
+func TestClickEvents(t *testing.T) {
+	service, mockRepo := setupTestService()
+	clicks := new(MockClickRepository)
+	service.clicks = clicks
+	service.locator = stubLocator{"203.0.113.7": "AT"}
+
+	link := &models.URL{ID: 9, OriginalURL: "https://example.com/page", ShortCode: "abc123"}
+	mockRepo.On("FindByShortCode", "abc123").Return(link, nil)
+	mockRepo.On("IncrementAccessCount", link).Return(nil)
+	clicks.On("Create", &models.ClickEvent{URLID: 9, Country: "AT", Platform: "android", Source: models.ClickSourceQR}).Return(errors.New("database error"))
+
+	visit := Visit{
+		Header:   http.Header{"User-Agent": {"Mozilla/5.0 (Linux; Android 14; Pixel 8)"}},
+		ClientIP: "203.0.113.7",
+		Source:   models.ClickSourceQR,
+	}
+	redirect, err := service.GetOriginalURL(context.Background(), "abc123", visit)
+	assert.NoError(t, err, "a lost click event must not fail the redirect")
+	assert.Equal(t, "https://example.com/page", redirect.Location)
+	clicks.AssertExpectations(t)
+
+	_, err = service.PreviewURL(context.Background(), "abc123", visit)
+	assert.NoError(t, err)
+	clicks.AssertNumberOfCalls(t, "Create", 1)
+}
+
+func TestGetSourceStats(t *testing.T) {
+	service, _ := setupTestService()
+	clicks := new(MockClickRepository)
+	service.clicks = clicks
+	clicks.On("CountBySource", uint(9)).Return([]models.SourceMetric{
+		{Source: "", Clicks: 7},
+		{Source: models.ClickSourceQR, Clicks: 5},
+	}, nil)
+
+	stats, err := service.GetSourceStats(context.Background(), &models.URL{ID: 9})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []models.SourceMetric{
+		{Source: models.ClickSourceDirect, Clicks: 7},
+		{Source: models.ClickSourceQR, Clicks: 5},
+	}, stats)
+}
+
+func TestVariantRotation(t *testing.T) {
+	link := &models.URL{
+		ID:          9,
+		ShortCode:   "promo",
+		OriginalURL: "https://example.com/landing",
+		Variants: models.Variants{
+			{Name: "a", URL: "https://example.com/landing-a", Weight: 3},
+			{Name: "b", URL: "https://example.com/landing-b", Weight: 1},
+			{Name: "off", URL: "https://example.com/landing-off", Weight: 0},
+		},
+	}
+
+	t.Run("Picks variants by weight and records them", func(t *testing.T) {
+		service, mockRepo := setupTestService()
+		clicks := new(MockClickRepository)
+		service.clicks = clicks
+		mockRepo.On("FindByShortCode", "promo").Return(link, nil)
+		mockRepo.On("IncrementAccessCount", link).Return(nil)
+		clicks.On("Create", mock.Anything).Return(nil)
+
+		served := map[string]int{}
+		for i := 0; i < 2000; i++ {
+			redirect, err := service.GetOriginalURL(context.Background(), "promo", Visit{})
+			require.NoError(t, err)
+			served[redirect.Variant]++
+			assert.Equal(t, "https://example.com/landing-"+redirect.Variant, redirect.Location)
+		}
+		assert.Zero(t, served["off"])
+		assert.InDelta(t, 1500, served["a"], 150)
+		assert.InDelta(t, 500, served["b"], 150)
+
+		recorded := clicks.Calls[0].Arguments.Get(0).(*models.ClickEvent)
+		assert.NotEmpty(t, recorded.Variant)
+	})
+
+	t.Run("Sticky variant", func(t *testing.T) {
+		service, mockRepo := setupTestService()
+		sticky := *link
+		sticky.StickyVariants = true
+		mockRepo.On("FindByShortCode", "promo").Return(&sticky, nil)
+		mockRepo.On("IncrementAccessCount", &sticky).Return(nil)
+
+		for i := 0; i < 20; i++ {
+			redirect, err := service.GetOriginalURL(context.Background(), "promo", Visit{Variant: "b"})
+			require.NoError(t, err)
+			assert.Equal(t, "b", redirect.Variant)
+		}
+
+		// A variant taken out of rotation is not kept
+		redirect, err := service.GetOriginalURL(context.Background(), "promo", Visit{Variant: "off"})
+		require.NoError(t, err)
+		assert.NotEqual(t, "off", redirect.Variant)
+	})
+
+	t.Run("Previewed variant is served even if not sticky", func(t *testing.T) {
+		service, mockRepo := setupTestService()
+		mockRepo.On("FindByShortCode", "promo").Return(link, nil)
+		mockRepo.On("IncrementAccessCount", link).Return(nil)
+
+		for i := 0; i < 20; i++ {
+			redirect, err := service.GetOriginalURL(context.Background(), "promo", Visit{Previewed: "b", Variant: "a"})
+			require.NoError(t, err)
+			assert.Equal(t, "b", redirect.Variant)
+		}
+
+		redirect, err := service.GetOriginalURL(context.Background(), "promo", Visit{Previewed: "off"})
+		require.NoError(t, err)
+		assert.NotEqual(t, "off", redirect.Variant)
+	})
+
+	t.Run("Invalid variants", func(t *testing.T) {
+		service, _ := setupTestService()
+		for _, variants := range []models.Variants{
+			{{Name: "a", URL: "https://example.com/a", Weight: 0}},
+			{{Name: "a", URL: "https://example.com/a", Weight: 1}, {Name: "a", URL: "https://example.com/b", Weight: 1}},
+			{{Name: "", URL: "https://example.com/a", Weight: 1}},
+			{{Name: "a", URL: "https://example.com/a", Weight: -1}, {Name: "b", URL: "https://example.com/b", Weight: 2}},
+			{{Name: "a", URL: "ftp://example.com/a", Weight: 1}},
+		} {
+			_, err := service.ShortenURL(context.Background(), "https://example.com/landing", ShortenOptions{Variants: variants})
+			assert.ErrorIs(t, err, ErrInvalidVariants)
+		}
+	})
+}
+
+func TestGetVariantStats(t *testing.T) {
+	service, _ := setupTestService()
+	clicks := new(MockClickRepository)
+	service.clicks = clicks
+	link := &models.URL{
+		ID: 9,
+		Variants: models.Variants{
+			{Name: "a", URL: "https://example.com/a", Weight: 1},
+			{Name: "b", URL: "https://example.com/b", Weight: 1},
+		},
+	}
+	clicks.On("CountByVariant", uint(9)).Return([]models.VariantMetric{
+		{Variant: "", Clicks: 4},
+		{Variant: "a", Clicks: 10},
+		{Variant: "old", Clicks: 2},
+	}, nil)
+
+	stats, err := service.GetVariantStats(context.Background(), link)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.VariantMetric{
+		{Variant: "a", Clicks: 10},
+		{Variant: "b", Clicks: 0},
+		{Variant: "old", Clicks: 2},
+	}, stats)
+}
+
+func TestShortenURLWithUTMTemplate(t *testing.T) {
+	workspaceID := uint(3)
+	template := &models.UTMTemplate{WorkspaceID: 3, Name: "newsletter", Source: "newsletter", Medium: "email"}
+	tagged := "https://example.com/shop?id=7&utm_source=newsletter&utm_medium=email"
+
+	t.Run("Tagged URL is deduplicated as its own destination", func(t *testing.T) {
+		service, mockRepo := setupTestService()
+		templates := new(MockUTMTemplateRepository)
+		service.templates = templates
+		templates.On("FindByName", workspaceID, "newsletter").Return(template, nil)
+		existing := &models.URL{OriginalURL: tagged, ShortCode: "tag123", Status: models.URLStatusActive}
+		mockRepo.On("FindByOriginalURL", tagged, repository.URLScope{WorkspaceID: &workspaceID}).Return(existing, nil)
+
+		url, err := service.ShortenURL(context.Background(), "https://example.com/shop?id=7", ShortenOptions{WorkspaceID: &workspaceID, UTMTemplate: "newsletter"})
+		assert.NoError(t, err)
+		assert.Same(t, existing, url)
+	})
+
+	t.Run("Stores the tagged URL", func(t *testing.T) {
+		service, mockRepo := setupTestService()
+		templates := new(MockUTMTemplateRepository)
+		service.templates = templates
+		templates.On("FindByName", workspaceID, "newsletter").Return(template, nil)
+		mockRepo.On("FindByOriginalURL", tagged, mock.Anything).Return(nil, gorm.ErrRecordNotFound)
+		mockRepo.On("FindByShortCode", mock.Anything).Return(nil, gorm.ErrRecordNotFound)
+		mockRepo.On("Create", mock.Anything).Return(nil)
+
+		url, err := service.ShortenURL(context.Background(), "https://example.com/shop?id=7", ShortenOptions{WorkspaceID: &workspaceID, UTMTemplate: "newsletter"})
+		assert.NoError(t, err)
+		assert.Equal(t, tagged, url.OriginalURL)
+		assert.Equal(t, "example.com", url.Domain)
+	})
+
+	t.Run("Unknown template", func(t *testing.T) {
+		service, _ := setupTestService()
+		templates := new(MockUTMTemplateRepository)
+		service.templates = templates
+		templates.On("FindByName", workspaceID, "missing").Return(nil, gorm.ErrRecordNotFound)
+
+		_, err := service.ShortenURL(context.Background(), "https://example.com/shop", ShortenOptions{WorkspaceID: &workspaceID, UTMTemplate: "missing"})
+		assert.ErrorIs(t, err, ErrUTMTemplateNotFound)
+	})
+
+	t.Run("Templates belong to workspaces", func(t *testing.T) {
+		service, _ := setupTestService()
+		_, err := service.ShortenURL(context.Background(), "https://example.com/shop", ShortenOptions{UTMTemplate: "newsletter"})
+		assert.ErrorIs(t, err, ErrUTMTemplateNotFound)
+	})
+}
+
+func TestPassthrough(t *testing.T) {
+	tests := []struct {
+		name      string
+		link      models.URL
+		path      string
+		query     string
+		expectURL string
+	}{
+		{
+			name:      "Disabled",
+			link:      models.URL{OriginalURL: "https://example.com/docs?lang=en"},
+			path:      "/guide",
+			query:     "ref=x",
+			expectURL: "https://example.com/docs?lang=en",
+		},
+		{
+			name:      "Path appended",
+			link:      models.URL{OriginalURL: "https://example.com/docs/", PassthroughPath: true},
+			path:      "/guide/intro",
+			expectURL: "https://example.com/docs/guide/intro",
+		},
+		{
+			name:      "Path appended before query and fragment",
+			link:      models.URL{OriginalURL: "https://example.com/docs?lang=en#top", PassthroughPath: true},
+			path:      "/guide",
+			expectURL: "https://example.com/docs/guide?lang=en#top",
+		},
+		{
+			name:      "Dot segments stay below the destination",
+			link:      models.URL{OriginalURL: "https://example.com/docs/", PassthroughPath: true},
+			path:      "/guide/../../../admin/./users",
+			expectURL: "https://example.com/docs/admin/users",
+		},
+		{
+			name:      "Nothing left after dot segments",
+			link:      models.URL{OriginalURL: "https://example.com/docs", PassthroughPath: true},
+			path:      "/../..",
+			expectURL: "https://example.com/docs",
+		},
+		{
+			name:      "Query merged",
+			link:      models.URL{OriginalURL: "https://example.com/docs?lang=en", PassthroughQuery: true},
+			query:     "ref=x&utm_source=mail",
+			expectURL: "https://example.com/docs?lang=en&ref=x&utm_source=mail",
+		},
+		{
+			name:      "Destination wins by default",
+			link:      models.URL{OriginalURL: "https://example.com/docs?lang=en&b=1", PassthroughQuery: true},
+			query:     "lang=de&ref=x",
+			expectURL: "https://example.com/docs?lang=en&b=1&ref=x",
+		},
+		{
+			name:      "Incoming wins",
+			link:      models.URL{OriginalURL: "https://example.com/docs?lang=en&b=1", PassthroughQuery: true, QueryConflict: models.QueryConflictIncoming},
+			query:     "lang=de&lang=fr",
+			expectURL: "https://example.com/docs?b=1&lang=de&lang=fr",
+		},
+		{
+			name:      "Values are re-escaped",
+			link:      models.URL{OriginalURL: "https://example.com/search", PassthroughQuery: true},
+			query:     "q=a+b%26c",
+			expectURL: "https://example.com/search?q=a+b%26c",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mockRepo := setupTestService()
+			link := tt.link
+			mockRepo.On("FindByShortCode", "abc123").Return(&link, nil)
+			mockRepo.On("IncrementAccessCount", &link).Return(nil)
+
+			query, err := url.ParseQuery(tt.query)
+			require.NoError(t, err)
+			redirect, err := service.GetOriginalURL(context.Background(), "abc123", Visit{Path: tt.path, Query: query})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectURL, redirect.Location)
+		})
+	}
+
+	t.Run("Unknown conflict rule", func(t *testing.T) {
+		service, _ := setupTestService()
+		_, err := service.ShortenURL(context.Background(), "https://example.com/docs", ShortenOptions{PassthroughQuery: true, QueryConflict: "merge"})
+		assert.ErrorIs(t, err, ErrInvalidConflictRule)
+	})
+}
+
+func TestAttemptLimiter(t *testing.T) {
+	limiter := newAttemptLimiter(2, time.Minute)
+	start := time.Now()
+
+	ok, _ := limiter.Reserve("abc", start)
+	assert.True(t, ok)
+	ok, _ = limiter.Reserve("abc", start.Add(10*time.Second))
+	assert.True(t, ok)
+
+	ok, retryAfter := limiter.Reserve("abc", start.Add(20*time.Second))
+	assert.False(t, ok, "attempts count from when they are reserved")
+	assert.Equal(t, 40*time.Second, retryAfter)
+
+	ok, _ = limiter.Reserve("other", start.Add(20*time.Second))
+	assert.True(t, ok, "limits are per key")
+
+	limiter.Release("abc", start.Add(10*time.Second))
+	ok, _ = limiter.Reserve("abc", start.Add(30*time.Second))
+	assert.True(t, ok, "released attempts do not count")
+	ok, _ = limiter.Reserve("abc", start.Add(30*time.Second))
+	assert.False(t, ok)
+
+	ok, _ = limiter.Reserve("abc", start.Add(91*time.Second))
+	assert.True(t, ok, "failures leave the window")
+	limiter.Reserve("once", start.Add(100*time.Second))
+	limiter.Reserve("abc", start.Add(200*time.Second))
+	assert.NotContains(t, limiter.failures, "once", "keys not tried again are evicted")
+}
+
+func TestFallback(t *testing.T) {
+	workspaceID := uint(3)
+
+	t.Run("Unknown code gets the deployment fallback", func(t *testing.T) {
+		service, mockRepo, _ := setupTestServiceWithWorkspaces()
+		service.config.Fallback.URL = "https://example.com/"
+		mockRepo.On("FindByShortCode", "nope").Return(&models.URL{}, gorm.ErrRecordNotFound)
+
+		assert.Equal(t, Fallback{URL: "https://example.com/"}, service.Fallback(context.Background(), "nope"))
+	})
+
+	t.Run("Workspace fallback wins", func(t *testing.T) {
+		service, mockRepo, workspaces := setupTestServiceWithWorkspaces()
+		service.config.Fallback.URL = "https://example.com/"
+		mockRepo.On("FindByShortCode", "promo").Return(&models.URL{ShortCode: "promo", WorkspaceID: &workspaceID}, nil)
+		workspaces.On("FindByID", workspaceID).Return(&models.Workspace{ID: workspaceID, FallbackPage: "<p>Offer over</p>"}, nil)
+
+		assert.Equal(t, Fallback{Page: "<p>Offer over</p>", FromWorkspace: true}, service.Fallback(context.Background(), "promo"))
+	})
+
+	t.Run("Workspace without a fallback uses the deployment's", func(t *testing.T) {
+		service, mockRepo, workspaces := setupTestServiceWithWorkspaces()
+		service.config.Fallback.Page = "<p>Not here</p>"
+		mockRepo.On("FindByShortCode", "promo").Return(&models.URL{ShortCode: "promo", WorkspaceID: &workspaceID}, nil)
+		workspaces.On("FindByID", workspaceID).Return(&models.Workspace{ID: workspaceID}, nil)
+
+		assert.Equal(t, Fallback{Page: "<p>Not here</p>"}, service.Fallback(context.Background(), "promo"))
+	})
+}
+
+func TestShortenBatch(t *testing.T) {
+	ownerID := uint(7)
+	existing := models.URL{ID: 1, OriginalURL: "https://example.com/old", ShortCode: "old123", Status: models.URLStatusActive}
+	paused := models.URL{ID: 2, OriginalURL: "https://example.com/new", ShortCode: "new123", Status: models.URLStatusPaused}
+
+	service, mockRepo := setupTestService()
+	service.config.Batch.MaxItems = 10
+	mockRepo.On("FindByOriginalURLs", []string{"https://example.com/old", "https://example.com/new", "https://example.com/new"},
+		repository.URLScope{OwnerID: &ownerID}).Return([]models.URL{existing, paused}, nil)
+	mockRepo.On("TakenShortCodes", []string{"taken", "launch"}).Return([]string{"TAKEN"}, nil)
+	mockRepo.On("TakenShortCodes", mock.Anything).Return([]string{}, nil)
+	var created []*models.URL
+	mockRepo.On("CreateBatch", mock.Anything).Run(func(args mock.Arguments) {
+		created = args.Get(0).([]*models.URL)
+	}).Return(nil)
+
+	results, err := service.ShortenBatch(context.Background(), []BatchItem{
+		{URL: "https://example.com/old"},
+		{URL: "https://example.com/new"},
+		{URL: "not a url"},
+		{URL: "https://example.com/a", Alias: "taken"},
+		{URL: "https://example.com/b", Alias: "launch"},
+		{URL: "https://example.com/c", Alias: "Launch"},
+		{URL: "https://example.com/new"},
+	}, ShortenOptions{OwnerID: &ownerID})
+
+	require.NoError(t, err)
+	require.Len(t, results, 7)
+	assert.Equal(t, "old123", results[0].URL.ShortCode, "existing link is reused")
+	assert.NotEmpty(t, results[1].URL.ShortCode)
+	assert.NotEqual(t, "new123", results[1].URL.ShortCode, "paused link is not reused")
+	assert.ErrorIs(t, results[2].Err, ErrInvalidURL)
+	assert.ErrorIs(t, results[3].Err, ErrAliasTaken)
+	assert.Equal(t, "launch", results[4].URL.ShortCode)
+	assert.ErrorIs(t, results[5].Err, ErrAliasTaken, "aliases differing in case collide")
+	assert.Same(t, results[1].URL, results[6].URL, "repeated destination gets one link")
+	assert.Len(t, created, 2)
+	for _, link := range created {
+		assert.Equal(t, &ownerID, link.OwnerID)
+	}
+	mockRepo.AssertNumberOfCalls(t, "CreateBatch", 1)
+}
+
+func TestShortenBatchLosesRace(t *testing.T) {
+	service, mockRepo := setupTestService()
+	service.config.Batch.MaxItems = 10
+	mockRepo.On("FindByOriginalURLs", mock.Anything, mock.Anything).Return([]models.URL{}, nil)
+	// Filled in once the first insert shows which code was generated
+	taken := make([]string, 2)
+	mockRepo.On("TakenShortCodes", mock.MatchedBy(func(codes []string) bool { return len(codes) == 3 })).Return(taken, nil).Once()
+	mockRepo.On("TakenShortCodes", mock.Anything).Return([]string{}, nil)
+	var generated string
+	mockRepo.On("CreateBatch", mock.Anything).Run(func(args mock.Arguments) {
+		urls := args.Get(0).([]*models.URL)
+		generated = urls[0].ShortCode
+		taken[0], taken[1] = generated, "PROMO"
+	}).Return(gorm.ErrDuplicatedKey).Once()
+	var created []*models.URL
+	mockRepo.On("CreateBatch", mock.Anything).Run(func(args mock.Arguments) {
+		created = args.Get(0).([]*models.URL)
+	}).Return(nil).Once()
+
+	results, err := service.ShortenBatch(context.Background(), []BatchItem{
+		{URL: "https://example.com/a"},
+		{URL: "https://example.com/b", Alias: "promo"},
+		{URL: "https://example.com/c", Alias: "launch"},
+	}, ShortenOptions{})
+
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	require.NoError(t, results[0].Err)
+	assert.NotEqual(t, generated, results[0].URL.ShortCode, "code taken meanwhile is regenerated")
+	assert.ErrorIs(t, results[1].Err, ErrAliasTaken, "alias taken meanwhile fails on its own")
+	assert.Nil(t, results[1].URL)
+	assert.Equal(t, "launch", results[2].URL.ShortCode)
+	assert.Equal(t, []*models.URL{results[0].URL, results[2].URL}, created)
+	mockRepo.AssertNumberOfCalls(t, "CreateBatch", 2)
+}
+
+func TestShortenBatchKeepsLosingRace(t *testing.T) {
+	service, mockRepo := setupTestService()
+	service.config.Batch.MaxItems = 10
+	mockRepo.On("FindByOriginalURLs", mock.Anything, mock.Anything).Return([]models.URL{}, nil)
+	mockRepo.On("TakenShortCodes", mock.Anything).Return([]string{}, nil)
+	mockRepo.On("CreateBatch", mock.Anything).Return(gorm.ErrDuplicatedKey)
+
+	_, err := service.ShortenBatch(context.Background(), []BatchItem{{URL: "https://example.com/a"}}, ShortenOptions{})
+
+	assert.ErrorIs(t, err, ErrStorage)
+	mockRepo.AssertNumberOfCalls(t, "CreateBatch", insertAttempts)
+}
+
+func TestShortenBatchTooLarge(t *testing.T) {
+	service, mockRepo := setupTestService()
+	service.config.Batch.MaxItems = 1
+
+	_, err := service.ShortenBatch(context.Background(), []BatchItem{{URL: "https://example.com/a"}, {URL: "https://example.com/b"}}, ShortenOptions{})
+
+	assert.ErrorIs(t, err, ErrBatchTooLarge)
+	mockRepo.AssertNotCalled(t, "CreateBatch", mock.Anything)
+}
+
+func TestExportURLs(t *testing.T) {
+	workspaceID := uint(3)
+	created := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	maxClicks := 10
+
+	service, mockRepo := setupTestService()
+	mockRepo.On("Each", repository.URLScope{WorkspaceID: &workspaceID}, mock.Anything).Return([][]models.URL{
+		{{ShortCode: "abc123", OriginalURL: "https://example.com/a", Domain: "example.com", Status: "active", CreatedAt: created, AccessCount: 4}},
+		{{ShortCode: "launch", OriginalURL: "https://example.com/b,c", Domain: "example.com", Status: "paused", CreatedAt: created, MaxClicks: &maxClicks, RedirectCode: 301,
+			PasswordHash: "$2a$10$hash", DeviceRules: models.DeviceRules{{Platform: "ios", URL: "https://apps.apple.com/x"}}, PassthroughPath: true}},
+	}, nil)
+
+	var buf strings.Builder
+	w, err := linkio.NewWriter(&buf, linkio.FormatCSV)
+	require.NoError(t, err)
+	require.NoError(t, service.ExportURLs(context.Background(), w, repository.URLScope{WorkspaceID: &workspaceID}))
+
+	assert.Equal(t, "short_code,original_url,domain,status,created_at,expires_at,active_from,active_until,redirect_code,max_clicks,password_hash,"+
+		"preview,device_rules,geo_rules,variants,sticky_variants,passthrough_path,passthrough_query,query_conflict,access_count\n"+
+		"abc123,https://example.com/a,example.com,active,2025-01-02T03:04:05Z,,,,,,,,,,,,,,,4\n"+
+		"launch,\"https://example.com/b,c\",example.com,paused,2025-01-02T03:04:05Z,,,,301,10,$2a$10$hash,,"+
+		"\"[{\"\"platform\"\":\"\"ios\"\",\"\"url\"\":\"\"https://apps.apple.com/x\"\"}]\",,,,true,,,0\n", buf.String())
+}
+
+func TestImportURLs(t *testing.T) {
+	ownerID := uint(7)
+	input := "original_url,short_code,access_count,status,created_at\n" +
+		"https://example.com/a,abc123,12,,2024-05-01T00:00:00Z\n" +
+		"https://example.com/b,taken,,,\n" +
+		"not a url,fine1,,,\n" +
+		"https://example.com/c,ABC123,,,\n" +
+		"https://example.com/d,okay99,x,,\n" +
+		"https://example.com/e,,,paused,\n" +
+		"https://example.com/f,bad!,,,\n"
+
+	setup := func() (*URLServiceImpl, *[]*models.URL) {
+		service, mockRepo := setupTestService()
+		mockRepo.On("TakenShortCodes", []string{"abc123", "taken"}).Return([]string{"TAKEN"}, nil)
+		mockRepo.On("TakenShortCodes", mock.Anything).Return([]string{}, nil)
+		var created []*models.URL
+		mockRepo.On("CreateBatch", mock.Anything).Run(func(args mock.Arguments) {
+			created = append(created, args.Get(0).([]*models.URL)...)
+		}).Return(nil)
+		return service, &created
+	}
+
+	t.Run("skips conflicts", func(t *testing.T) {
+		service, created := setup()
+		r, err := linkio.NewReader(strings.NewReader(input), linkio.FormatCSV)
+		require.NoError(t, err)
+
+		report, err := service.ImportURLs(context.Background(), r, ImportOptions{OwnerID: &ownerID})
+
+		require.NoError(t, err)
+		assert.Equal(t, 2, report.Imported)
+		assert.Equal(t, []ImportConflict{
+			{Line: 3, ShortCode: "taken"},
+			{Line: 5, ShortCode: "ABC123"},
+		}, report.Conflicts)
+		require.Len(t, report.Errors, 3)
+		assert.Equal(t, 4, report.Errors[0].Line)
+		assert.ErrorIs(t, report.Errors[0].Err, ErrInvalidURL)
+		assert.Equal(t, 6, report.Errors[1].Line, "malformed count")
+		assert.Equal(t, 8, report.Errors[2].Line)
+		assert.ErrorIs(t, report.Errors[2].Err, ErrInvalidAlias)
+
+		require.Len(t, *created, 2)
+		kept := (*created)[0]
+		assert.Equal(t, "abc123", kept.ShortCode)
+		assert.Equal(t, 12, kept.AccessCount)
+		assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), kept.CreatedAt)
+		assert.Equal(t, &ownerID, kept.OwnerID)
+		generated := (*created)[1]
+		assert.Len(t, generated.ShortCode, 6, "record without a code gets one")
+		assert.Equal(t, models.URLStatusPaused, generated.Status)
+	})
+
+	t.Run("renames conflicts", func(t *testing.T) {
+		service, created := setup()
+		r, err := linkio.NewReader(strings.NewReader(input), linkio.FormatCSV)
+		require.NoError(t, err)
+
+		report, err := service.ImportURLs(context.Background(), r, ImportOptions{OwnerID: &ownerID, RenameConflicts: true})
+
+		require.NoError(t, err)
+		assert.Equal(t, 4, report.Imported)
+		require.Len(t, report.Conflicts, 2)
+		for _, conflict := range report.Conflicts {
+			assert.Len(t, conflict.NewShortCode, 6)
+		}
+		assert.Len(t, *created, 4)
+	})
+
+	t.Run("codes taken during the insert", func(t *testing.T) {
+		for _, rename := range []bool{false, true} {
+			service, mockRepo := setupTestService()
+			mockRepo.On("TakenShortCodes", []string{"launch", "promo"}).Return([]string{}, nil).Once()
+			mockRepo.On("TakenShortCodes", []string{"launch", "promo"}).Return([]string{"LAUNCH"}, nil).Once()
+			mockRepo.On("TakenShortCodes", mock.Anything).Return([]string{}, nil)
+			mockRepo.On("CreateBatch", mock.Anything).Return(gorm.ErrDuplicatedKey).Once()
+			var created []*models.URL
+			mockRepo.On("CreateBatch", mock.Anything).Run(func(args mock.Arguments) {
+				created = args.Get(0).([]*models.URL)
+			}).Return(nil).Once()
+			r, err := linkio.NewReader(strings.NewReader("original_url,short_code\n"+
+				"https://example.com/a,launch\nhttps://example.com/b,promo\n"), linkio.FormatCSV)
+			require.NoError(t, err)
+
+			report, err := service.ImportURLs(context.Background(), r, ImportOptions{RenameConflicts: rename})
+
+			require.NoError(t, err)
+			require.Len(t, report.Conflicts, 1)
+			assert.Equal(t, 2, report.Conflicts[0].Line)
+			assert.Equal(t, "launch", report.Conflicts[0].ShortCode)
+			if rename {
+				assert.Equal(t, 2, report.Imported)
+				assert.Len(t, report.Conflicts[0].NewShortCode, 6)
+				require.Len(t, created, 2)
+				assert.Equal(t, report.Conflicts[0].NewShortCode, created[0].ShortCode)
+			} else {
+				assert.Equal(t, 1, report.Imported)
+				assert.Empty(t, report.Conflicts[0].NewShortCode)
+				require.Len(t, created, 1)
+				assert.Equal(t, "promo", created[0].ShortCode)
+			}
+		}
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		service, created := setup()
+		r, err := linkio.NewReader(strings.NewReader(
+			`{"short_code":"launch","original_url":"https://example.com/a","max_clicks":5}`+"\n\n{oops\n"), linkio.FormatNDJSON)
+		require.NoError(t, err)
+
+		report, err := service.ImportURLs(context.Background(), r, ImportOptions{})
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.Imported)
+		require.Len(t, report.Errors, 1)
+		assert.Equal(t, 3, report.Errors[0].Line)
+		require.Len(t, *created, 1)
+		assert.Equal(t, 5, *(*created)[0].MaxClicks)
+	})
+
+	t.Run("keeps passwords and targeting", func(t *testing.T) {
+		service, created := setup()
+		hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+		require.NoError(t, err)
+		r, err := linkio.NewReader(strings.NewReader(
+			`{"short_code":"locked","original_url":"https://example.com/a","password_hash":"`+string(hash)+`",`+
+				`"geo_rules":[{"countries":["de"],"url":"https://example.de"}],"passthrough_query":true,"query_conflict":"incoming"}`+"\n"+
+				`{"short_code":"forged","original_url":"https://example.com/a","password_hash":"secret"}`+"\n"), linkio.FormatNDJSON)
+		require.NoError(t, err)
+
+		report, err := service.ImportURLs(context.Background(), r, ImportOptions{})
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.Imported)
+		require.Len(t, report.Errors, 1)
+		assert.ErrorIs(t, report.Errors[0].Err, ErrInvalidPasswordHash)
+		require.Len(t, *created, 1)
+		link := (*created)[0]
+		assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte("secret")))
+		assert.Equal(t, models.GeoRules{{Countries: []string{"DE"}, URL: "https://example.de"}}, link.GeoRules)
+		assert.True(t, link.PassthroughQuery)
+		assert.Equal(t, models.QueryConflictIncoming, link.QueryConflict)
+	})
+}
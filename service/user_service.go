@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"urlshortner/models"
+	"urlshortner/repository"
+)
+
+var ErrInvalidEmail = errors.New("invalid email address")
+
+type UserService interface {
+	CreateUser(ctx context.Context, email, name string) (*models.User, error)
+	GetUser(ctx context.Context, id uint) (*models.User, error)
+	ListUsers(ctx context.Context) ([]models.User, error)
+}
+
+type UserServiceImpl struct {
+	repo repository.UserRepository
+}
+
+func NewUserService(repo repository.UserRepository) UserService {
+	return &UserServiceImpl{repo: repo}
+}
+
+func (s *UserServiceImpl) CreateUser(ctx context.Context, email, name string) (*models.User, error) {
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		return nil, ErrInvalidEmail
+	}
+
+	user := &models.User{
+		Email: address.Address,
+		Name:  name,
+	}
+	if err := s.repo.Create(ctx, user); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (s *UserServiceImpl) GetUser(ctx context.Context, id uint) (*models.User, error) {
+	return s.repo.FindByID(ctx, id)
+}
+
+func (s *UserServiceImpl) ListUsers(ctx context.Context) ([]models.User, error) {
+	return s.repo.List(ctx)
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"urlshortner/models"
+	"urlshortner/repository"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidUTMTemplate  = errors.New("invalid UTM template")
+	ErrUTMTemplateExists   = errors.New("UTM template already exists")
+	ErrUTMTemplateNotFound = errors.New("UTM template not found")
+)
+
+// UTMService manages the UTM templates of workspaces.
+type UTMService interface {
+	CreateTemplate(ctx context.Context, template *models.UTMTemplate) error
+	ListTemplates(ctx context.Context, workspaceID uint) ([]models.UTMTemplate, error)
+	DeleteTemplate(ctx context.Context, workspaceID, id uint) error
+}
+
+type UTMServiceImpl struct {
+	repo repository.UTMTemplateRepository
+}
+
+func NewUTMService(repo repository.UTMTemplateRepository) UTMService {
+	return &UTMServiceImpl{repo: repo}
+}
+
+func (s *UTMServiceImpl) CreateTemplate(ctx context.Context, template *models.UTMTemplate) error {
+	template.Name = strings.TrimSpace(template.Name)
+	if template.Name == "" || template.Source == "" {
+		return ErrInvalidUTMTemplate
+	}
+
+	_, err := s.repo.FindByName(ctx, template.WorkspaceID, template.Name)
+	if err == nil {
+		return ErrUTMTemplateExists
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	return s.repo.Create(ctx, template)
+}
+
+func (s *UTMServiceImpl) ListTemplates(ctx context.Context, workspaceID uint) ([]models.UTMTemplate, error) {
+	return s.repo.List(ctx, workspaceID)
+}
+
+func (s *UTMServiceImpl) DeleteTemplate(ctx context.Context, workspaceID, id uint) error {
+	err := s.repo.Delete(ctx, workspaceID, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrUTMTemplateNotFound
+	}
+	return err
+}
+
+// tagURL appends the template's UTM parameters to target's query. Parameters
+// the URL already has are left as they are.
+func tagURL(target *url.URL, template *models.UTMTemplate) {
+	existing, _ := url.ParseQuery(target.RawQuery)
+
+	var pairs []string
+	if target.RawQuery != "" {
+		pairs = append(pairs, target.RawQuery)
+	}
+	for _, param := range template.Params() {
+		if existing.Has(param[0]) {
+			continue
+		}
+		pairs = append(pairs, param[0]+"="+url.QueryEscape(param[1]))
+	}
+	target.RawQuery = strings.Join(pairs, "&")
+}
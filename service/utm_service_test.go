@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"urlshortner/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type MockUTMTemplateRepository struct {
+	mock.Mock
+}
+
+func (m *MockUTMTemplateRepository) Create(ctx context.Context, template *models.UTMTemplate) error {
+	args := m.Called(template)
+	return args.Error(0)
+}
+
+func (m *MockUTMTemplateRepository) FindByName(ctx context.Context, workspaceID uint, name string) (*models.UTMTemplate, error) {
+	args := m.Called(workspaceID, name)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.UTMTemplate), args.Error(1)
+}
+
+func (m *MockUTMTemplateRepository) List(ctx context.Context, workspaceID uint) ([]models.UTMTemplate, error) {
+	args := m.Called(workspaceID)
+	return args.Get(0).([]models.UTMTemplate), args.Error(1)
+}
+
+func (m *MockUTMTemplateRepository) Delete(ctx context.Context, workspaceID, id uint) error {
+	args := m.Called(workspaceID, id)
+	return args.Error(0)
+}
+
+func TestCreateTemplate(t *testing.T) {
+	tests := []struct {
+		name        string
+		template    models.UTMTemplate
+		setupMock   func(*MockUTMTemplateRepository)
+		expectErrIs error
+	}{
+		{
+			name:     "Created",
+			template: models.UTMTemplate{WorkspaceID: 1, Name: " newsletter ", Source: "newsletter", Medium: "email"},
+			setupMock: func(m *MockUTMTemplateRepository) {
+				m.On("FindByName", uint(1), "newsletter").Return(nil, gorm.ErrRecordNotFound)
+				m.On("Create", mock.Anything).Return(nil)
+			},
+		},
+		{
+			name:     "Name taken in the workspace",
+			template: models.UTMTemplate{WorkspaceID: 1, Name: "newsletter", Source: "newsletter"},
+			setupMock: func(m *MockUTMTemplateRepository) {
+				m.On("FindByName", uint(1), "newsletter").Return(&models.UTMTemplate{ID: 4}, nil)
+			},
+			expectErrIs: ErrUTMTemplateExists,
+		},
+		{
+			name:        "Source is required",
+			template:    models.UTMTemplate{WorkspaceID: 1, Name: "newsletter", Medium: "email"},
+			setupMock:   func(m *MockUTMTemplateRepository) {},
+			expectErrIs: ErrInvalidUTMTemplate,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := new(MockUTMTemplateRepository)
+			tt.setupMock(repo)
+			service := NewUTMService(repo)
+
+			template := tt.template
+			err := service.CreateTemplate(context.Background(), &template)
+			if tt.expectErrIs != nil {
+				assert.ErrorIs(t, err, tt.expectErrIs)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "newsletter", template.Name)
+			}
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDeleteTemplate(t *testing.T) {
+	repo := new(MockUTMTemplateRepository)
+	repo.On("Delete", uint(1), uint(4)).Return(gorm.ErrRecordNotFound)
+	repo.On("Delete", uint(1), uint(5)).Return(errors.New("database error"))
+
+	service := NewUTMService(repo)
+	assert.ErrorIs(t, service.DeleteTemplate(context.Background(), 1, 4), ErrUTMTemplateNotFound)
+	assert.EqualError(t, service.DeleteTemplate(context.Background(), 1, 5), "database error")
+}
+
+func TestTagURL(t *testing.T) {
+	template := &models.UTMTemplate{Source: "newsletter", Medium: "email", Campaign: "spring sale & more"}
+
+	tests := []struct {
+		url       string
+		expectURL string
+	}{
+		{
+			url:       "https://example.com/shop",
+			expectURL: "https://example.com/shop?utm_source=newsletter&utm_medium=email&utm_campaign=spring+sale+%26+more",
+		},
+		{
+			url:       "https://example.com/shop?id=7&sort=price%20asc#reviews",
+			expectURL: "https://example.com/shop?id=7&sort=price%20asc&utm_source=newsletter&utm_medium=email&utm_campaign=spring+sale+%26+more#reviews",
+		},
+		{
+			url:       "https://example.com/shop?utm_source=partner",
+			expectURL: "https://example.com/shop?utm_source=partner&utm_medium=email&utm_campaign=spring+sale+%26+more",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			target, err := url.Parse(tt.url)
+			require.NoError(t, err)
+			tagURL(target, template)
+			assert.Equal(t, tt.expectURL, target.String())
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"urlshortner/models"
+	"urlshortner/repository"
+)
+
+var (
+	ErrInvalidRole     = errors.New("invalid role")
+	ErrInvalidFallback = errors.New("invalid fallback")
+)
+
+type WorkspaceService interface {
+	CreateWorkspace(ctx context.Context, name string) (*models.Workspace, error)
+	GetWorkspace(ctx context.Context, id uint) (*models.Workspace, error)
+	ListWorkspaces(ctx context.Context) ([]models.Workspace, error)
+	// SetFallback sets where visitors of the workspace's dead links go: a
+	// URL or an HTML page, not both. Empty values restore the default.
+	SetFallback(ctx context.Context, workspaceID uint, fallbackURL, page string) error
+	SetMember(ctx context.Context, workspaceID, userID uint, role models.Role) error
+	ListMembers(ctx context.Context, workspaceID uint) ([]models.WorkspaceMember, error)
+	MemberRole(ctx context.Context, workspaceID, userID uint) (models.Role, error)
+}
+
+type WorkspaceServiceImpl struct {
+	repo  repository.WorkspaceRepository
+	users repository.UserRepository
+}
+
+func NewWorkspaceService(repo repository.WorkspaceRepository, users repository.UserRepository) WorkspaceService {
+	return &WorkspaceServiceImpl{
+		repo:  repo,
+		users: users,
+	}
+}
+
+func (s *WorkspaceServiceImpl) CreateWorkspace(ctx context.Context, name string) (*models.Workspace, error) {
+	if name == "" {
+		return nil, errors.New("workspace name is required")
+	}
+	workspace := &models.Workspace{Name: name}
+	if err := s.repo.Create(ctx, workspace); err != nil {
+		return nil, err
+	}
+	return workspace, nil
+}
+
+func (s *WorkspaceServiceImpl) GetWorkspace(ctx context.Context, id uint) (*models.Workspace, error) {
+	return s.repo.FindByID(ctx, id)
+}
+
+func (s *WorkspaceServiceImpl) ListWorkspaces(ctx context.Context) ([]models.Workspace, error) {
+	return s.repo.List(ctx)
+}
+
+func (s *WorkspaceServiceImpl) SetFallback(ctx context.Context, workspaceID uint, fallbackURL, page string) error {
+	if fallbackURL != "" && page != "" {
+		return fmt.Errorf("%w: set a URL or a page, not both", ErrInvalidFallback)
+	}
+	if fallbackURL != "" && !validDestination(fallbackURL) {
+		return fmt.Errorf("%w: %q is not an http(s) URL", ErrInvalidFallback, fallbackURL)
+	}
+	workspace, err := s.repo.FindByID(ctx, workspaceID)
+	if err != nil {
+		return fmt.Errorf("workspace %d: %w", workspaceID, err)
+	}
+
+	workspace.FallbackURL = fallbackURL
+	workspace.FallbackPage = page
+	return s.repo.Update(ctx, workspace)
+}
+
+func (s *WorkspaceServiceImpl) SetMember(ctx context.Context, workspaceID, userID uint, role models.Role) error {
+	if !role.Valid() {
+		return fmt.Errorf("%w: %q", ErrInvalidRole, role)
+	}
+	if _, err := s.repo.FindByID(ctx, workspaceID); err != nil {
+		return fmt.Errorf("workspace %d: %w", workspaceID, err)
+	}
+	if _, err := s.users.FindByID(ctx, userID); err != nil {
+		return fmt.Errorf("user %d: %w", userID, err)
+	}
+
+	return s.repo.SaveMember(ctx, &models.WorkspaceMember{
+		WorkspaceID: workspaceID,
+		UserID:      userID,
+		Role:        role,
+	})
+}
+
+func (s *WorkspaceServiceImpl) ListMembers(ctx context.Context, workspaceID uint) ([]models.WorkspaceMember, error) {
+	return s.repo.ListMembers(ctx, workspaceID)
+}
+
+func (s *WorkspaceServiceImpl) MemberRole(ctx context.Context, workspaceID, userID uint) (models.Role, error) {
+	return s.repo.MemberRole(ctx, workspaceID, userID)
+}
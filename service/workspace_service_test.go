@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"urlshortner/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+type MockWorkspaceRepository struct {
+	mock.Mock
+}
+
+func (m *MockWorkspaceRepository) Create(ctx context.Context, workspace *models.Workspace) error {
+	args := m.Called(workspace)
+	return args.Error(0)
+}
+
+func (m *MockWorkspaceRepository) FindByID(ctx context.Context, id uint) (*models.Workspace, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Workspace), args.Error(1)
+}
+
+func (m *MockWorkspaceRepository) List(ctx context.Context) ([]models.Workspace, error) {
+	args := m.Called()
+	return args.Get(0).([]models.Workspace), args.Error(1)
+}
+
+func (m *MockWorkspaceRepository) Update(ctx context.Context, workspace *models.Workspace) error {
+	args := m.Called(workspace)
+	return args.Error(0)
+}
+
+func (m *MockWorkspaceRepository) SaveMember(ctx context.Context, member *models.WorkspaceMember) error {
+	args := m.Called(member)
+	return args.Error(0)
+}
+
+func (m *MockWorkspaceRepository) ListMembers(ctx context.Context, workspaceID uint) ([]models.WorkspaceMember, error) {
+	args := m.Called(workspaceID)
+	return args.Get(0).([]models.WorkspaceMember), args.Error(1)
+}
+
+func (m *MockWorkspaceRepository) MemberRole(ctx context.Context, workspaceID, userID uint) (models.Role, error) {
+	args := m.Called(workspaceID, userID)
+	return args.Get(0).(models.Role), args.Error(1)
+}
+
+type MockUserRepository struct {
+	mock.Mock
+}
+
+func (m *MockUserRepository) Create(ctx context.Context, user *models.User) error {
+	args := m.Called(user)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) FindByID(ctx context.Context, id uint) (*models.User, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockUserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	args := m.Called(email)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockUserRepository) List(ctx context.Context) ([]models.User, error) {
+	args := m.Called()
+	return args.Get(0).([]models.User), args.Error(1)
+}
+
+func TestSetMember(t *testing.T) {
+	tests := []struct {
+		name        string
+		role        models.Role
+		setupMock   func(*MockWorkspaceRepository, *MockUserRepository)
+		expectError bool
+	}{
+		{
+			name: "Adds member",
+			role: models.RoleEditor,
+			setupMock: func(w *MockWorkspaceRepository, u *MockUserRepository) {
+				w.On("FindByID", uint(1)).Return(&models.Workspace{ID: 1}, nil)
+				u.On("FindByID", uint(2)).Return(&models.User{ID: 2}, nil)
+				w.On("SaveMember", &models.WorkspaceMember{WorkspaceID: 1, UserID: 2, Role: models.RoleEditor}).Return(nil)
+			},
+		},
+		{
+			name:        "Unknown role",
+			role:        "owner",
+			setupMock:   func(w *MockWorkspaceRepository, u *MockUserRepository) {},
+			expectError: true,
+		},
+		{
+			name: "Unknown user",
+			role: models.RoleViewer,
+			setupMock: func(w *MockWorkspaceRepository, u *MockUserRepository) {
+				w.On("FindByID", uint(1)).Return(&models.Workspace{ID: 1}, nil)
+				u.On("FindByID", uint(2)).Return(nil, gorm.ErrRecordNotFound)
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workspaceRepo := new(MockWorkspaceRepository)
+			userRepo := new(MockUserRepository)
+			tt.setupMock(workspaceRepo, userRepo)
+			service := NewWorkspaceService(workspaceRepo, userRepo)
+
+			err := service.SetMember(context.Background(), 1, 2, tt.role)
+
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			workspaceRepo.AssertExpectations(t)
+			userRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSetFallback(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		page        string
+		setupMock   func(*MockWorkspaceRepository)
+		expectError error
+	}{
+		{
+			name: "Sets fallback URL",
+			url:  "https://example.com/gone",
+			setupMock: func(w *MockWorkspaceRepository) {
+				w.On("FindByID", uint(1)).Return(&models.Workspace{ID: 1, FallbackPage: "<p>old</p>"}, nil)
+				w.On("Update", &models.Workspace{ID: 1, FallbackURL: "https://example.com/gone"}).Return(nil)
+			},
+		},
+		{
+			name:        "URL and page together",
+			url:         "https://example.com/gone",
+			page:        "<p>Gone</p>",
+			setupMock:   func(w *MockWorkspaceRepository) {},
+			expectError: ErrInvalidFallback,
+		},
+		{
+			name:        "Not an http URL",
+			url:         "javascript:alert(1)",
+			setupMock:   func(w *MockWorkspaceRepository) {},
+			expectError: ErrInvalidFallback,
+		},
+		{
+			name: "Unknown workspace",
+			page: "<p>Gone</p>",
+			setupMock: func(w *MockWorkspaceRepository) {
+				w.On("FindByID", uint(1)).Return(nil, gorm.ErrRecordNotFound)
+			},
+			expectError: gorm.ErrRecordNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workspaceRepo := new(MockWorkspaceRepository)
+			tt.setupMock(workspaceRepo)
+			service := NewWorkspaceService(workspaceRepo, new(MockUserRepository))
+
+			err := service.SetFallback(context.Background(), 1, tt.url, tt.page)
+
+			if tt.expectError != nil {
+				assert.ErrorIs(t, err, tt.expectError)
+			} else {
+				assert.NoError(t, err)
+			}
+			workspaceRepo.AssertExpectations(t)
+		})
+	}
+}
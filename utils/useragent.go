@@ -0,0 +1,44 @@
+package utils
+
+import "strings"
+
+// Operating systems recognised by ParseUserAgent.
+const (
+	OSiOS      = "ios"
+	OSAndroid  = "android"
+	OSWindows  = "windows"
+	OSMacOS    = "macos"
+	OSLinux    = "linux"
+	OSChromeOS = "chromeos"
+)
+
+// Device describes the client behind a User-Agent header.
+type Device struct {
+	// OS is one of the OS constants, or empty if it could not be told.
+	OS     string
+	Mobile bool
+}
+
+// ParseUserAgent makes a best-effort guess at the device sending ua. It only
+// looks for the tokens browsers have used for years, so it errs towards an
+// unknown OS rather than a wrong one.
+func ParseUserAgent(ua string) Device {
+	switch {
+	// Windows Phone claims to be Android and iOS as well
+	case strings.Contains(ua, "Windows Phone"):
+		return Device{OS: OSWindows, Mobile: true}
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"), strings.Contains(ua, "iPod"):
+		return Device{OS: OSiOS, Mobile: true}
+	case strings.Contains(ua, "Android"):
+		return Device{OS: OSAndroid, Mobile: true}
+	case strings.Contains(ua, "CrOS"):
+		return Device{OS: OSChromeOS}
+	case strings.Contains(ua, "Macintosh"), strings.Contains(ua, "Mac OS X"):
+		return Device{OS: OSMacOS}
+	case strings.Contains(ua, "Windows"):
+		return Device{OS: OSWindows}
+	case strings.Contains(ua, "Linux"), strings.Contains(ua, "X11"):
+		return Device{OS: OSLinux}
+	}
+	return Device{Mobile: strings.Contains(ua, "Mobile")}
+}